@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/kdeps/kdeps/pkg/archiver"
+	"github.com/kdeps/kdeps/pkg/environment"
+	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/resolver"
+	"github.com/kdeps/kdeps/pkg/utils"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// NewPlanCommand creates the 'plan' command and passes the necessary dependencies.
+func NewPlanCommand(fs afero.Fs, ctx context.Context, kdepsDir string, env *environment.Environment, logger *logging.Logger) *cobra.Command {
+	var (
+		actionID   string
+		path       string
+		method     string
+		data       string
+		params     []string
+		headers    []string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:     "plan [package]",
+		Example: "$ kdeps plan ./myAgent.kdeps --path /api/v1/items --method POST --data '{\"q\": 1}'",
+		Short:   "Show the execution plan of an AI agent for a sample request",
+		Long: `Show the resources an AI agent would process for a sample request, in execution order,
+together with their skip and preflight decisions and the run blocks they would execute.
+No exec, python, LLM or HTTP step is run.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sections, err := sampleRequestSections(path, method, data, params, headers)
+			if err != nil {
+				return err
+			}
+
+			plan, err := planPackage(fs, ctx, kdepsDir, env, args[0], actionID, sections, logger)
+			if err != nil {
+				return fmt.Errorf("%s: %w", errorStyle.Render("Error planning agent"), err)
+			}
+
+			if jsonOutput {
+				out, err := json.MarshalIndent(plan, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+				return nil
+			}
+
+			printPlan(plan)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&actionID, "action", "a", "", "The actionID to plan. Defaults to the targetActionID of the workflow")
+	cmd.Flags().StringVar(&path, "path", "/", "The path of the sample request")
+	cmd.Flags().StringVarP(&method, "method", "X", "GET", "The HTTP method of the sample request")
+	cmd.Flags().StringVarP(&data, "data", "d", "", "The body of the sample request")
+	cmd.Flags().StringArrayVarP(&params, "param", "p", nil, "A query parameter of the sample request, as name=value")
+	cmd.Flags().StringArrayVarP(&headers, "header", "H", nil, "A header of the sample request, as name=value")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the plan as JSON")
+
+	return cmd
+}
+
+// planPackage extracts the agent package into a temporary agent directory and plans actionID
+// against the sample request.
+func planPackage(fs afero.Fs, ctx context.Context, kdepsDir string, env *environment.Environment, pkgFile, actionID string, sections []string, logger *logging.Logger) ([]resolver.PlanStep, error) {
+	pkgProject, err := archiver.ExtractPackage(fs, ctx, kdepsDir, pkgFile, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	agentDir, err := afero.TempDir(fs, "", "kdeps-plan")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary agent directory: %w", err)
+	}
+	defer func() {
+		if err := fs.RemoveAll(agentDir); err != nil {
+			logger.Warn("failed to clean up temporary agent directory", "path", agentDir, "error", err)
+		}
+	}()
+
+	projectDir := filepath.Dir(pkgProject.Workflow)
+	if err := archiver.CopyDir(fs, ctx, projectDir, filepath.Join(agentDir, "project"), logger); err != nil {
+		return nil, fmt.Errorf("failed to copy agent project: %w", err)
+	}
+	if err := archiver.CopyFile(fs, ctx, pkgProject.Workflow, filepath.Join(agentDir, "workflow", "workflow.pkl"), logger); err != nil {
+		return nil, fmt.Errorf("failed to copy workflow: %w", err)
+	}
+
+	dr, err := resolver.NewGraphResolver(fs, ctx, env, agentDir, filepath.Join(agentDir, "action"), "plan", logger)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Evaluator.Close()
+
+	if err := dr.CreateRequestModule(sections); err != nil {
+		return nil, fmt.Errorf("failed to create sample request: %w", err)
+	}
+	if err := dr.PrepareResources(); err != nil {
+		return nil, err
+	}
+
+	return dr.Plan(ctx, actionID)
+}
+
+// sampleRequestSections formats the sample request like the API server formats incoming requests.
+func sampleRequestSections(path, method, data string, params, headers []string) ([]string, error) {
+	paramValues := url.Values{}
+	for _, param := range params {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid parameter %q, expected name=value", param)
+		}
+		paramValues.Add(name, value)
+	}
+
+	headerValues := make(map[string][]string)
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, expected name=value", header)
+		}
+		headerValues[name] = append(headerValues[name], value)
+	}
+
+	return []string{
+		fmt.Sprintf(`path = %q`, path),
+		`IP = "127.0.0.1"`,
+		`ID = "plan"`,
+		fmt.Sprintf(`method = %q`, strings.ToUpper(method)),
+		utils.FormatRequestHeaders(headerValues),
+		"data = " + utils.PklString(data),
+		utils.FormatRequestParams(paramValues),
+		"files {\n}",
+	}, nil
+}
+
+// printPlan prints the plan, one resource per line.
+func printPlan(plan []resolver.PlanStep) {
+	for i, step := range plan {
+		var decision string
+		switch {
+		case step.Skipped:
+			decision = "skipped"
+		case step.PreflightFailed:
+			decision = errorStyle.Render("preflight check fails (" + step.PreflightError + ")")
+		case len(step.Steps) == 0:
+			decision = "nothing to run"
+		default:
+			decision = successStyle.Render(strings.Join(step.Steps, ", "))
+			if step.ForEachItems != nil {
+				decision += fmt.Sprintf(" (for each of %d items)", *step.ForEachItems)
+			}
+		}
+
+		fmt.Printf("%d. [level %d] %s: %s\n", i+1, step.Level, primaryStyle.Render(step.ActionID), decision)
+		if len(step.Requires) > 0 {
+			fmt.Printf("   requires: %s\n", strings.Join(step.Requires, ", "))
+		}
+	}
+}
@@ -30,6 +30,8 @@ variable to prevent errors caused by rate limit exhaustion.`)
 	rootCmd.AddCommand(NewPackageCommand(fs, ctx, kdepsDir, env, logger))
 	rootCmd.AddCommand(NewBuildCommand(fs, ctx, kdepsDir, systemCfg, logger))
 	rootCmd.AddCommand(NewRunCommand(fs, ctx, kdepsDir, systemCfg, logger))
+	rootCmd.AddCommand(NewPlanCommand(fs, ctx, kdepsDir, env, logger))
+	rootCmd.AddCommand(NewSecretsCommand(fs, env))
 
 	return rootCmd
 }
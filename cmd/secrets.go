@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kdeps/kdeps/pkg/environment"
+	"github.com/kdeps/kdeps/pkg/secrets"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// NewSecretsCommand creates the 'secrets' command, which manages an encrypted secret store.
+func NewSecretsCommand(fs afero.Fs, env *environment.Environment) *cobra.Command {
+	var store string
+
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the encrypted secret store of an AI agent",
+		Long: `Manage an encrypted secret store, which AI agents read at run time. The store is encrypted
+with the passphrase in the KDEPS_SECRETS_KEY environment variable. Mount it at
+/agent/secrets.enc and pass the same KDEPS_SECRETS_KEY when running the agent.`,
+	}
+	cmd.PersistentFlags().StringVarP(&store, "store", "s", "secrets.enc", "The secret store file")
+
+	loadStore := func() (map[string]string, error) {
+		if env.SecretsKey == "" {
+			return nil, errors.New("KDEPS_SECRETS_KEY is not set")
+		}
+		if exists, _ := afero.Exists(fs, store); !exists {
+			return map[string]string{}, nil
+		}
+		return secrets.ReadStore(fs, store, env.SecretsKey)
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:     "set [name]",
+		Example: "$ echo -n \"$API_KEY\" | kdeps secrets set API_KEY",
+		Short:   "Store a secret read from stdin",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values, err := loadStore()
+			if err != nil {
+				return fmt.Errorf("%s: %w", errorStyle.Render("Error reading secret store"), err)
+			}
+			value, err := io.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return fmt.Errorf("%s: %w", errorStyle.Render("Error reading secret"), err)
+			}
+			values[args[0]] = strings.TrimRight(string(value), "\r\n")
+			if err := secrets.WriteStore(fs, store, env.SecretsKey, values); err != nil {
+				return fmt.Errorf("%s: %w", errorStyle.Render("Error writing secret store"), err)
+			}
+			fmt.Println(successStyle.Render("Secret stored:"), args[0])
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rm [name]",
+		Short: "Remove a secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values, err := loadStore()
+			if err != nil {
+				return fmt.Errorf("%s: %w", errorStyle.Render("Error reading secret store"), err)
+			}
+			delete(values, args[0])
+			if err := secrets.WriteStore(fs, store, env.SecretsKey, values); err != nil {
+				return fmt.Errorf("%s: %w", errorStyle.Render("Error writing secret store"), err)
+			}
+			fmt.Println(successStyle.Render("Secret removed:"), args[0])
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the names of the stored secrets",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values, err := loadStore()
+			if err != nil {
+				return fmt.Errorf("%s: %w", errorStyle.Render("Error reading secret store"), err)
+			}
+			for _, name := range secrets.New(values).Names() {
+				fmt.Println(name)
+			}
+			return nil
+		},
+	})
+
+	return cmd
+}
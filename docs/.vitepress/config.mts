@@ -85,6 +85,10 @@ export default defineConfig({
               },
             ],
           },
+          {
+            text: "Secrets",
+            link: "/getting-started/configuration/secrets",
+          },
         ],
       },
       {
@@ -178,6 +182,11 @@ export default defineConfig({
             text: "Preflight Validations",
             link: "/getting-started/resources/validations",
           },
+          { text: "Retry Policy", link: "/getting-started/resources/retry" },
+          { text: "Output Cache", link: "/getting-started/resources/cache" },
+          { text: "Iterating with forEach", link: "/getting-started/resources/foreach" },
+          { text: "Error Handlers", link: "/getting-started/resources/onerror" },
+          { text: "Sandbox Limits", link: "/getting-started/resources/sandbox" },
           { text: "Data Folder", link: "/getting-started/resources/data" },
           { text: "File Uploads", link: "/getting-started/tutorials/files" },
           {
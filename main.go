@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/kdeps/kdeps/cmd"
@@ -15,6 +16,8 @@ import (
 	"github.com/kdeps/kdeps/pkg/environment"
 	"github.com/kdeps/kdeps/pkg/logging"
 	"github.com/kdeps/kdeps/pkg/resolver"
+	"github.com/kdeps/kdeps/pkg/secrets"
+	"github.com/kdeps/kdeps/pkg/telemetry"
 	"github.com/kdeps/kdeps/pkg/utils"
 	v "github.com/kdeps/kdeps/pkg/version"
 	"github.com/spf13/afero"
@@ -67,6 +70,26 @@ func main() {
 }
 
 func handleDockerMode(ctx context.Context, dr *resolver.DependencyResolver, cancel context.CancelFunc) {
+	// Load the secrets before anything can log or store their values
+	store, missing, err := secrets.Load(dr.Fs, dr.Environment, dr.Settings.Secrets)
+	if err != nil {
+		dr.Logger.Error("failed to load secrets", "error", err)
+		utils.SendSigterm(dr.Logger)
+		return
+	}
+	if len(missing) > 0 {
+		dr.Logger.Warn("secrets without a value, steps referencing them will fail", "secrets", missing)
+	}
+	dr.Secrets = store
+	logging.SetRedactor(store.Redact)
+
+	// Initialize tracing before any request is served
+	shutdownTracing, err := telemetry.Setup(ctx, dr.Fs, dr.Settings.Telemetry, dr.Workflow.GetName(), dr.Workflow.GetVersion())
+	if err != nil {
+		dr.Logger.Error("failed to set up tracing", "error", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	}
+
 	// Initialize Docker system
 	apiServerMode, err := docker.BootstrapDockerSystem(ctx, dr)
 	if err != nil {
@@ -89,6 +112,14 @@ func handleDockerMode(ctx context.Context, dr *resolver.DependencyResolver, canc
 	// Wait for shutdown signal
 	<-ctx.Done()
 	dr.Logger.Debug("context canceled, shutting down gracefully...")
+	if err := dr.Evaluator.Close(); err != nil {
+		dr.Logger.Warn("failed to close pkl evaluator", "error", err)
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		dr.Logger.Warn("failed to flush traces", "error", err)
+	}
 	cleanup(dr.Fs, ctx, dr.Environment, apiServerMode, dr.Logger)
 }
 
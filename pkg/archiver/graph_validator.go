@@ -0,0 +1,178 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/resource"
+	pklWf "github.com/kdeps/schema/gen/workflow"
+	"github.com/spf13/afero"
+)
+
+var (
+	quotedValuePattern  = regexp.MustCompile(`"([^"]*)"`)
+	onErrorPattern      = regexp.MustCompile(`onError\s*{[^}]*?\bhandler\s*=\s*"([^"]*)"`)
+	errorHandlerPattern = regexp.MustCompile(`(?m)^\s*errorHandler\s*=\s*"([^"]*)"`)
+)
+
+// ValidateResourceGraph checks the dependency graph of the compiled project. The project resources
+// are compiled again in memory, so that duplicate actionIDs are detected before their compiled
+// files overwrite each other, and the resources copied from external workflows are read from the
+// compiled resources directory. The target actions are read from the compiled workflow, which
+// includes the targetActionID of its API routes, and the errorHandler setting. The onError handlers
+// and the errorHandler are compiled here, since the resources reference them by their short
+// actionID.
+func ValidateResourceGraph(fs afero.Fs, ctx context.Context, wf pklWf.Workflow, projectDir, compiledProjectDir string, logger *logging.Logger) error {
+	resourcesDir := filepath.Join(compiledProjectDir, "resources")
+	var nodes []resource.GraphNode
+	projectActionIDs := make(map[string]bool)
+
+	err := afero.Walk(fs, filepath.Join(projectDir, "resources"), func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(file) != ".pkl" {
+			return err
+		}
+
+		content, _, err := processFileContent(fs, file, wf, logger)
+		if err != nil {
+			return err
+		}
+
+		node := parseGraphNode(content.String(), file)
+		if node.ActionID == "" {
+			return nil
+		}
+		node.Handler = compileActionID(node.Handler, wf)
+		projectActionIDs[node.ActionID] = true
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read project resources: %w", err)
+	}
+
+	compiledFiles, err := collectPklFiles(fs, resourcesDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range compiledFiles {
+		content, err := afero.ReadFile(fs, file)
+		if err != nil {
+			return fmt.Errorf("failed to read compiled resource %s: %w", file, err)
+		}
+
+		node := parseGraphNode(string(content), file)
+		if node.ActionID == "" || projectActionIDs[node.ActionID] {
+			continue
+		}
+		node.Handler = compileActionID(node.Handler, wf)
+		nodes = append(nodes, node)
+	}
+
+	workflowFile := filepath.Join(compiledProjectDir, "workflow.pkl")
+	targets, err := compiledTargetActionIDs(fs, workflowFile, wf)
+	if err != nil {
+		return err
+	}
+	errorHandler, err := compiledErrorHandler(fs, workflowFile, wf)
+	if err != nil {
+		return err
+	}
+
+	warnings, err := resource.ValidateGraph(nodes, errorHandler, targets...)
+	if err != nil {
+		logger.Error("invalid resource graph", "error", err)
+		return fmt.Errorf("invalid resource graph: %w", err)
+	}
+	for _, warning := range warnings {
+		logger.Warn(warning)
+	}
+
+	return nil
+}
+
+// parseGraphNode reads the actionID, the requires block and the onError handler of a compiled
+// resource file.
+func parseGraphNode(content, file string) resource.GraphNode {
+	node := resource.GraphNode{File: file}
+	if match := onErrorPattern.FindStringSubmatch(content); match != nil {
+		node.Handler = match[1]
+	}
+	inRequiresBlock := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !inRequiresBlock {
+			if idMatch := idPattern.FindStringSubmatch(line); idMatch != nil && node.ActionID == "" {
+				node.ActionID = idMatch[1]
+				continue
+			}
+			if !requiresPattern.MatchString(line) {
+				continue
+			}
+			inRequiresBlock = true
+			line = line[strings.Index(line, "{")+1:]
+		}
+
+		block, _, closed := strings.Cut(line, "}")
+		for _, match := range quotedValuePattern.FindAllStringSubmatch(block, -1) {
+			if match[1] != "" {
+				node.Requires = append(node.Requires, match[1])
+			}
+		}
+		if closed {
+			inRequiresBlock = false
+		}
+	}
+
+	return node
+}
+
+// compiledTargetActionIDs returns the targetActionID of the compiled workflow followed by the
+// targetActionID of its API routes.
+func compiledTargetActionIDs(fs afero.Fs, workflowFile string, wf pklWf.Workflow) ([]string, error) {
+	content, err := afero.ReadFile(fs, workflowFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compiled workflow: %w", err)
+	}
+
+	targets := []string{wf.GetTargetActionID()}
+	for _, match := range targetActionIDPattern.FindAllStringSubmatch(string(content), -1) {
+		if match[1] != wf.GetTargetActionID() {
+			targets = append(targets, match[1])
+		}
+	}
+	return targets, nil
+}
+
+// compiledErrorHandler returns the compiled errorHandler setting of the compiled workflow, or an
+// empty string when it is not set.
+func compiledErrorHandler(fs afero.Fs, workflowFile string, wf pklWf.Workflow) (string, error) {
+	content, err := afero.ReadFile(fs, workflowFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read compiled workflow: %w", err)
+	}
+
+	match := errorHandlerPattern.FindStringSubmatch(string(content))
+	if match == nil {
+		return "", nil
+	}
+	return compileActionID(match[1], wf), nil
+}
+
+// compileActionID returns the actionID a resource of the workflow references by its short form,
+// such as fetch, in its compiled form, such as @agent/fetch:1.0.0.
+func compileActionID(actionID string, wf pklWf.Workflow) string {
+	actionID = strings.TrimSpace(actionID)
+	if actionID == "" || strings.HasPrefix(actionID, "@") {
+		return actionID
+	}
+	return fmt.Sprintf("@%s/%s:%s", wf.GetName(), actionID, wf.GetVersion())
+}
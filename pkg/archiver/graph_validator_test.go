@@ -0,0 +1,84 @@
+package archiver
+
+import (
+	"testing"
+
+	pklWf "github.com/kdeps/schema/gen/workflow"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGraphNode(t *testing.T) {
+	t.Parallel()
+
+	content := `amends "package://schema.kdeps.com/core@0.2.7#/Resource.pkl"
+
+actionID = "@agent/response:1.0.0"
+requires {
+"@agent/llm:1.0.0"
+""
+"@other/fetch:2.0.0"
+}
+run {
+  exec { command = "echo \"done\"" }
+}
+`
+
+	node := parseGraphNode(content, "response.pkl")
+	assert.Equal(t, "@agent/response:1.0.0", node.ActionID)
+	assert.Equal(t, []string{"@agent/llm:1.0.0", "@other/fetch:2.0.0"}, node.Requires)
+
+	assert.Empty(t, node.Handler)
+
+	node = parseGraphNode("actionID = \"@agent/fetch:1.0.0\"\nrequires { \"@agent/input:1.0.0\" }\n", "fetch.pkl")
+	assert.Equal(t, []string{"@agent/input:1.0.0"}, node.Requires)
+
+	node = parseGraphNode("actionID = \"@agent/fetch:1.0.0\"\nrun {\n  onError {\n    continueOnError = true\n    handler = \"fallback\"\n  }\n}\n", "fetch.pkl")
+	assert.Equal(t, "fallback", node.Handler)
+}
+
+func TestProcessActionPatternsResults(t *testing.T) {
+	t.Parallel()
+
+	line := processActionPatterns(`local descriptions = item.results("describeImages")`, "agent", "1.0.0")
+	assert.Equal(t, `local descriptions = item.results("@agent/describeImages:1.0.0")`, line)
+}
+
+func TestCompiledTargetActionIDs(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	content := `targetActionID = "@agent/chat:1.0.0"
+settings {
+  APIServer {
+    routes {
+      new { path = "/chat"; methods { "POST" } }
+      new { path = "/summarize"; methods { "POST" }; targetActionID = "@agent/summarize:1.0.0" }
+    }
+  }
+}
+`
+	require.NoError(t, afero.WriteFile(fs, "/agent/workflow.pkl", []byte(content), 0o644))
+
+	targets, err := compiledTargetActionIDs(fs, "/agent/workflow.pkl", &pklWf.WorkflowImpl{TargetActionID: "@agent/chat:1.0.0"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@agent/chat:1.0.0", "@agent/summarize:1.0.0"}, targets)
+}
+
+func TestCompiledErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	wf := &pklWf.WorkflowImpl{Name: "agent", Version: "1.0.0", TargetActionID: "@agent/chat:1.0.0"}
+	require.NoError(t, afero.WriteFile(fs, "/agent/workflow.pkl", []byte("targetActionID = \"@agent/chat:1.0.0\"\n"), 0o644))
+
+	handler, err := compiledErrorHandler(fs, "/agent/workflow.pkl", wf)
+	require.NoError(t, err)
+	assert.Empty(t, handler)
+
+	require.NoError(t, afero.WriteFile(fs, "/agent/workflow.pkl", []byte("targetActionID = \"@agent/chat:1.0.0\"\nerrorHandler = \"errorResponse\"\n"), 0o644))
+	handler, err = compiledErrorHandler(fs, "/agent/workflow.pkl", wf)
+	require.NoError(t, err)
+	assert.Equal(t, "@agent/errorResponse:1.0.0", handler)
+}
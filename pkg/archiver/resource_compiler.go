@@ -18,7 +18,7 @@ import (
 
 var (
 	idPattern       = regexp.MustCompile(`(?i)^\s*actionID\s*=\s*"(.+)"`)
-	actionIDRegex   = regexp.MustCompile(`(?i)\b(resources|resource|responseBody|responseHeader|stderr|stdout|env|response|prompt|exitCode|file)\s*\(\s*"([^"]+)"\s*(?:,\s*"([^"]+)")?\s*\)`)
+	actionIDRegex   = regexp.MustCompile(`(?i)\b(resources|resource|responseBody|responseHeader|stderr|stdout|env|response|prompt|exitCode|file|results|attempts|cached)\s*\(\s*"([^"]+)"\s*(?:,\s*"([^"]+)")?\s*\)`)
 	requiresPattern = regexp.MustCompile(`^\s*requires\s*{`)
 )
 
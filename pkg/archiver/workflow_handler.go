@@ -21,6 +21,8 @@ import (
 	"github.com/spf13/afero"
 )
 
+var targetActionIDPattern = regexp.MustCompile(`targetActionID\s*=\s*"([^"]*)"`)
+
 func PrepareRunDir(fs afero.Fs, ctx context.Context, wf pklWf.Workflow, kdepsDir, pkgFilePath string, logger *logging.Logger) (string, error) {
 	agentName, agentVersion := wf.GetName(), wf.GetVersion()
 	runDir := filepath.Join(kdepsDir, "run/"+agentName+"/"+agentVersion+"/workflow")
@@ -145,8 +147,17 @@ func CompileWorkflow(fs afero.Fs, ctx context.Context, wf pklWf.Workflow, kdepsD
 		return "", err
 	}
 
-	re := regexp.MustCompile(`targetActionID\s*=\s*".*"`)
-	updatedContent := re.ReplaceAllString(string(content), fmt.Sprintf("targetActionID = \"%s\"", compiledAction))
+	// The targetActionID of the workflow and those of its API routes are all compiled.
+	updatedContent := targetActionIDPattern.ReplaceAllStringFunc(string(content), func(match string) string {
+		target := targetActionIDPattern.FindStringSubmatch(match)[1]
+		if target == action {
+			return fmt.Sprintf("targetActionID = \"%s\"", compiledAction)
+		}
+		if !strings.HasPrefix(target, "@") {
+			target = fmt.Sprintf("@%s/%s:%s", name, target, version)
+		}
+		return fmt.Sprintf("targetActionID = \"%s\"", target)
+	})
 
 	if err := afero.WriteFile(fs, compiledFilePath, []byte(updatedContent), 0o644); err != nil {
 		logger.Error("failed to write compiled workflow", "path", compiledFilePath, "error", err)
@@ -194,6 +205,10 @@ func CompileProject(fs afero.Fs, ctx context.Context, wf pklWf.Workflow, kdepsDi
 		return "", "", fmt.Errorf("failed to process workflows: %w", err)
 	}
 
+	if err := ValidateResourceGraph(fs, ctx, newWorkflow, projectDir, compiledProjectDir, logger); err != nil {
+		return "", "", err
+	}
+
 	packageFile, err := PackageProject(fs, ctx, newWorkflow, kdepsDir, compiledProjectDir, logger)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to package project: %w", err)
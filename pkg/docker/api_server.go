@@ -12,16 +12,23 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/kdeps/kdeps/pkg/evaluator"
 	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/metrics"
 	"github.com/kdeps/kdeps/pkg/resolver"
+	"github.com/kdeps/kdeps/pkg/telemetry"
 	"github.com/kdeps/kdeps/pkg/utils"
 	apiserver "github.com/kdeps/schema/gen/api_server"
 	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ErrorResponse defines the structure of each error.
@@ -43,11 +50,36 @@ type ResponseData struct {
 	Data []string `json:"data"`
 }
 
+// PlanResponse is returned instead of running the workflow when a request asks for its plan.
+type PlanResponse struct {
+	RequestID string              `json:"requestID"`
+	Plan      []resolver.PlanStep `json:"plan"`
+}
+
+// planHeader is the request header that asks for the execution plan of the request instead of
+// running it. It is only honored when the allowPlan request setting of the workflow is enabled.
+const planHeader = "X-Kdeps-Plan"
+
+// progressHeader is the request header that asks for the output of the exec and python steps to be
+// streamed while the request runs. It is only honored when the allowProgress request setting of
+// the workflow is enabled.
+const progressHeader = "X-Kdeps-Progress"
+
+// traceHeader is the request header that asks for the execution trace of the request to be
+// included in the response meta. It is only honored when the allowTrace request setting of the
+// workflow is enabled.
+const traceHeader = "X-Kdeps-Trace"
+
+// progressBufferSize is the number of output lines buffered for a client streaming a request.
+const progressBufferSize = 1024
+
 // ResponseMeta contains metadata related to the API response.
 type ResponseMeta struct {
 	RequestID  string            `json:"requestID"`
 	Headers    map[string]string `json:"headers,omitempty"`
 	Properties map[string]string `json:"properties,omitempty"`
+	// Trace holds the execution trace of the request, when it was asked for.
+	Trace []resolver.TraceSpan `json:"trace,omitempty"`
 }
 
 type handlerError struct {
@@ -59,6 +91,57 @@ func (e *handlerError) Error() string {
 	return e.message
 }
 
+// errQueueTimeout is returned when a request waited too long for a free processing slot.
+var errQueueTimeout = errors.New("timed out waiting in the request queue")
+
+// requestLimiter bounds the number of requests that are processed at the same time. Requests
+// over the limit wait in a queue until a slot frees up or the queue timeout expires.
+type requestLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+// newRequestLimiter creates a limiter for maxConcurrent requests. A maxConcurrent of zero or
+// less does not limit the requests.
+func newRequestLimiter(maxConcurrent int, queueTimeout time.Duration) *requestLimiter {
+	limiter := &requestLimiter{queueTimeout: queueTimeout}
+	if maxConcurrent > 0 {
+		limiter.slots = make(chan struct{}, maxConcurrent)
+	}
+	return limiter
+}
+
+// acquire waits for a free slot. Every successful acquire must be followed by a release.
+func (l *requestLimiter) acquire(ctx context.Context) error {
+	if l == nil || l.slots == nil {
+		return nil
+	}
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return errQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot taken by acquire.
+func (l *requestLimiter) release() {
+	if l == nil || l.slots == nil {
+		return
+	}
+	<-l.slots
+}
+
 func handleMultipartForm(c *gin.Context, dr *resolver.DependencyResolver, fileMap map[string]struct{ Filename, Filetype string }) error {
 	form, err := c.MultipartForm()
 	if err != nil {
@@ -139,7 +222,15 @@ func StartAPIServerMode(ctx context.Context, dr *resolver.DependencyResolver) er
 		}
 	}
 
-	setupRoutes(router, ctx, wfAPIServer.Routes, dr)
+	if dr.Settings.Metrics.Enabled {
+		if err := setupMetrics(router, dr); err != nil {
+			return err
+		}
+	}
+
+	limiter := newRequestLimiter(dr.Settings.Requests.MaxConcurrent,
+		time.Duration(dr.Settings.Requests.QueueTimeout)*time.Second)
+	setupRoutes(router, ctx, wfAPIServer.Routes, dr, limiter)
 
 	dr.Logger.Printf("Starting API server on port %s", hostPort)
 	go func() {
@@ -151,14 +242,72 @@ func StartAPIServerMode(ctx context.Context, dr *resolver.DependencyResolver) er
 	return nil
 }
 
-func setupRoutes(router *gin.Engine, ctx context.Context, routes []*apiserver.APIServerRoutes, dr *resolver.DependencyResolver) {
-	for _, route := range routes {
+// setupMetrics serves the Prometheus metrics on the path of the metrics settings of the workflow,
+// on a separate server when their port is set, and records the metrics of the API requests served
+// by router.
+func setupMetrics(router *gin.Engine, dr *resolver.DependencyResolver) error {
+	settings := dr.Settings.Metrics
+	path := settings.Path
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("invalid metrics path %q: it must start with /", path)
+	}
+
+	if host, port, err := parseOLLAMAHost(dr.Logger); err == nil {
+		metrics.SetOllamaCheck(func() bool { return isServerReady(host, port, dr.Logger) })
+	}
+
+	if settings.Port > 0 {
+		mux := http.NewServeMux()
+		mux.Handle(path, metrics.Handler())
+		server := &http.Server{
+			Addr:              ":" + strconv.Itoa(settings.Port),
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		dr.Logger.Printf("Serving metrics on port %d at %s", settings.Port, path)
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				dr.Logger.Error("failed to start metrics server", "error", err)
+			}
+		}()
+	} else {
+		dr.Logger.Printf("Serving metrics at %s", path)
+		router.GET(path, gin.WrapH(metrics.Handler()))
+	}
+
+	// Registered after the metrics route, so that scrapes are not recorded
+	router.Use(metricsMiddleware())
+	return nil
+}
+
+// metricsMiddleware records the count, duration and status code of the requests, labeled with
+// their route pattern.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		done := metrics.RequestStarted()
+		defer done()
+
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveRequest(route, c.Request.Method, c.Writer.Status(), time.Since(start))
+	}
+}
+
+func setupRoutes(router *gin.Engine, ctx context.Context, routes []*apiserver.APIServerRoutes, dr *resolver.DependencyResolver, limiter *requestLimiter) {
+	for i, route := range routes {
 		if route == nil || route.Path == "" {
 			dr.Logger.Error("route configuration is invalid", "route", route)
 			continue
 		}
 
-		handler := APIServerHandler(ctx, route, dr)
+		targetActionID := dr.RouteTarget(i)
+		handler := APIServerHandler(ctx, route, targetActionID, dr, limiter)
 		for _, method := range route.Methods {
 			switch method {
 			case http.MethodGet:
@@ -180,11 +329,19 @@ func setupRoutes(router *gin.Engine, ctx context.Context, routes []*apiserver.AP
 			}
 		}
 
-		dr.Logger.Printf("Route configured: %s", route.Path)
+		if targetActionID != "" {
+			dr.Logger.Printf("Route configured: %s -> %s", route.Path, targetActionID)
+		} else {
+			dr.Logger.Printf("Route configured: %s", route.Path)
+		}
 	}
 }
 
-func APIServerHandler(ctx context.Context, route *apiserver.APIServerRoutes, baseDr *resolver.DependencyResolver) gin.HandlerFunc {
+// APIServerHandler processes the requests of a route. Every request is resolved in its own
+// working directory, and the limiter bounds how many requests are processed at the same time.
+// A non-empty targetActionID resolves the requests of the route to that action instead of the
+// targetActionID of the workflow.
+func APIServerHandler(ctx context.Context, route *apiserver.APIServerRoutes, targetActionID string, baseDr *resolver.DependencyResolver, limiter *requestLimiter) gin.HandlerFunc {
 	allowedMethods := route.Methods
 
 	return func(c *gin.Context) {
@@ -192,7 +349,40 @@ func APIServerHandler(ctx context.Context, route *apiserver.APIServerRoutes, bas
 		baseLogger := logging.GetLogger()
 		logger := baseLogger.With("requestID", graphID) // Now returns *logging.Logger
 
-		dr, err := resolver.NewGraphResolver(baseDr.Fs, ctx, baseDr.Environment, baseDr.AgentDir, baseDr.ActionDir, graphID, logger)
+		// Continue the trace of the caller, if any, so the steps of the request join it
+		ctx, span := telemetry.Tracer().Start(telemetry.Extract(ctx, c.Request.Header), c.Request.Method+" "+route.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route.Path),
+				attribute.String("kdeps.request_id", graphID),
+			))
+		defer func() {
+			status := c.Writer.Status()
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			span.End()
+		}()
+
+		if err := limiter.acquire(c.Request.Context()); err != nil {
+			logger.Warn("request rejected while queued", "error", err)
+			resp := APIResponse{
+				Success: false,
+				Errors: []ErrorResponse{
+					{
+						Code:    http.StatusServiceUnavailable,
+						Message: "Server is busy, please retry later",
+					},
+				},
+			}
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, resp)
+			return
+		}
+		defer limiter.release()
+
+		dr, err := resolver.NewRequestResolver(baseDr, ctx, graphID, logger)
 		if err != nil {
 			resp := APIResponse{
 				Success: false,
@@ -206,6 +396,12 @@ func APIServerHandler(ctx context.Context, route *apiserver.APIServerRoutes, bas
 			c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
 			return
 		}
+		dr.TargetActionID = targetActionID
+		defer func() {
+			if err := dr.Fs.RemoveAll(dr.ActionDir); err != nil {
+				dr.Logger.Warn("failed to clean up request directory", "path", dr.ActionDir, "error", err)
+			}
+		}()
 
 		if err := cleanOldFiles(dr); err != nil {
 			resp := APIResponse{
@@ -339,7 +535,7 @@ func APIServerHandler(ctx context.Context, route *apiserver.APIServerRoutes, bas
 		urlSection := fmt.Sprintf(`path = "%s"`, c.Request.URL.Path)
 		clientIPSection := fmt.Sprintf(`IP = "%s"`, c.ClientIP())
 		requestIDSection := fmt.Sprintf(`ID = "%s"`, graphID)
-		dataSection := fmt.Sprintf(`data = "%s"`, utils.EncodeBase64String(bodyData))
+		dataSection := "data = " + utils.PklString(bodyData)
 
 		var sb strings.Builder
 		sb.WriteString("files {\n")
@@ -358,8 +554,7 @@ func APIServerHandler(ctx context.Context, route *apiserver.APIServerRoutes, bas
 
 		sections := []string{urlSection, clientIPSection, requestIDSection, method, requestHeaderSection, dataSection, paramSection, fileSection}
 
-		if err := evaluator.CreateAndProcessPklFile(dr.Fs, ctx, sections, dr.RequestPklFile,
-			"APIServerRequest.pkl", dr.Logger, evaluator.EvalPkl, true); err != nil {
+		if err := dr.CreateRequestModule(sections); err != nil {
 			resp := APIResponse{
 				Success: false,
 				Errors: []ErrorResponse{
@@ -373,85 +568,236 @@ func APIServerHandler(ctx context.Context, route *apiserver.APIServerRoutes, bas
 			return
 		}
 
-		fatal, err := processWorkflow(ctx, dr)
-		if err != nil {
-			resp := APIResponse{
-				Success: false,
-				Errors: []ErrorResponse{
-					{
-						Code:    http.StatusInternalServerError,
-						Message: "Workflow processing failed",
-					},
-				},
-			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
+		if dr.Settings.Requests.AllowPlan && strings.EqualFold(c.GetHeader(planHeader), "true") {
+			respondWithPlan(c, dr)
 			return
 		}
 
-		content, err := afero.ReadFile(dr.Fs, dr.ResponseTargetFile)
-		if err != nil {
-			resp := APIResponse{
-				Success: false,
-				Errors: []ErrorResponse{
-					{
-						Code:    http.StatusInternalServerError,
-						Message: "Failed to read response file",
-					},
-				},
-			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
-			return
+		trace := dr.Settings.Requests.AllowTrace && strings.EqualFold(c.GetHeader(traceHeader), "true")
+
+		var fatal bool
+		if dr.Settings.Requests.AllowProgress && strings.EqualFold(c.GetHeader(progressHeader), "true") {
+			fatal = streamWorkflow(c, ctx, dr, trace)
+		} else {
+			fatal = respondWithWorkflow(c, ctx, dr, trace)
 		}
 
-		decodedResp, err := decodeResponseContent(content, dr.Logger)
-		if err != nil {
-			resp := APIResponse{
-				Success: false,
-				Errors: []ErrorResponse{
-					{
-						Code:    http.StatusInternalServerError,
-						Message: "Failed to decode response content",
-					},
-				},
+		if fatal {
+			if removeErr := dr.Fs.RemoveAll(dr.ActionDir); removeErr != nil {
+				dr.Logger.Warn("failed to clean up temporary directory", "path", dr.ActionDir, "error", removeErr)
 			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
-			return
+			dr.Logger.Error("a fatal server error occurred. Restarting the service.")
+			utils.SendSigterm(dr.Logger)
 		}
+	}
+}
 
-		if decodedResp.Meta.Headers != nil {
-			for key, value := range decodedResp.Meta.Headers {
-				c.Header(key, value)
-			}
+// respondWithWorkflow processes the workflow of the request and responds with its response,
+// including its execution trace when trace is set. It reports whether a fatal error occurred.
+func respondWithWorkflow(c *gin.Context, ctx context.Context, dr *resolver.DependencyResolver, trace bool) bool {
+	fatal, err := processWorkflow(ctx, dr)
+	if err != nil {
+		resp := APIResponse{
+			Success: false,
+			Errors: []ErrorResponse{
+				{
+					Code:    http.StatusInternalServerError,
+					Message: "Workflow processing failed",
+				},
+			},
 		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
+		return false
+	}
 
-		decodedContent, err := json.Marshal(decodedResp)
-		if err != nil {
-			resp := APIResponse{
-				Success: false,
-				Errors: []ErrorResponse{
-					{
-						Code:    http.StatusInternalServerError,
-						Message: "Failed to marshal response content",
-					},
+	content, headers, err := workflowResponse(dr, trace)
+	if err != nil {
+		var he *handlerError
+		errors.As(err, &he)
+		resp := APIResponse{
+			Success: false,
+			Errors: []ErrorResponse{
+				{
+					Code:    he.statusCode,
+					Message: he.message,
 				},
-			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
-			return
+			},
 		}
+		c.AbortWithStatusJSON(he.statusCode, resp)
+		return false
+	}
 
-		decodedContent = formatResponseJSON(decodedContent)
-		c.Data(http.StatusOK, "application/json; charset=utf-8", decodedContent)
+	for key, value := range headers {
+		c.Header(key, value)
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", content)
+	return fatal
+}
 
-		if fatal {
-			if removeErr := dr.Fs.RemoveAll(dr.ActionDir); removeErr != nil {
-				dr.Logger.Warn("failed to clean up temporary directory", "path", dr.ActionDir, "error", removeErr)
+// streamWorkflow processes the workflow of the request, streaming the output lines of the exec
+// and python steps to the client as server-sent "output" events while they run, followed by a
+// "response" event holding the response of the workflow, or an "error" event. The headers of the
+// workflow response are not sent, since the response headers are written before the workflow
+// runs. The response includes the execution trace of the request when trace is set. The workflow
+// stops when the client goes away or an event cannot be written to it. It reports whether a fatal
+// error occurred.
+func streamWorkflow(c *gin.Context, ctx context.Context, dr *resolver.DependencyResolver, trace bool) bool {
+	ctx, cancel := streamContext(c, ctx)
+	defer cancel()
+
+	lines := make(chan resolver.OutputLine, progressBufferSize)
+	var dropped atomic.Int64
+	dr.OnOutput = func(line resolver.OutputLine) {
+		// A slow client must not block the steps, so lines that do not fit are dropped.
+		select {
+		case lines <- line:
+		default:
+			dropped.Add(1)
+		}
+	}
+
+	type outcome struct {
+		fatal bool
+		err   error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		fatal, err := processWorkflow(ctx, dr)
+		done <- outcome{fatal: fatal, err: err}
+	}()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	for {
+		select {
+		case line := <-lines:
+			if err := writeEvent(c, "output", line); err != nil {
+				dr.Logger.Warn("failed to stream output, stopping the workflow", "error", err)
+				cancel()
+				<-done
+				return false
 			}
-			dr.Logger.Error("a fatal server error occurred. Restarting the service.")
-			utils.SendSigterm(dr.Logger)
+		case result := <-done:
+			for len(lines) > 0 {
+				if err := writeEvent(c, "output", <-lines); err != nil {
+					dr.Logger.Warn("failed to stream output", "error", err)
+					return result.fatal
+				}
+			}
+			if n := dropped.Load(); n > 0 {
+				dr.Logger.Warn("dropped output lines the client did not read in time", "count", n)
+			}
+
+			if result.err != nil {
+				_ = writeEvent(c, "error", APIResponse{
+					Success: false,
+					Errors:  []ErrorResponse{{Code: http.StatusInternalServerError, Message: "Workflow processing failed"}},
+				})
+				return false
+			}
+
+			content, _, err := workflowResponse(dr, trace)
+			if err != nil {
+				var he *handlerError
+				errors.As(err, &he)
+				_ = writeEvent(c, "error", APIResponse{
+					Success: false,
+					Errors:  []ErrorResponse{{Code: he.statusCode, Message: he.message}},
+				})
+				return false
+			}
+
+			if err := writeEvent(c, "response", string(content)); err != nil {
+				dr.Logger.Warn("failed to stream the response", "error", err)
+			}
+			return result.fatal
 		}
 	}
 }
 
+// streamContext returns the context a streamed workflow runs under. It is derived from the context
+// of the HTTP request, so that it is canceled when the client goes away, carries the span of the
+// request from ctx, and is also canceled when ctx is done.
+func streamContext(c *gin.Context, ctx context.Context) (context.Context, context.CancelFunc) {
+	streamCtx, cancel := context.WithCancel(trace.ContextWithSpan(c.Request.Context(), trace.SpanFromContext(ctx)))
+	stop := context.AfterFunc(ctx, cancel)
+	return streamCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// writeEvent writes a server-sent event to the client and flushes it. It returns the error of the
+// write, if any.
+func writeEvent(c *gin.Context, name string, data any) error {
+	var event bytes.Buffer
+	if err := sse.Encode(&event, sse.Event{Event: name, Data: data}); err != nil {
+		return err
+	}
+	if _, err := c.Writer.Write(event.Bytes()); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// workflowResponse reads the response created by the workflow of the request and returns it with
+// the headers it sets. The execution trace of the request is added to the meta when trace is set.
+// The error is a *handlerError.
+func workflowResponse(dr *resolver.DependencyResolver, trace bool) ([]byte, map[string]string, error) {
+	content, err := afero.ReadFile(dr.Fs, dr.ResponseTargetFile)
+	if err != nil {
+		return nil, nil, &handlerError{http.StatusInternalServerError, "Failed to read response file"}
+	}
+
+	decodedResp, err := decodeResponseContent(content, dr.Logger)
+	if err != nil {
+		return nil, nil, &handlerError{http.StatusInternalServerError, "Failed to decode response content"}
+	}
+	if trace {
+		decodedResp.Meta.Trace = dr.Trace()
+	}
+
+	decodedContent, err := json.Marshal(decodedResp)
+	if err != nil {
+		return nil, nil, &handlerError{http.StatusInternalServerError, "Failed to marshal response content"}
+	}
+
+	return formatResponseJSON(decodedContent), decodedResp.Meta.Headers, nil
+}
+
+// respondWithPlan responds with the execution plan of the request, without running it.
+func respondWithPlan(c *gin.Context, dr *resolver.DependencyResolver) {
+	plan, err := planRequest(dr)
+	if err != nil {
+		dr.Logger.Error("failed to plan request", "error", err)
+		resp := APIResponse{
+			Success: false,
+			Errors: []ErrorResponse{
+				{
+					Code:    http.StatusInternalServerError,
+					Message: "Failed to plan request",
+				},
+			},
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, PlanResponse{RequestID: dr.RequestID, Plan: plan})
+}
+
+// planRequest loads the resources of the request, if needed, and plans its target action.
+func planRequest(dr *resolver.DependencyResolver) ([]resolver.PlanStep, error) {
+	if err := dr.PrepareResources(); err != nil {
+		return nil, err
+	}
+	return dr.Plan(dr.Context, "")
+}
+
 // cleanOldFiles removes any old response files or flags from previous API requests.
 // It ensures the environment is clean before processing new requests.
 func cleanOldFiles(dr *resolver.DependencyResolver) error {
@@ -482,15 +828,18 @@ func validateMethod(r *http.Request, allowedMethods []string) (string, error) {
 
 // processWorkflow handles the execution of the workflow steps after the .pkl file is created.
 // It prepares the workflow directory, imports necessary files, and processes the actions defined in the workflow.
+// Requests created from the compiled workflow already have their workflow directory and import files.
 func processWorkflow(ctx context.Context, dr *resolver.DependencyResolver) (bool, error) {
 	dr.Context = ctx
 
-	if err := dr.PrepareWorkflowDir(); err != nil {
-		return false, err
-	}
+	if !dr.Compiled() {
+		if err := dr.PrepareWorkflowDir(); err != nil {
+			return false, err
+		}
 
-	if err := dr.PrepareImportFiles(); err != nil {
-		return false, err
+		if err := dr.PrepareImportFiles(); err != nil {
+			return false, err
+		}
 	}
 
 	//nolint:contextcheck // context already passed via dr.Context
@@ -0,0 +1,160 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/resolver"
+	"github.com/kdeps/kdeps/pkg/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Unlimited", func(t *testing.T) {
+		t.Parallel()
+		limiter := newRequestLimiter(0, time.Second)
+		for range 10 {
+			require.NoError(t, limiter.acquire(context.Background()))
+		}
+	})
+
+	t.Run("QueuesUntilReleased", func(t *testing.T) {
+		t.Parallel()
+		limiter := newRequestLimiter(1, time.Second)
+		require.NoError(t, limiter.acquire(context.Background()))
+
+		acquired := make(chan error, 1)
+		go func() { acquired <- limiter.acquire(context.Background()) }()
+
+		select {
+		case <-acquired:
+			t.Fatal("second request should wait for a free slot")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		limiter.release()
+		require.NoError(t, <-acquired)
+	})
+
+	t.Run("QueueTimeout", func(t *testing.T) {
+		t.Parallel()
+		limiter := newRequestLimiter(1, 10*time.Millisecond)
+		require.NoError(t, limiter.acquire(context.Background()))
+		assert.ErrorIs(t, limiter.acquire(context.Background()), errQueueTimeout)
+	})
+
+	t.Run("ClientGone", func(t *testing.T) {
+		t.Parallel()
+		limiter := newRequestLimiter(1, 0)
+		require.NoError(t, limiter.acquire(context.Background()))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorIs(t, limiter.acquire(ctx), context.Canceled)
+	})
+}
+
+func TestSetupMetrics(t *testing.T) {
+	t.Parallel()
+
+	newResolver := func(path string) *resolver.DependencyResolver {
+		return &resolver.DependencyResolver{
+			Logger:   logging.NewTestLogger(),
+			Settings: &workflow.Settings{Metrics: workflow.MetricsSettings{Enabled: true, Path: path}},
+		}
+	}
+
+	t.Run("RecordsRoutes", func(t *testing.T) {
+		t.Parallel()
+		router := gin.New()
+		require.NoError(t, setupMetrics(router, newResolver("/metrics")))
+		router.POST("/api/v1/metrics-test/:id", func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/metrics-test/42", nil))
+		require.Equal(t, http.StatusCreated, recorder.Code)
+
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), `kdeps_http_requests_total{method="POST",route="/api/v1/metrics-test/:id",status="201"} 1`)
+		assert.NotContains(t, recorder.Body.String(), `route="/metrics"`)
+	})
+
+	t.Run("InvalidPath", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, setupMetrics(gin.New(), newResolver("metrics")))
+	})
+}
+
+// failingWriter is a response writer whose writes fail, like the one of a client that went away.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestStreamHelpers(t *testing.T) {
+	t.Parallel()
+
+	newContext := func(w http.ResponseWriter) (*gin.Context, context.CancelFunc) {
+		c, _ := gin.CreateTestContext(w)
+		ctx, cancel := context.WithCancel(context.Background())
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/stream", nil).WithContext(ctx)
+		return c, cancel
+	}
+
+	t.Run("ClientGoesAway", func(t *testing.T) {
+		t.Parallel()
+		c, cancelRequest := newContext(httptest.NewRecorder())
+		ctx, cancel := streamContext(c, context.Background())
+		defer cancel()
+
+		require.NoError(t, ctx.Err())
+		cancelRequest()
+		<-ctx.Done()
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	})
+
+	t.Run("ServerStops", func(t *testing.T) {
+		t.Parallel()
+		c, cancelRequest := newContext(httptest.NewRecorder())
+		defer cancelRequest()
+		serverCtx, stopServer := context.WithCancel(context.Background())
+		ctx, cancel := streamContext(c, serverCtx)
+		defer cancel()
+
+		stopServer()
+		<-ctx.Done()
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	})
+
+	t.Run("WritesEvent", func(t *testing.T) {
+		t.Parallel()
+		recorder := httptest.NewRecorder()
+		c, cancel := newContext(recorder)
+		defer cancel()
+
+		require.NoError(t, writeEvent(c, "output", "hello"))
+		assert.Equal(t, "event:output\ndata:hello\n\n", recorder.Body.String())
+	})
+
+	t.Run("WriteFails", func(t *testing.T) {
+		t.Parallel()
+		c, cancel := newContext(failingWriter{httptest.NewRecorder()})
+		defer cancel()
+
+		assert.EqualError(t, writeEvent(c, "output", "hello"), "broken pipe")
+	})
+}
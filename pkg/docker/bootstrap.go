@@ -37,6 +37,11 @@ func setupDockerEnvironment(ctx context.Context, dr *resolver.DependencyResolver
 		return false, fmt.Errorf("failed to prepare workflow directory: %w", err)
 	}
 
+	dr.Logger.Debug("loading conda environments")
+	if err := dr.LoadCondaEnvironments(ctx); err != nil {
+		return false, err
+	}
+
 	host, port, err := parseOLLAMAHost(dr.Logger)
 	if err != nil {
 		return false, err
@@ -56,6 +61,15 @@ func setupDockerEnvironment(ctx context.Context, dr *resolver.DependencyResolver
 	}
 
 	if wfSettings.APIServerMode {
+		if err := dr.LoadRouteTargets(ctx); err != nil {
+			return wfSettings.APIServerMode, err
+		}
+
+		dr.Logger.Debug("compiling workflow resources")
+		if err := dr.CompileRequestTemplate(); err != nil {
+			return wfSettings.APIServerMode, fmt.Errorf("failed to compile workflow: %w", err)
+		}
+
 		return wfSettings.APIServerMode, startAPIServer(ctx, dr)
 	}
 
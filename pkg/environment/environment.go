@@ -18,6 +18,10 @@ type Environment struct {
 	KdepsConfig    string `env:"KDEPS_CONFIG,default=$HOME/.kdeps.pkl"`
 	DockerMode     string `env:"DOCKER_MODE,default=0"`
 	NonInteractive string `env:"NON_INTERACTIVE,default=0"`
+	PklEvaluator   string `env:"KDEPS_PKL_EVALUATOR,default=inprocess"`
+	SecretsDir     string `env:"KDEPS_SECRETS_DIR,default=/run/secrets"`
+	SecretsStore   string `env:"KDEPS_SECRETS_STORE,default=/agent/secrets.enc"`
+	SecretsKey     string `env:"KDEPS_SECRETS_KEY"`
 	Extras         env.EnvSet
 }
 
@@ -67,45 +71,27 @@ func allDockerEnvVarsSet() bool {
 
 // NewEnvironment initializes and returns a new Environment based on provided or default settings.
 func NewEnvironment(fs afero.Fs, environ *Environment) (*Environment, error) {
+	environment := &Environment{}
 	if environ != nil {
 		// If an environment is provided, prioritize overriding configurations
-		kdepsConfigFile := findKdepsConfig(fs, environ.Pwd, environ.Home)
-		dockerMode := "0"
-		if isDockerEnvironment(fs, environ.Root) {
-			dockerMode = "1"
+		*environment = *environ
+		environment.NonInteractive = "1" // Prioritize non-interactive mode for overridden environments
+		environment.Extras = nil
+	} else {
+		// Load environment variables into a new Environment struct
+		extras, err := env.UnmarshalFromEnviron(environment)
+		if err != nil {
+			return nil, err
 		}
-
-		return &Environment{
-			Root:           environ.Root,
-			Home:           environ.Home,
-			Pwd:            environ.Pwd,
-			KdepsConfig:    kdepsConfigFile,
-			NonInteractive: "1", // Prioritize non-interactive mode for overridden environments
-			DockerMode:     dockerMode,
-		}, nil
-	}
-
-	// Load environment variables into a new Environment struct
-	environment := &Environment{}
-	extras, err := env.UnmarshalFromEnviron(environment)
-	if err != nil {
-		return nil, err
+		environment.Extras = extras
 	}
-	environment.Extras = extras
 
 	// Find kdepsConfig file and check if running in Docker
-	kdepsConfigFile := findKdepsConfig(fs, environment.Pwd, environment.Home)
-	dockerMode := "0"
+	environment.KdepsConfig = findKdepsConfig(fs, environment.Pwd, environment.Home)
+	environment.DockerMode = "0"
 	if isDockerEnvironment(fs, environment.Root) {
-		dockerMode = "1"
+		environment.DockerMode = "1"
 	}
 
-	return &Environment{
-		Root:        environment.Root,
-		Home:        environment.Home,
-		Pwd:         environment.Pwd,
-		KdepsConfig: kdepsConfigFile,
-		DockerMode:  dockerMode,
-		Extras:      environment.Extras,
-	}, nil
+	return environment, nil
 }
@@ -108,13 +108,15 @@ func TestNewEnvironment(t *testing.T) {
 
 	// Test with provided environment
 	providedEnv := &Environment{
-		Root: "/",
-		Home: "/home",
-		Pwd:  "/current",
+		Root:         "/",
+		Home:         "/home",
+		Pwd:          "/current",
+		PklEvaluator: "cli",
 	}
 	env, err := NewEnvironment(fs, providedEnv)
 	require.NoError(t, err, "Expected no error")
 	assert.Equal(t, providedEnv.Home, env.Home, "Expected Home directory to match")
+	assert.Equal(t, "cli", env.PklEvaluator, "Expected the provided settings to be kept")
 	assert.Equal(t, "1", env.NonInteractive, "Expected NonInteractive to be prioritized")
 
 	// Test loading from default environment
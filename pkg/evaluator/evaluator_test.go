@@ -52,3 +52,35 @@ func TestCreateAndProcessPklFile(t *testing.T) {
 		assert.Contains(t, string(content), sections[1], "Final file content should include section2")
 	})
 }
+
+func TestNewManager(t *testing.T) {
+	t.Parallel()
+
+	t.Run("DefaultsToInProcess", func(t *testing.T) {
+		t.Parallel()
+		manager, err := evaluator.NewManager("")
+		require.NoError(t, err)
+		assert.Equal(t, evaluator.ModeInProcess, manager.Mode())
+		require.NoError(t, manager.Close())
+	})
+
+	t.Run("CLI", func(t *testing.T) {
+		t.Parallel()
+		manager, err := evaluator.NewManager(evaluator.ModeCLI)
+		require.NoError(t, err)
+		assert.Equal(t, evaluator.ModeCLI, manager.Mode())
+	})
+
+	t.Run("UnknownMode", func(t *testing.T) {
+		t.Parallel()
+		_, err := evaluator.NewManager("jvm")
+		require.Error(t, err)
+	})
+
+	t.Run("NilManager", func(t *testing.T) {
+		t.Parallel()
+		var manager *evaluator.Manager
+		assert.Equal(t, evaluator.ModeCLI, manager.Mode())
+		require.NoError(t, manager.Close())
+	})
+}
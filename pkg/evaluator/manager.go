@@ -0,0 +1,180 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/alexellis/go-execute/v2"
+	"github.com/apple/pkl-go/pkl"
+	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/spf13/afero"
+)
+
+const (
+	// ModeInProcess evaluates Pkl through a long-lived pkl-go evaluator manager.
+	ModeInProcess = "inprocess"
+	// ModeCLI evaluates Pkl by running the pkl binary for every evaluation.
+	ModeCLI = "cli"
+)
+
+// Manager evaluates Pkl modules. In the in-process mode a single pkl-go evaluator manager, and
+// therefore a single pkl server process, is shared by every evaluation. Each evaluation still
+// gets a fresh evaluator so that output files rewritten between evaluations are never read from
+// the module cache of an earlier one. A nil Manager behaves like one in the CLI mode.
+type Manager struct {
+	mode string
+
+	mu      sync.Mutex
+	manager pkl.EvaluatorManager
+}
+
+// NewManager creates a manager for the given mode. An empty mode selects the in-process mode.
+func NewManager(mode string) (*Manager, error) {
+	switch mode {
+	case "", ModeInProcess:
+		mode = ModeInProcess
+	case ModeCLI:
+	default:
+		return nil, fmt.Errorf("unknown pkl evaluator %q, expected %q or %q", mode, ModeInProcess, ModeCLI)
+	}
+
+	return &Manager{mode: mode}, nil
+}
+
+// Mode returns the evaluation mode of the manager.
+func (m *Manager) Mode() string {
+	if m == nil {
+		return ModeCLI
+	}
+	return m.mode
+}
+
+// newEvaluator returns an evaluator for a single evaluation. The caller must close it.
+func (m *Manager) newEvaluator(ctx context.Context, opts ...func(options *pkl.EvaluatorOptions)) (pkl.Evaluator, error) {
+	opts = append([]func(options *pkl.EvaluatorOptions){pkl.PreconfiguredOptions}, opts...)
+	if m.Mode() == ModeCLI {
+		return pkl.NewEvaluator(ctx, opts...)
+	}
+
+	m.mu.Lock()
+	if m.manager == nil {
+		m.manager = pkl.NewEvaluatorManager()
+	}
+	manager := m.manager
+	m.mu.Unlock()
+
+	return manager.NewEvaluator(ctx, opts...)
+}
+
+// Evaluate runs fn with an evaluator that is closed once fn returns. The options are applied on
+// top of the preconfigured options.
+func (m *Manager) Evaluate(ctx context.Context, fn func(evaluator pkl.Evaluator) error, opts ...func(options *pkl.EvaluatorOptions)) (err error) {
+	evaluator, err := m.newEvaluator(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create pkl evaluator: %w", err)
+	}
+	defer func() {
+		if cerr := evaluator.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return fn(evaluator)
+}
+
+// Load decodes the module at source with one of the generated Load functions of the schema.
+func Load[T any](ctx context.Context, m *Manager, source *pkl.ModuleSource, load func(context.Context, pkl.Evaluator, *pkl.ModuleSource) (T, error), opts ...func(options *pkl.EvaluatorOptions)) (T, error) {
+	var out T
+	err := m.Evaluate(ctx, func(evaluator pkl.Evaluator) error {
+		var err error
+		out, err = load(ctx, evaluator, source)
+		return err
+	}, opts...)
+	return out, err
+}
+
+// EvalPkl evaluates the module at resourcePath and returns its rendered output prefixed with
+// headerSection, like EvalPkl does with the pkl binary.
+func (m *Manager) EvalPkl(fs afero.Fs, ctx context.Context, resourcePath string, headerSection string, logger *logging.Logger) (string, error) {
+	if m.Mode() == ModeCLI {
+		return EvalPkl(fs, ctx, resourcePath, headerSection, logger)
+	}
+
+	if filepath.Ext(resourcePath) != ".pkl" {
+		errMsg := fmt.Sprintf("file '%s' must have a .pkl extension", resourcePath)
+		logger.Error(errMsg)
+		return "", errors.New(errMsg)
+	}
+
+	var output string
+	err := m.Evaluate(ctx, func(evaluator pkl.Evaluator) error {
+		var err error
+		output, err = evaluator.EvaluateOutputText(ctx, pkl.FileSource(resourcePath))
+		return err
+	})
+	if err != nil {
+		logger.Error("pkl evaluation failed", "file", resourcePath, "error", err)
+		return "", fmt.Errorf("pkl evaluation failed: %w", err)
+	}
+
+	return fmt.Sprintf("%s\n%s", headerSection, output), nil
+}
+
+// EvalJSON renders the module at resourcePath as JSON into outputPath.
+func (m *Manager) EvalJSON(fs afero.Fs, ctx context.Context, resourcePath, outputPath string, logger *logging.Logger) error {
+	if m.Mode() == ModeCLI {
+		if err := EnsurePklBinaryExists(ctx, logger); err != nil {
+			return err
+		}
+
+		cmd := execute.ExecTask{
+			Command:     "pkl",
+			Args:        []string{"eval", "--format", "json", "--output-path", outputPath, resourcePath},
+			StreamStdio: false,
+		}
+
+		result, err := cmd.Execute(ctx)
+		if err != nil {
+			return fmt.Errorf("execute command: %w", err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("command failed with exit code %d: %s", result.ExitCode, result.Stderr)
+		}
+		return nil
+	}
+
+	evaluator, err := m.newEvaluator(ctx, func(options *pkl.EvaluatorOptions) {
+		options.OutputFormat = "json"
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create pkl evaluator: %w", err)
+	}
+	defer evaluator.Close()
+
+	output, err := evaluator.EvaluateOutputText(ctx, pkl.FileSource(resourcePath))
+	if err != nil {
+		return fmt.Errorf("pkl evaluation failed: %w", err)
+	}
+
+	return afero.WriteFile(fs, outputPath, []byte(output), 0o644)
+}
+
+// Close stops the pkl server shared by the in-process evaluations.
+func (m *Manager) Close() error {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.manager == nil {
+		return nil
+	}
+	err := m.manager.Close()
+	m.manager = nil
+	return err
+}
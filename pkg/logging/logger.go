@@ -2,8 +2,10 @@ package logging
 
 import (
 	"bytes"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/charmbracelet/log"
 )
@@ -16,13 +18,38 @@ type Logger struct {
 var (
 	logger *Logger
 	once   sync.Once
+	// output is the writer of the logger created by CreateLogger and of the loggers derived from it.
+	output = &redactingWriter{w: os.Stderr}
 )
 
+// redactingWriter passes the log entries written to it through the redactor set by SetRedactor.
+type redactingWriter struct {
+	w      io.Writer
+	redact atomic.Pointer[func(string) string]
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	redact := w.redact.Load()
+	if redact == nil {
+		return w.w.Write(p)
+	}
+	if _, err := io.WriteString(w.w, (*redact)(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetRedactor makes the logger created by CreateLogger, and every logger derived from it, pass
+// their entries through redact before writing them, so that secret values are never logged.
+func SetRedactor(redact func(string) string) {
+	output.redact.Store(&redact)
+}
+
 func CreateLogger() {
 	once.Do(func() {
-		baseLogger := log.New(os.Stderr)
+		baseLogger := log.New(output)
 		if os.Getenv("DEBUG") == "1" {
-			baseLogger = log.NewWithOptions(os.Stderr, log.Options{
+			baseLogger = log.NewWithOptions(output, log.Options{
 				ReportCaller:    true,
 				ReportTimestamp: true,
 				Prefix:          "kdeps",
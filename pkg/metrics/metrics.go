@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kdeps"
+
+// Registry holds the metrics of the agent. It is separate from the default registry of the
+// Prometheus client so that only the metrics of kdeps and of its process are exposed.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of API requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of API requests by route, method and status code.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"route", "method", "status"})
+
+	requestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of API requests being served, including the queued ones.",
+	})
+
+	stepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "resource_step_duration_seconds",
+		Help:      "Duration of resource steps by step type and outcome.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"step", "outcome"})
+
+	llmRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_requests_total",
+		Help:      "Number of LLM calls by model and outcome.",
+	}, []string{"model", "outcome"})
+
+	llmRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "Duration of LLM calls by model.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"model"})
+)
+
+var (
+	ollamaMu    sync.RWMutex
+	ollamaCheck func() bool
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		requestsInFlight,
+		stepDuration,
+		llmRequestsTotal,
+		llmRequestDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ollama_up",
+			Help:      "Whether the Ollama server accepts connections (1) or not (0).",
+		}, ollamaUp),
+	)
+}
+
+// Handler returns the HTTP handler that serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RequestStarted counts a request as in flight until the returned function is called.
+func RequestStarted() func() {
+	requestsInFlight.Inc()
+	return requestsInFlight.Dec
+}
+
+// ObserveRequest records an API request served by route.
+func ObserveRequest(route, method string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	requestsTotal.WithLabelValues(route, method, code).Inc()
+	requestDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+// ObserveStep records a resource step of the given type (exec, python, llm or client).
+func ObserveStep(step, outcome string, duration time.Duration) {
+	stepDuration.WithLabelValues(step, outcome).Observe(duration.Seconds())
+}
+
+// ObserveLLMCall records a call to model, which failed when err is not nil.
+func ObserveLLMCall(model string, err error, duration time.Duration) {
+	outcome := "succeeded"
+	if err != nil {
+		outcome = "failed"
+	}
+	llmRequestsTotal.WithLabelValues(model, outcome).Inc()
+	llmRequestDuration.WithLabelValues(model).Observe(duration.Seconds())
+}
+
+// SetOllamaCheck sets the check the ollama_up metric runs on every scrape.
+func SetOllamaCheck(check func() bool) {
+	ollamaMu.Lock()
+	defer ollamaMu.Unlock()
+	ollamaCheck = check
+}
+
+func ollamaUp() float64 {
+	ollamaMu.RLock()
+	check := ollamaCheck
+	ollamaMu.RUnlock()
+
+	if check != nil && check() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	ObserveLLMCall("llama3.2:1b", nil, time.Second)
+	ObserveLLMCall("llama3.2:1b", assert.AnError, time.Second)
+	assert.InDelta(t, 1, testutil.ToFloat64(llmRequestsTotal.WithLabelValues("llama3.2:1b", "failed")), 0)
+
+	ObserveStep("exec", "succeeded", 50*time.Millisecond)
+	done := RequestStarted()
+	assert.InDelta(t, 1, testutil.ToFloat64(requestsInFlight), 0)
+	done()
+
+	SetOllamaCheck(func() bool { return true })
+	assert.InDelta(t, 1, ollamaUp(), 0)
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `kdeps_llm_requests_total{model="llama3.2:1b",outcome="succeeded"} 1`)
+	assert.Contains(t, recorder.Body.String(), `kdeps_resource_step_duration_seconds_count{outcome="succeeded",step="exec"} 1`)
+	assert.Contains(t, recorder.Body.String(), "kdeps_ollama_up 1")
+	assert.Contains(t, recorder.Body.String(), "go_goroutines")
+}
@@ -0,0 +1,280 @@
+package resolver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kdeps/kdeps/pkg/metrics"
+	"github.com/kdeps/kdeps/pkg/telemetry"
+	pklHTTP "github.com/kdeps/schema/gen/http"
+	pklRes "github.com/kdeps/schema/gen/resource"
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// cachePolicy is the cache block of a resource run block.
+type cachePolicy struct {
+	// Whether the output of the resource is cached. Defaults to true when the block is set.
+	Enabled *bool `pkl:"enabled"`
+
+	// How long (in seconds) a cached output is reused. Defaults to the cache ttl of the workflow.
+	TTL *int `pkl:"ttl"`
+}
+
+// cacheSettings is a cache policy with its defaults applied.
+type cacheSettings struct {
+	enabled bool
+	ttl     time.Duration
+}
+
+// cacheEntry is the stored output of a resource step.
+type cacheEntry struct {
+	CreatedAt time.Time          `json:"createdAt"`
+	Stdout    *string            `json:"stdout,omitempty"`
+	Stderr    *string            `json:"stderr,omitempty"`
+	ExitCode  *int               `json:"exitCode,omitempty"`
+	Python    *pythonRun         `json:"python,omitempty"`
+	Response  *string            `json:"response,omitempty"`
+	Body      *string            `json:"body,omitempty"`
+	Headers   *map[string]string `json:"headers,omitempty"`
+}
+
+// cacheSettings returns the cache settings of the resource. Caching is opt-in: it is enabled
+// by the cache block of the resource or by listing the actionID in the cache settings of the
+// workflow.
+func (dr *DependencyResolver) cacheSettings(actionID string, policy *cachePolicy) cacheSettings {
+	workflowCache := dr.settings().Cache
+	settings := cacheSettings{ttl: time.Duration(workflowCache.TTL) * time.Second}
+	for _, id := range workflowCache.Resources {
+		if dr.compiledActionID(id) == actionID {
+			settings.enabled = true
+		}
+	}
+
+	if policy != nil {
+		settings.enabled = policy.Enabled == nil || *policy.Enabled
+		if policy.TTL != nil {
+			settings.ttl = time.Duration(*policy.TTL) * time.Second
+		}
+	}
+
+	return settings
+}
+
+// runResourceStep runs a step of the run block with its retry policy. When caching is enabled,
+// a stored output of an identical step is reused instead, and a successful output is stored.
+func (dr *DependencyResolver) runResourceStep(ctx context.Context, actionID, step string, runBlock *pklRes.ResourceAction, opts *runOptions,
+	timeoutPtr *int, retry retrySettings, cache cacheSettings, handler func(ctx context.Context) error,
+) (err error) {
+	ctx, otelSpan := telemetry.Tracer().Start(ctx, "kdeps."+step, trace.WithAttributes(attribute.String("kdeps.action_id", actionID)))
+	span := TraceSpan{ActionID: actionID, Step: step, Start: time.Now(), Outcome: traceSucceeded}
+	defer func() {
+		recorded := dr.recordStepSpan(span, runBlock, err)
+		metrics.ObserveStep(step, recorded.Outcome, recorded.End.Sub(recorded.Start))
+		endOtelSpan(otelSpan, recorded, err)
+	}()
+
+	var key string
+	if cache.enabled {
+		var err error
+		if key, err = dr.stepCacheKey(actionID, step, runBlock, opts); err != nil {
+			dr.Logger.Warn("unable to compute cache key, caching disabled", "actionID", actionID, "error", err)
+			cache.enabled = false
+		}
+	}
+
+	if cache.enabled {
+		if entry := dr.loadCacheEntry(key, cache.ttl); entry != nil {
+			err := dr.restoreCachedStep(actionID, step, runBlock, entry)
+			if err == nil {
+				dr.Logger.Infof("resource '%s' (type: %s) reused its cached output", actionID, step)
+				span.Outcome = traceCached
+				return dr.updateStatus(actionID, func(status *resourceStatus) { status.cached = true })
+			}
+			dr.Logger.Warn("unable to restore cached output, running the step", "actionID", actionID, "error", err)
+		}
+	}
+
+	err = dr.processResourceStepWithRetry(ctx, actionID, step, timeoutPtr, retry, handler)
+
+	// A 5xx response that is not retried (anymore) is kept as the resource output, but not cached.
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return nil
+	}
+	if err != nil || !cache.enabled {
+		return err
+	}
+
+	entry := newCacheEntry(step, runBlock)
+	if step == "python" {
+		run, err := dr.recordedPythonRun(actionID)
+		if err != nil {
+			dr.Logger.Warn("unable to store cached output", "actionID", actionID, "error", err)
+			return nil
+		}
+		entry.Python = &run
+	}
+	if err := dr.storeCacheEntry(key, entry); err != nil {
+		dr.Logger.Warn("unable to store cached output", "actionID", actionID, "error", err)
+	}
+	return nil
+}
+
+// stepCacheKey hashes the evaluated inputs of a step, including the run options it reads. The
+// content of the script file of an exec step and the environment of a python step are part of the
+// inputs, so that editing the script or switching the environment does not reuse a stale output.
+func (dr *DependencyResolver) stepCacheKey(actionID, step string, runBlock *pklRes.ResourceAction, opts *runOptions) (string, error) {
+	var inputs any
+	switch step {
+	case "exec":
+		execInputs := []any{runBlock.Exec.Command, runBlock.Exec.Env}
+		if opts != nil && opts.Exec != nil {
+			execInputs = append(execInputs, opts.Exec)
+			if opts.Exec.hasScriptFile() {
+				content, err := afero.ReadFile(dr.Fs, dr.projectPath(*opts.Exec.ScriptFile))
+				if err != nil {
+					return "", fmt.Errorf("failed to read script file: %w", err)
+				}
+				sum := sha256.Sum256(content)
+				execInputs = append(execInputs, hex.EncodeToString(sum[:]))
+			}
+		}
+		inputs = execInputs
+	case "python":
+		var pythonOpts *pythonOptions
+		if opts != nil {
+			pythonOpts = opts.Python
+		}
+		env, err := dr.pythonEnvironment(runBlock.Python, pythonOpts)
+		if err != nil {
+			return "", err
+		}
+		pythonInputs := []any{runBlock.Python.Script, runBlock.Python.Env, env.Name, env.Interpreter}
+		if pythonOpts != nil {
+			pythonInputs = append(pythonInputs, pythonOpts)
+		}
+		inputs = pythonInputs
+	case "llm":
+		chat := runBlock.Chat
+		inputs = []any{chat.Model, chat.Prompt, chat.Files, chat.JSONResponse, chat.JSONResponseKeys}
+	case "client":
+		client := runBlock.HTTPClient
+		inputs = []any{client.Method, client.Url, client.Headers, client.Params, client.Data}
+	default:
+		return "", fmt.Errorf("unsupported step: %s", step)
+	}
+
+	// encoding/json sorts map keys, so equal inputs always produce the same document.
+	data, err := json.Marshal(map[string]any{"actionID": actionID, "step": step, "inputs": inputs})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newCacheEntry captures the output of a completed step.
+func newCacheEntry(step string, runBlock *pklRes.ResourceAction) *cacheEntry {
+	entry := &cacheEntry{CreatedAt: time.Now()}
+	switch step {
+	case "exec":
+		entry.Stdout, entry.Stderr, entry.ExitCode = runBlock.Exec.Stdout, runBlock.Exec.Stderr, runBlock.Exec.ExitCode
+	case "python":
+		entry.Stdout, entry.Stderr, entry.ExitCode = runBlock.Python.Stdout, runBlock.Python.Stderr, runBlock.Python.ExitCode
+	case "llm":
+		entry.Response = runBlock.Chat.Response
+	case "client":
+		if response := runBlock.HTTPClient.Response; response != nil {
+			entry.Body, entry.Headers = response.Body, response.Headers
+		}
+	}
+	return entry
+}
+
+// restoreCachedStep writes the cached output of a step to the output file of its type.
+func (dr *DependencyResolver) restoreCachedStep(actionID, step string, runBlock *pklRes.ResourceAction, entry *cacheEntry) error {
+	switch step {
+	case "exec":
+		runBlock.Exec.Stdout, runBlock.Exec.Stderr, runBlock.Exec.ExitCode = entry.Stdout, entry.Stderr, entry.ExitCode
+		return dr.AppendExecEntry(actionID, runBlock.Exec)
+	case "python":
+		runBlock.Python.Stdout, runBlock.Python.Stderr, runBlock.Python.ExitCode = entry.Stdout, entry.Stderr, entry.ExitCode
+		var run pythonRun
+		if entry.Python != nil {
+			run = *entry.Python
+		}
+		return dr.AppendPythonEntry(actionID, runBlock.Python, run)
+	case "llm":
+		runBlock.Chat.Response = entry.Response
+		return dr.AppendChatEntry(actionID, runBlock.Chat)
+	case "client":
+		if runBlock.HTTPClient.Response == nil {
+			runBlock.HTTPClient.Response = &pklHTTP.ResponseBlock{}
+		}
+		runBlock.HTTPClient.Response.Body, runBlock.HTTPClient.Response.Headers = entry.Body, entry.Headers
+		return dr.AppendHTTPEntry(actionID, runBlock.HTTPClient)
+	default:
+		return fmt.Errorf("unsupported step: %s", step)
+	}
+}
+
+func (dr *DependencyResolver) cacheEntryPath(key string) string {
+	return filepath.Join(dr.CacheDir, key+".json")
+}
+
+// loadCacheEntry returns the cache entry stored under key, or nil when there is no entry or it
+// is older than ttl. Expired entries are removed.
+func (dr *DependencyResolver) loadCacheEntry(key string, ttl time.Duration) *cacheEntry {
+	path := dr.cacheEntryPath(key)
+	data, err := afero.ReadFile(dr.Fs, path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			dr.Logger.Warn("unable to read cache entry", "path", path, "error", err)
+		}
+		return nil
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		dr.Logger.Warn("ignoring corrupt cache entry", "path", path, "error", err)
+		return nil
+	}
+
+	if ttl > 0 && time.Since(entry.CreatedAt) > ttl {
+		if err := dr.Fs.Remove(path); err != nil {
+			dr.Logger.Warn("unable to remove expired cache entry", "path", path, "error", err)
+		}
+		return nil
+	}
+
+	return &entry
+}
+
+// storeCacheEntry writes the entry under key. The entry is written to a temporary file first,
+// so that concurrent requests never read a partially written entry.
+func (dr *DependencyResolver) storeCacheEntry(key string, entry *cacheEntry) error {
+	if err := dr.Fs.MkdirAll(dr.CacheDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	path := dr.cacheEntryPath(key)
+	tmpPath := path + "." + dr.RequestID + ".tmp"
+	if err := afero.WriteFile(dr.Fs, tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return dr.Fs.Rename(tmpPath, path)
+}
@@ -0,0 +1,181 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kdeps/kdeps/pkg/workflow"
+	pklExec "github.com/kdeps/schema/gen/exec"
+	pklPython "github.com/kdeps/schema/gen/python"
+	pklRes "github.com/kdeps/schema/gen/resource"
+	pklWf "github.com/kdeps/schema/gen/workflow"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheSettings(t *testing.T) {
+	t.Parallel()
+
+	dr := &DependencyResolver{Settings: &workflow.Settings{Cache: workflow.CacheSettings{Resources: []string{"embed", "lookup"}, TTL: 60}}}
+
+	assert.Equal(t, cacheSettings{enabled: true, ttl: time.Minute}, dr.cacheSettings("lookup", nil))
+	assert.False(t, dr.cacheSettings("other", nil).enabled)
+
+	ttl := 5
+	assert.Equal(t, cacheSettings{enabled: true, ttl: 5 * time.Second}, dr.cacheSettings("other", &cachePolicy{TTL: &ttl}))
+
+	disabled := false
+	assert.False(t, dr.cacheSettings("embed", &cachePolicy{Enabled: &disabled}).enabled)
+
+	dr.Workflow = &pklWf.WorkflowImpl{Name: "agent", Version: "1.0.0"}
+	assert.True(t, dr.cacheSettings("@agent/lookup:1.0.0", nil).enabled)
+	assert.False(t, dr.cacheSettings("@other/lookup:1.0.0", nil).enabled)
+}
+
+func TestStepCacheKey(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	runBlock := func(command string, env map[string]string) *pklRes.ResourceAction {
+		return &pklRes.ResourceAction{Exec: &pklExec.ResourceExec{Command: command, Env: &env}}
+	}
+
+	key, err := dr.stepCacheKey("action", "exec", runBlock("echo hi", map[string]string{"A": "1", "B": "2"}), nil)
+	require.NoError(t, err)
+
+	same, err := dr.stepCacheKey("action", "exec", runBlock("echo hi", map[string]string{"B": "2", "A": "1"}), nil)
+	require.NoError(t, err)
+	assert.Equal(t, key, same)
+
+	otherEnv, err := dr.stepCacheKey("action", "exec", runBlock("echo hi", map[string]string{"A": "2"}), nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, otherEnv)
+
+	otherAction, err := dr.stepCacheKey("other", "exec", runBlock("echo hi", map[string]string{"A": "1", "B": "2"}), nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, otherAction)
+
+	_, err = dr.stepCacheKey("action", "unknown", runBlock("echo hi", nil), nil)
+	assert.Error(t, err)
+
+	t.Run("ScriptFileContent", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.WorkflowDir = "/agent/workflow"
+		script := "scripts/run.sh"
+		opts := &runOptions{Exec: &execOptions{ScriptFile: &script}}
+		require.NoError(t, afero.WriteFile(dr.Fs, "/agent/workflow/scripts/run.sh", []byte("echo one"), 0o644))
+
+		key, err := dr.stepCacheKey("action", "exec", runBlock("", nil), opts)
+		require.NoError(t, err)
+
+		require.NoError(t, afero.WriteFile(dr.Fs, "/agent/workflow/scripts/run.sh", []byte("echo two"), 0o644))
+		edited, err := dr.stepCacheKey("action", "exec", runBlock("", nil), opts)
+		require.NoError(t, err)
+		assert.NotEqual(t, key, edited)
+
+		require.NoError(t, dr.Fs.Remove("/agent/workflow/scripts/run.sh"))
+		_, err = dr.stepCacheKey("action", "exec", runBlock("", nil), opts)
+		assert.Error(t, err)
+	})
+
+	t.Run("PythonEnvironment", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.WorkflowDir = "/agent/workflow"
+		for _, venv := range []string{"one", "two"} {
+			require.NoError(t, afero.WriteFile(dr.Fs, "/agent/workflow/"+venv+"/bin/python", nil, 0o755))
+		}
+		runBlock := &pklRes.ResourceAction{Python: &pklPython.ResourcePython{Script: "print(1)"}}
+		venv := func(path string) *runOptions { return &runOptions{Python: &pythonOptions{VirtualEnv: &path}} }
+
+		system, err := dr.stepCacheKey("action", "python", runBlock, nil)
+		require.NoError(t, err)
+		one, err := dr.stepCacheKey("action", "python", runBlock, venv("one"))
+		require.NoError(t, err)
+		two, err := dr.stepCacheKey("action", "python", runBlock, venv("two"))
+		require.NoError(t, err)
+		assert.NotEqual(t, system, one)
+		assert.NotEqual(t, one, two)
+
+		_, err = dr.stepCacheKey("action", "python", runBlock, venv("missing"))
+		assert.Error(t, err)
+	})
+}
+
+func TestCacheEntries(t *testing.T) {
+	t.Parallel()
+
+	t.Run("StoreAndLoad", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		stdout := "hello"
+		require.NoError(t, dr.storeCacheEntry("key", &cacheEntry{CreatedAt: time.Now(), Stdout: &stdout}))
+
+		entry := dr.loadCacheEntry("key", time.Minute)
+		require.NotNil(t, entry)
+		assert.Equal(t, "hello", *entry.Stdout)
+		assert.Nil(t, dr.loadCacheEntry("missing", time.Minute))
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		require.NoError(t, dr.storeCacheEntry("key", &cacheEntry{CreatedAt: time.Now().Add(-time.Hour)}))
+
+		assert.Nil(t, dr.loadCacheEntry("key", time.Minute))
+		exists, err := afero.Exists(dr.Fs, dr.cacheEntryPath("key"))
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+}
+
+func TestRunResourceStep(t *testing.T) {
+	t.Parallel()
+
+	retry := retrySettings{maxAttempts: 1}
+	cache := cacheSettings{enabled: true, ttl: time.Minute}
+
+	t.Run("StoresOutputOnMiss", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		runBlock := &pklRes.ResourceAction{Exec: &pklExec.ResourceExec{Command: "echo hi"}}
+
+		err := dr.runResourceStep(context.Background(), "action", "exec", runBlock, nil, nil, retry, cache, func(ctx context.Context) error {
+			dr.runStep(ctx, "action", "exec", func(context.Context) error {
+				stdout := "hi"
+				runBlock.Exec.Stdout = &stdout
+				return nil
+			})
+			return nil
+		})
+		require.NoError(t, err)
+
+		key, err := dr.stepCacheKey("action", "exec", runBlock, nil)
+		require.NoError(t, err)
+		entry := dr.loadCacheEntry(key, time.Minute)
+		require.NotNil(t, entry)
+		assert.Equal(t, "hi", *entry.Stdout)
+		assert.False(t, dr.statuses["action"].cached)
+	})
+
+	t.Run("DoesNotCacheServerErrors", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		runBlock := &pklRes.ResourceAction{Exec: &pklExec.ResourceExec{Command: "echo hi"}}
+
+		err := dr.runResourceStep(context.Background(), "action", "exec", runBlock, nil, nil, retry, cache, func(ctx context.Context) error {
+			dr.runStep(ctx, "action", "exec", func(context.Context) error {
+				return &httpStatusError{statusCode: 500}
+			})
+			return nil
+		})
+		require.NoError(t, err)
+
+		key, err := dr.stepCacheKey("action", "exec", runBlock, nil)
+		require.NoError(t, err)
+		assert.Nil(t, dr.loadCacheEntry(key, time.Minute))
+	})
+}
@@ -0,0 +1,226 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// commandWaitDelay bounds how long a canceled command may keep its output pipes open.
+const commandWaitDelay = 5 * time.Second
+
+// maxLineSize bounds the size of a streamed output line. Longer lines are streamed in parts.
+const maxLineSize = 64 * 1024
+
+// commandTask describes a process started by a resource step.
+type commandTask struct {
+	Command string
+	Args    []string
+	Shell   bool
+	Env     []string
+	// Dir is the directory the process runs in. Defaults to the current directory.
+	Dir string
+	// Stdin, if set, is written to the standard input of the process.
+	Stdin *string
+	// OnLine, if set, is called with every line the process writes, as it is written. stream is
+	// "stdout" or "stderr".
+	OnLine func(stream, line string)
+	// Stdout, if set, also receives the standard output of the process as it is written.
+	Stdout io.Writer
+	// Sandbox, if set, limits the resources of the process.
+	Sandbox *sandboxPolicy
+}
+
+// commandResult holds the captured output of a finished process.
+type commandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// runCommand runs the task until it exits or ctx is done. The process is started in its own
+// process group, so canceling ctx kills the process together with every child it spawned.
+// A non-zero exit code is reported in the result and is not an error.
+func runCommand(ctx context.Context, task commandTask) (commandResult, error) {
+	if err := ctx.Err(); err != nil {
+		return commandResult{ExitCode: -1}, err
+	}
+
+	name, args := task.Command, task.Args
+	if task.Shell {
+		name = shellPath()
+		args = []string{"-c", task.Command}
+		if len(task.Args) > 0 {
+			// The args are passed to the script as positional parameters, so that the shell does
+			// not split or interpret them.
+			args = append([]string{"-c", task.Command + ` "$@"`, name}, task.Args...)
+		}
+	}
+	name, args = task.Sandbox.wrap(name, args)
+
+	dir, err := task.Sandbox.workingDir(task.Dir)
+	if err != nil {
+		return commandResult{ExitCode: -1}, err
+	}
+
+	limitCtx, count, stop := task.Sandbox.limits(ctx)
+	defer stop()
+
+	cmd := exec.CommandContext(limitCtx, name, args...)
+	cmd.Env = mergeEnv(os.Environ(), task.Env)
+	cmd.Dir = dir
+	cmd.WaitDelay = commandWaitDelay
+	setProcessGroup(cmd)
+	if task.Stdin != nil {
+		cmd.Stdin = strings.NewReader(*task.Stdin)
+	}
+	if task.Sandbox.denyNetwork() {
+		if err := isolateNetwork(cmd); err != nil {
+			return commandResult{ExitCode: -1}, err
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	stdoutWriters := []io.Writer{&stdout, countingWriter{count}}
+	stderrWriters := []io.Writer{&stderr, countingWriter{count}}
+	if task.Stdout != nil {
+		stdoutWriters = append(stdoutWriters, task.Stdout)
+	}
+	if task.OnLine != nil {
+		stdoutLines := &lineWriter{stream: "stdout", onLine: task.OnLine}
+		stderrLines := &lineWriter{stream: "stderr", onLine: task.OnLine}
+		defer stdoutLines.flush()
+		defer stderrLines.flush()
+		stdoutWriters = append(stdoutWriters, stdoutLines)
+		stderrWriters = append(stderrWriters, stderrLines)
+	}
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+
+	if err := cmd.Start(); err != nil {
+		if task.Sandbox.denyNetwork() {
+			err = networkIsolationError(err)
+		}
+		return commandResult{ExitCode: -1}, err
+	}
+
+	result := commandResult{}
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return commandResult{ExitCode: -1}, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	return result, task.Sandbox.exceeded(limitCtx, cpuTimeExceeded(cmd.ProcessState))
+}
+
+// shellPath returns the shell that runs the scripts of the steps: bash when it is installed, and
+// the POSIX shell of the system otherwise.
+func shellPath() string {
+	if path, err := exec.LookPath("bash"); err == nil {
+		return path
+	}
+	return "/bin/sh"
+}
+
+// lineWriter calls onLine with every complete line written to it.
+type lineWriter struct {
+	stream string
+	onLine func(stream, line string)
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			if len(w.buf) >= maxLineSize {
+				w.flush()
+			}
+			return len(p), nil
+		}
+		w.onLine(w.stream, strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush passes the incomplete last line, if any, to onLine.
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.onLine(w.stream, string(w.buf))
+		w.buf = nil
+	}
+}
+
+// mergeEnv returns base with the KEY=VALUE pairs of overrides replacing the matching keys.
+func mergeEnv(base, overrides []string) []string {
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	overridden := make(map[string]bool, len(overrides))
+	for _, kv := range overrides {
+		key, _, _ := strings.Cut(kv, "=")
+		overridden[key] = true
+	}
+
+	env := append([]string{}, overrides...)
+	for _, kv := range base {
+		key, _, _ := strings.Cut(kv, "=")
+		if !overridden[key] {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// exitCodeError is returned when a step process exits with a non-zero code.
+type exitCodeError struct {
+	exitCode int
+	stderr   string
+}
+
+func (e *exitCodeError) Error() string {
+	msg := fmt.Sprintf("process exited with code %d", e.exitCode)
+	if stderr := lastLine(e.stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// failOnNonZeroExit reports whether a non-zero exit code fails the step of a resource. The
+// failOnNonZeroExit option of the run block, when set, overrides the workflow-wide setting.
+func (dr *DependencyResolver) failOnNonZeroExit(option *bool) bool {
+	if option != nil {
+		return *option
+	}
+	return dr.settings().FailOnNonZeroExit
+}
+
+// checkExitCode returns an exitCodeError for a non-zero exit code when the resource requires it.
+func (dr *DependencyResolver) checkExitCode(failOnNonZeroExit *bool, result commandResult) error {
+	if result.ExitCode == 0 || !dr.failOnNonZeroExit(failOnNonZeroExit) {
+		return nil
+	}
+	return &exitCodeError{exitCode: result.ExitCode, stderr: result.Stderr}
+}
+
+// lastLine returns the last non-empty line of s.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
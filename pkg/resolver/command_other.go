@@ -0,0 +1,17 @@
+//go:build !unix
+
+package resolver
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on platforms without process groups; canceling the command only
+// kills the process itself.
+func setProcessGroup(_ *exec.Cmd) {}
+
+// cpuTimeExceeded always reports false on platforms without CPU time limits.
+func cpuTimeExceeded(_ *os.ProcessState) bool {
+	return false
+}
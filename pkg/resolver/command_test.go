@@ -0,0 +1,134 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommand(t *testing.T) {
+	t.Parallel()
+
+	t.Run("CapturesOutput", func(t *testing.T) {
+		t.Parallel()
+		result, err := runCommand(context.Background(), commandTask{
+			Command: "echo $GREETING; echo oops >&2; exit 3",
+			Shell:   true,
+			Env:     []string{"GREETING=hello"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", result.Stdout)
+		assert.Equal(t, "oops\n", result.Stderr)
+		assert.Equal(t, 3, result.ExitCode)
+	})
+
+	t.Run("ShellArgs", func(t *testing.T) {
+		t.Parallel()
+		result, err := runCommand(context.Background(), commandTask{
+			Command: "printf '%s|'",
+			Args:    []string{"two words", "$(echo injected); exit 7", "*"},
+			Shell:   true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "two words|$(echo injected); exit 7|*|", result.Stdout)
+		assert.Equal(t, 0, result.ExitCode)
+	})
+
+	t.Run("ArgsStdinAndDir", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		stdin := "from stdin"
+		result, err := runCommand(context.Background(), commandTask{
+			Command: "sh",
+			Args:    []string{"-c", `pwd; cat; echo "$1"`, "sh", "$(not expanded); echo"},
+			Dir:     dir,
+			Stdin:   &stdin,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, dir+"\nfrom stdin$(not expanded); echo\n", result.Stdout)
+	})
+
+	t.Run("StreamsLines", func(t *testing.T) {
+		t.Parallel()
+		var (
+			mu    sync.Mutex
+			lines []string
+		)
+		result, err := runCommand(context.Background(), commandTask{
+			Command: "echo first; echo oops >&2; printf last",
+			Shell:   true,
+			OnLine: func(stream, line string) {
+				mu.Lock()
+				defer mu.Unlock()
+				lines = append(lines, stream+": "+line)
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "first\nlast", result.Stdout)
+		assert.ElementsMatch(t, []string{"stdout: first", "stderr: oops", "stdout: last"}, lines)
+	})
+
+	t.Run("CancelKillsProcessGroup", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		// The background sleep keeps the output pipes open unless the whole group is killed.
+		_, err := runCommand(ctx, commandTask{Command: "sleep 30 & sleep 30", Shell: true})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), commandWaitDelay)
+	})
+}
+
+func TestShellPath(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	assert.Equal(t, "/bin/sh", shellPath())
+}
+
+func TestMergeEnv(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, mergeEnv([]string{"A=1"}, nil))
+	assert.Equal(t, []string{"A=2", "B=1"}, mergeEnv([]string{"A=1", "B=1"}, []string{"A=2"}))
+}
+
+func TestCheckExitCode(t *testing.T) {
+	t.Parallel()
+
+	failing := commandResult{ExitCode: 2, Stderr: "warming up\nfile not found\n"}
+
+	t.Run("DisabledByDefault", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		assert.NoError(t, dr.checkExitCode(nil, failing))
+	})
+
+	t.Run("EnabledByWorkflow", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.Settings.FailOnNonZeroExit = true
+		err := dr.checkExitCode(nil, failing)
+
+		var exitErr *exitCodeError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, 2, exitErr.exitCode)
+		assert.Equal(t, "process exited with code 2: file not found", err.Error())
+		assert.NoError(t, dr.checkExitCode(nil, commandResult{}))
+	})
+
+	t.Run("OverriddenByRunOption", func(t *testing.T) {
+		t.Parallel()
+		enabled, disabled := true, false
+		dr := newTestResolver(t)
+		dr.Settings.FailOnNonZeroExit = true
+		assert.NoError(t, dr.checkExitCode(&disabled, failing))
+
+		dr.Settings.FailOnNonZeroExit = false
+		assert.Error(t, dr.checkExitCode(&enabled, failing))
+	})
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package resolver
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a new process group and kills the whole group on cancellation.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// cpuTimeExceeded reports whether the process, or the command its shell ran last, was killed for
+// exceeding its CPU time limit.
+func cpuTimeExceeded(state *os.ProcessState) bool {
+	if state == nil {
+		return false
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() && status.Signal() == syscall.SIGXCPU {
+		return true
+	}
+	return state.ExitCode() == 128+int(syscall.SIGXCPU)
+}
@@ -0,0 +1,223 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/kdeps/kdeps/pkg/utils"
+)
+
+// External properties through which a forEach iteration passes its item to the item module.
+const (
+	forEachItemProperty  = "kdeps.forEach.item"
+	forEachIndexProperty = "kdeps.forEach.index"
+)
+
+// forEachPolicy is the forEach block of a resource run block.
+type forEachPolicy struct {
+	// Items the steps of the run block are executed for, once per item.
+	Items *[]any `pkl:"items"`
+
+	// Maximum number of items processed at the same time. Defaults to 1.
+	Concurrency *int `pkl:"concurrency"`
+}
+
+// forEachIterationID returns the actionID under which the outputs of an iteration are stored.
+func forEachIterationID(actionID string, index int) string {
+	return fmt.Sprintf("%s#%d", actionID, index)
+}
+
+// formatForEachItem returns the item as exposed by item.current(): strings are passed as is and
+// every other value is encoded as JSON.
+func formatForEachItem(item any) (string, error) {
+	if s, ok := item.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode forEach item: %w", err)
+	}
+	return string(data), nil
+}
+
+// withForEachItem exposes the item and index of an iteration to the evaluated resource.
+func withForEachItem(index int, item string) func(options *pkl.EvaluatorOptions) {
+	return func(options *pkl.EvaluatorOptions) {
+		properties := make(map[string]string, len(options.Properties)+2)
+		for k, v := range options.Properties {
+			properties[k] = v
+		}
+		properties[forEachItemProperty] = item
+		properties[forEachIndexProperty] = strconv.Itoa(index)
+		options.Properties = properties
+	}
+}
+
+// processForEach runs the steps of the run block once per item of the forEach block, with at
+// most concurrency items at a time. Every iteration evaluates the resource again with its item,
+// and the output of its last step is recorded at the index of the item. The first error cancels
+// the iterations that have not started yet.
+func (dr *DependencyResolver) processForEach(ctx context.Context, res ResourceNodeEntry, policy *forEachPolicy, retry retrySettings, cache cacheSettings) error {
+	var items []any
+	if policy.Items != nil {
+		items = *policy.Items
+	}
+	concurrency := 1
+	if policy.Concurrency != nil && *policy.Concurrency > 1 {
+		concurrency = *policy.Concurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+		results  = make([]string, len(items))
+	)
+
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	dr.Logger.Info("processing forEach items", "actionID", res.ActionID, "items", len(items), "concurrency", concurrency)
+
+	for i, item := range items {
+		value, err := formatForEachItem(item)
+		if err != nil {
+			fail(&resourceError{code: 500, message: err.Error(), fatal: true})
+			break
+		}
+
+		wg.Add(1)
+		go func(index int, value string) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			defer func() {
+				if r := recover(); r != nil {
+					buf := make([]byte, 1<<16)
+					stackSize := runtime.Stack(buf, false)
+					dr.Logger.Error("panic recovered while processing forEach item", "actionID", res.ActionID, "index", index, "panic", r)
+					dr.Logger.Error("stack trace", "stack", string(buf[:stackSize]))
+					fail(&resourceError{code: 500, message: fmt.Sprintf("panic in resource %s: %v", res.ActionID, r), fatal: true})
+				}
+			}()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			output, err := dr.processForEachItem(ctx, res, index, value, retry, cache)
+			if err != nil {
+				fail(err)
+				return
+			}
+			results[index] = output
+		}(i, value)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := dr.recordForEachResults(res.ActionID, results); err != nil {
+		return &resourceError{code: 500, message: err.Error(), fatal: true}
+	}
+	return nil
+}
+
+// processForEachItem evaluates the resource for one item and runs its steps under the
+// iteration actionID. It returns the output of the last step that ran.
+func (dr *DependencyResolver) processForEachItem(ctx context.Context, res ResourceNodeEntry, index int, item string, retry retrySettings, cache cacheSettings) (string, error) {
+	rsc, opts, err := dr.loadResource(ctx, res.File, withForEachItem(index, item))
+	if err != nil {
+		return "", &resourceError{code: 500, message: err.Error(), fatal: true}
+	}
+	if rsc.Run == nil {
+		return "", nil
+	}
+
+	step, err := dr.runSteps(ctx, forEachIterationID(res.ActionID, index), rsc.Run, opts, retry, cache)
+	if err != nil {
+		return "", err
+	}
+
+	entry := newCacheEntry(step, rsc.Run)
+	for _, output := range []*string{entry.Stdout, entry.Response, entry.Body} {
+		if output != nil {
+			return *output, nil
+		}
+	}
+	return "", nil
+}
+
+// recordForEachResults stores the ordered outputs of a forEach resource and rewrites the item
+// output.
+func (dr *DependencyResolver) recordForEachResults(actionID string, results []string) error {
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
+
+	if dr.forEachResults == nil {
+		dr.forEachResults = make(map[string][]string)
+	}
+	dr.forEachResults[actionID] = results
+
+	return dr.writeItemOutput()
+}
+
+// writeItemOutput writes the item output module, which resources import as `item`.
+// The caller must hold outputMu.
+func (dr *DependencyResolver) writeItemOutput() error {
+	ids := make([]string, 0, len(dr.forEachResults))
+	for id := range dr.forEachResults {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var pklContent strings.Builder
+	pklContent.WriteString("/// The outputs of the forEach resources, in item order, keyed by actionID.\n")
+	pklContent.WriteString("resultLists: Mapping<String, Listing<String>> = new {\n")
+	for _, id := range ids {
+		pklContent.WriteString(fmt.Sprintf("  [%s] {\n", utils.PklString(id)))
+		for _, result := range dr.forEachResults[id] {
+			pklContent.WriteString(fmt.Sprintf("    %s\n", utils.PklString(result)))
+		}
+		pklContent.WriteString("  }\n")
+	}
+	pklContent.WriteString("}\n\n")
+
+	pklContent.WriteString("/// Retrieves the item of the current forEach iteration. Items that are not strings are encoded\n")
+	pklContent.WriteString("/// as JSON. Empty outside of a forEach iteration.\n")
+	pklContent.WriteString(fmt.Sprintf("function current(): String = read?(\"prop:%s\") ?? \"\"\n\n", forEachItemProperty))
+	pklContent.WriteString("/// Retrieves the index of the current forEach iteration. -1 outside of a forEach iteration.\n")
+	pklContent.WriteString(fmt.Sprintf("function index(): Int = (read?(\"prop:%s\") ?? \"-1\").toInt()\n\n", forEachIndexProperty))
+	pklContent.WriteString("/// Retrieves the outputs of the forEach resource [actionID], in item order.\n")
+	pklContent.WriteString("function results(actionID: String): List<String> =\n")
+	pklContent.WriteString("  resultLists.getOrNull(actionID)?.toList() ?? List()\n")
+
+	return dr.writeOutput("item", pklContent.String())
+}
@@ -0,0 +1,56 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachItems(t *testing.T) {
+	t.Parallel()
+
+	t.Run("FormatItem", func(t *testing.T) {
+		t.Parallel()
+		value, err := formatForEachItem("/uploads/a.png")
+		require.NoError(t, err)
+		assert.Equal(t, "/uploads/a.png", value)
+
+		value, err = formatForEachItem(map[string]any{"name": "a", "size": 2})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"name": "a", "size": 2}`, value)
+	})
+
+	t.Run("EvaluatorProperties", func(t *testing.T) {
+		t.Parallel()
+		options := &pkl.EvaluatorOptions{Properties: map[string]string{"other": "value"}}
+		withForEachItem(3, "row")(options)
+
+		assert.Equal(t, map[string]string{
+			"other":              "value",
+			forEachItemProperty:  "row",
+			forEachIndexProperty: "3",
+		}, options.Properties)
+		assert.Equal(t, "@agent/fetch:1.0.0#3", forEachIterationID("@agent/fetch:1.0.0", 3))
+	})
+}
+
+func TestProcessForEachRecordsResults(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+
+	items := []any{}
+	err := dr.processForEach(context.Background(), ResourceNodeEntry{ActionID: "fetch"}, &forEachPolicy{Items: &items}, retrySettings{}, cacheSettings{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, dr.forEachResults["fetch"])
+
+	require.NoError(t, dr.recordForEachResults("summarize", []string{"first", "second \"quoted\""}))
+	content, err := dr.readOutput("item")
+	require.NoError(t, err)
+	assert.Contains(t, content, `["fetch"] {`)
+	assert.Contains(t, content, `"second \"quoted\""`)
+	assert.Contains(t, content, "function results(actionID: String): List<String>")
+}
@@ -20,164 +20,50 @@ import (
 	"github.com/spf13/afero"
 )
 
-func (dr *DependencyResolver) PrependDynamicImports(pklFile string) error {
-	// Read the file content
-	content, err := afero.ReadFile(dr.Fs, pklFile)
-	if err != nil {
-		return err
-	}
-	contentStr := string(content)
-
-	// Define a regular expression to match "{{value}}"
-	re := regexp.MustCompile(`\@\((.*)\)`)
-
-	// Define ImportConfig struct
-	type ImportConfig struct {
-		Alias string
-		Check bool // Flag to specify if the file existence should be checked
-	}
-
-	// Import configurations
-	importCheck := map[string]ImportConfig{
-		"pkl:json":     {Alias: "", Check: false},
-		"pkl:test":     {Alias: "", Check: false},
-		"pkl:math":     {Alias: "", Check: false},
-		"pkl:platform": {Alias: "", Check: false},
-		"pkl:semver":   {Alias: "", Check: false},
-		"pkl:shell":    {Alias: "", Check: false},
-		"pkl:xml":      {Alias: "", Check: false},
-		"pkl:yaml":     {Alias: "", Check: false},
-		fmt.Sprintf("package://schema.kdeps.com/core@%s#/Document.pkl", schema.SchemaVersion(dr.Context)): {Alias: "document", Check: false},
-		fmt.Sprintf("package://schema.kdeps.com/core@%s#/Skip.pkl", schema.SchemaVersion(dr.Context)):     {Alias: "skip", Check: false},
-		fmt.Sprintf("package://schema.kdeps.com/core@%s#/Utils.pkl", schema.SchemaVersion(dr.Context)):    {Alias: "utils", Check: false},
-		filepath.Join(dr.ActionDir, "/llm/"+dr.RequestID+"__llm_output.pkl"):                              {Alias: "llm", Check: true},
-		filepath.Join(dr.ActionDir, "/client/"+dr.RequestID+"__client_output.pkl"):                        {Alias: "client", Check: true},
-		filepath.Join(dr.ActionDir, "/exec/"+dr.RequestID+"__exec_output.pkl"):                            {Alias: "exec", Check: true},
-		filepath.Join(dr.ActionDir, "/python/"+dr.RequestID+"__python_output.pkl"):                        {Alias: "python", Check: true},
-		filepath.Join(dr.ActionDir, "/data/"+dr.RequestID+"__data_output.pkl"):                            {Alias: "data", Check: true},
-		dr.RequestPklFile: {Alias: "request", Check: true},
-	}
-
-	// Helper to check file existence
-	fileExists := func(file string) bool {
-		exists, _ := afero.Exists(dr.Fs, file)
-		return exists
-	}
-
-	// Helper to generate import lines
-	generateImportLine := func(file, alias string) string {
-		if alias == "" {
-			return fmt.Sprintf(`import "%s"`, file)
-		}
-		return fmt.Sprintf(`import "%s" as %s`, file, alias)
-	}
-
-	// Construct the dynamic import lines
-	var importBuilder strings.Builder
-	for file, config := range importCheck {
-		if config.Check && !fileExists(file) {
+// PrepareImportFiles creates the empty output modules that the resources import.
+func (dr *DependencyResolver) PrepareImportFiles() error {
+	outputs := map[string]struct {
+		schemaFile string
+		blockType  string
+	}{
+		"llm":    {schemaFile: "LLM.pkl", blockType: "resources"},
+		"client": {schemaFile: "HTTP.pkl", blockType: "resources"},
+		"exec":   {schemaFile: "Exec.pkl", blockType: "resources"},
+		"python": {schemaFile: "Python.pkl", blockType: "resources"},
+		"data":   {schemaFile: "Data.pkl", blockType: "files"}, // Special case for "data"
+	}
+
+	for key, output := range outputs {
+		if dr.hasOutput(key) {
 			continue
 		}
 
-		importLine := generateImportLine(file, config.Alias)
-		if !strings.Contains(contentStr, importLine) {
-			importBuilder.WriteString(importLine + "\n")
+		content := fmt.Sprintf("extends \"%s\"\n\n%s {\n}\n", schema.VendoredModule(output.schemaFile), output.blockType)
+		if err := dr.writeOutput(key, content); err != nil {
+			return fmt.Errorf("failed to create %s output: %w", key, err)
 		}
 	}
 
-	// If there are no new imports, return early
-	importFiles := importBuilder.String()
-	if importFiles == "" {
-		return nil
-	}
-
-	// Add the imports after the "amends" line
-	amendsIndex := strings.Index(contentStr, "amends")
-	if amendsIndex != -1 {
-		amendsLineEnd := strings.Index(contentStr[amendsIndex:], "\n") + amendsIndex + 1
-		newContent := contentStr[:amendsLineEnd] + importFiles + contentStr[amendsLineEnd:]
-		newContent = re.ReplaceAllString(newContent, `\($1)`)
+	// The status, item and error outputs are plain modules maintained by the resolver itself.
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
 
-		// Write the updated content back to the file
-		err = afero.WriteFile(dr.Fs, pklFile, []byte(newContent), 0o644)
-		if err != nil {
-			return err
-		}
+	if err := dr.writeStatusOutput(); err != nil {
+		return err
 	}
-
-	return nil
-}
-
-func (dr *DependencyResolver) PrepareImportFiles() error {
-	files := map[string]string{
-		"llm":    filepath.Join(dr.ActionDir, "/llm/"+dr.RequestID+"__llm_output.pkl"),
-		"client": filepath.Join(dr.ActionDir, "/client/"+dr.RequestID+"__client_output.pkl"),
-		"exec":   filepath.Join(dr.ActionDir, "/exec/"+dr.RequestID+"__exec_output.pkl"),
-		"python": filepath.Join(dr.ActionDir, "/python/"+dr.RequestID+"__python_output.pkl"),
-		"data":   filepath.Join(dr.ActionDir, "/data/"+dr.RequestID+"__data_output.pkl"),
+	if err := dr.writeItemOutput(); err != nil {
+		return err
 	}
+	return dr.writeErrorOutput(nil)
+}
 
-	for key, file := range files {
-		dir := filepath.Dir(file)
-		if err := dr.Fs.MkdirAll(dir, 0o755); err != nil {
-			return fmt.Errorf("failed to create directory for %s: %w", key, err)
-		}
-
-		// Check if the file exists, if not, create it
-		exists, err := afero.Exists(dr.Fs, file)
-		if err != nil {
-			return fmt.Errorf("failed to check if %s file exists: %w", key, err)
-		}
-
-		if !exists {
-			// Create the file if it doesn't exist
-			f, err := dr.Fs.Create(file)
-			if err != nil {
-				return fmt.Errorf("failed to create %s file: %w", key, err)
-			}
-			defer f.Close()
-
-			// Use packageURL in the header writing
-			packageURL := fmt.Sprintf("package://schema.kdeps.com/core@%s#/", schema.SchemaVersion(dr.Context))
-			writer := bufio.NewWriter(f)
-
-			var schemaFile, blockType string
-			switch key {
-			case "exec":
-				schemaFile = "Exec.pkl"
-				blockType = "resources"
-			case "python":
-				schemaFile = "Python.pkl"
-				blockType = "resources"
-			case "client":
-				schemaFile = "HTTP.pkl"
-				blockType = "resources"
-			case "llm":
-				schemaFile = "LLM.pkl"
-				blockType = "resources"
-			case "data":
-				schemaFile = "Data.pkl"
-				blockType = "files" // Special case for "data"
-			}
-
-			// Write header using packageURL and schemaFile
-			if _, err := writer.WriteString(fmt.Sprintf("extends \"%s%s\"\n\n", packageURL, schemaFile)); err != nil {
-				return fmt.Errorf("failed to write header for %s: %w", key, err)
-			}
-
-			// Write the block (resources or files)
-			if _, err := writer.WriteString(blockType + " {\n}\n"); err != nil {
-				return fmt.Errorf("failed to write block for %s: %w", key, err)
-			}
-
-			// Flush the writer
-			if err := writer.Flush(); err != nil {
-				return fmt.Errorf("failed to flush output for %s: %w", key, err)
-			}
-		}
-	}
+// CreateRequestModule evaluates the request sections and stores the result as the request module,
+// which resources import as `request`.
+func (dr *DependencyResolver) CreateRequestModule(sections []string) error {
+	headerSection := fmt.Sprintf(`extends "%s"`, schema.VendoredModule("APIServerRequest.pkl"))
+	content := headerSection + "\n" + strings.Join(sections, "\n")
 
-	return nil
+	return dr.evaluateOutput("request", content, headerSection)
 }
 
 func (dr *DependencyResolver) PrepareWorkflowDir() error {
@@ -306,7 +192,7 @@ func (dr *DependencyResolver) AddPlaceholderImports(filePath string) error {
 		return err
 	}
 
-	if err := dr.AppendPythonEntry(actionID, pythonCmd); err != nil {
+	if err := dr.AppendPythonEntry(actionID, pythonCmd, pythonRun{}); err != nil {
 		return err
 	}
 
@@ -0,0 +1,135 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kdeps/kdeps/pkg/utils"
+)
+
+// errorPolicy is the onError block of a resource run block.
+type errorPolicy struct {
+	// ActionID of the resource that handles the failure. Defaults to the errorHandler of the workflow.
+	Handler *string `pkl:"handler"`
+
+	// Whether the resources that depend on the failed resource still run.
+	ContinueOnError *bool `pkl:"continueOnError"`
+}
+
+// failure is the failed resource exposed by the error output.
+type failure struct {
+	actionID string
+	step     string
+	message  string
+	code     int
+}
+
+// errorHandler returns the compiled actionID of the resource that handles the failures of a
+// resource with the given policy, or an empty string when there is none.
+func (dr *DependencyResolver) errorHandler(policy *errorPolicy) string {
+	if policy != nil && policy.Handler != nil {
+		return dr.compiledActionID(*policy.Handler)
+	}
+	return dr.compiledActionID(dr.settings().ErrorHandler)
+}
+
+// handleResourceError records the failure of a resource in the error output and runs its error
+// handler, whose outputs are stored under the actionID of the failed resource in place of its own.
+// It returns nil when the resource continues on error, and otherwise the failure, marked as
+// handled when the error handler created the API response.
+func (dr *DependencyResolver) handleResourceError(ctx context.Context, actionID string, policy *errorPolicy, err error) error {
+	handler := dr.errorHandler(policy)
+	continueOnError := policy != nil && policy.ContinueOnError != nil && *policy.ContinueOnError
+	if (handler == "" && !continueOnError) || handler == actionID || ctx.Err() != nil {
+		return err
+	}
+
+	resErr := &resourceError{code: 500, message: err.Error(), fatal: true}
+	errors.As(err, &resErr)
+
+	// Error handlers share the error output, so they run one at a time.
+	dr.errorMu.Lock()
+	defer dr.errorMu.Unlock()
+
+	dr.outputMu.Lock()
+	recordErr := dr.writeErrorOutput(&failure{actionID: actionID, step: resErr.step, message: resErr.message, code: resErr.code})
+	responses := dr.responses
+	dr.outputMu.Unlock()
+	if recordErr != nil {
+		dr.Logger.Error("failed to record resource failure", "actionID", actionID, "error", recordErr)
+		return err
+	}
+
+	if handler != "" {
+		dr.Logger.Warn("resource failed, running its error handler", "actionID", actionID, "handler", handler, "error", resErr.message)
+		if handlerErr := dr.runErrorHandler(ctx, handler, actionID); handlerErr != nil {
+			dr.Logger.Error("error handler failed", "actionID", actionID, "handler", handler, "error", handlerErr)
+			return err
+		}
+	}
+
+	if continueOnError {
+		dr.Logger.Warn("resource failed, continuing", "actionID", actionID, "error", resErr.message)
+		return nil
+	}
+
+	handled := *resErr
+	handled.handled = handler != "" && dr.APIServerMode && dr.responseCount() > responses
+	return &handled
+}
+
+// runErrorHandler runs the run blocks of the handler resource and stores their outputs under
+// the actionID of the failed resource.
+func (dr *DependencyResolver) runErrorHandler(ctx context.Context, handler, failedActionID string) error {
+	found := false
+	for _, res := range dr.Resources {
+		if res.ActionID != handler {
+			continue
+		}
+		found = true
+
+		rsc, opts, err := dr.loadResource(ctx, res.File)
+		if err != nil {
+			return err
+		}
+		if err := dr.runResource(ctx, ResourceNodeEntry{ActionID: failedActionID, File: res.File}, rsc, opts); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("error handler %s does not exist", handler)
+	}
+	return nil
+}
+
+// responseCount returns the number of API responses the resources of the request created, so
+// that a response created by the error handler can be told apart from an earlier one.
+func (dr *DependencyResolver) responseCount() int {
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
+
+	return dr.responses
+}
+
+// writeErrorOutput writes the error output module, which resources import as `error`. A nil
+// failure writes the module of a request without failures. The caller must hold outputMu.
+func (dr *DependencyResolver) writeErrorOutput(f *failure) error {
+	if f == nil {
+		f = &failure{}
+	}
+
+	var pklContent strings.Builder
+	pklContent.WriteString("/// The actionID of the failed resource. Empty when no resource has failed.\n")
+	pklContent.WriteString(fmt.Sprintf("actionID: String = %s\n\n", utils.PklString(f.actionID)))
+	pklContent.WriteString("/// The step of the run block that failed: preflight, exec, python, llm, client or response.\n")
+	pklContent.WriteString(fmt.Sprintf("step: String = %s\n\n", utils.PklString(f.step)))
+	pklContent.WriteString("/// The error message of the failure.\n")
+	pklContent.WriteString(fmt.Sprintf("message: String = %s\n\n", utils.PklString(dr.Secrets.Redact(f.message))))
+	pklContent.WriteString("/// The HTTP status code of the failure. 0 when no resource has failed.\n")
+	pklContent.WriteString(fmt.Sprintf("code: Int = %d\n", f.code))
+
+	return dr.writeOutput("error", pklContent.String())
+}
@@ -0,0 +1,61 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleResourceError(t *testing.T) {
+	t.Parallel()
+
+	stepErr := newStepError("exec", "Exec", "@agent/fetch:1.0.0", assert.AnError, false)
+	enabled := true
+
+	t.Run("ErrorHandler", func(t *testing.T) {
+		t.Parallel()
+		handler := "fallback"
+		dr := newTestResolver(t)
+		dr.Settings.ErrorHandler = "onFailure"
+
+		assert.Equal(t, "@agent/onFailure:1.0.0", dr.errorHandler(nil))
+		assert.Equal(t, "@agent/fallback:1.0.0", dr.errorHandler(&errorPolicy{Handler: &handler}))
+	})
+
+	t.Run("NoHandler", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+
+		err := dr.handleResourceError(context.Background(), "@agent/fetch:1.0.0", nil, stepErr)
+		assert.Equal(t, stepErr, err)
+		assert.False(t, dr.hasOutput("error"))
+	})
+
+	t.Run("ContinueOnError", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		stepErr := newStepError("exec", "Exec", "@agent/fetch:1.0.0", errors.New(`missing "C:\data" file`), false)
+
+		err := dr.handleResourceError(context.Background(), "@agent/fetch:1.0.0", &errorPolicy{ContinueOnError: &enabled}, stepErr)
+		require.NoError(t, err)
+
+		content, err := dr.readOutput("error")
+		require.NoError(t, err)
+		assert.Contains(t, content, `actionID: String = "@agent/fetch:1.0.0"`)
+		assert.Contains(t, content, `step: String = "exec"`)
+		assert.Contains(t, content, `message: String = "Exec failed for resource: @agent/fetch:1.0.0 - missing \"C:\\data\" file"`)
+		assert.Contains(t, content, "code: Int = 500")
+	})
+
+	t.Run("MissingHandler", func(t *testing.T) {
+		t.Parallel()
+		handler := "fallback"
+		dr := newTestResolver(t)
+
+		err := dr.handleResourceError(context.Background(), "@agent/fetch:1.0.0", &errorPolicy{Handler: &handler, ContinueOnError: &enabled}, stepErr)
+		assert.Equal(t, stepErr, err)
+	})
+}
@@ -0,0 +1,194 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/kdeps/kdeps/pkg/evaluator"
+	"github.com/kdeps/kdeps/pkg/schema"
+	"github.com/spf13/afero"
+)
+
+// outputScheme is the URI scheme under which the output modules of a request are imported, e.g.
+// `import "kdeps:exec" as exec`.
+const outputScheme = "kdeps"
+
+// outputAliases lists the output modules of a request by import alias.
+var outputAliases = []string{"llm", "client", "exec", "python", "data", "status", "item", "error", "request"}
+
+// outputReader is a pkl module reader that serves the output modules of a request from memory.
+type outputReader struct {
+	dr *DependencyResolver
+}
+
+func (r outputReader) Scheme() string {
+	return outputScheme
+}
+
+func (r outputReader) IsGlobbable() bool {
+	return false
+}
+
+func (r outputReader) HasHierarchicalUris() bool {
+	return false
+}
+
+func (r outputReader) IsLocal() bool {
+	return true
+}
+
+func (r outputReader) ListElements(url.URL) ([]pkl.PathElement, error) {
+	return nil, nil
+}
+
+func (r outputReader) Read(u url.URL) (string, error) {
+	return r.dr.readOutput(u.Opaque)
+}
+
+// evaluatorOptions returns the evaluator options that let modules amend the vendored schema and
+// import the output modules.
+func (dr *DependencyResolver) evaluatorOptions() []func(options *pkl.EvaluatorOptions) {
+	return []func(options *pkl.EvaluatorOptions){schema.WithVendoredSchema, pkl.WithModuleReader(outputReader{dr: dr})}
+}
+
+// moduleSource returns the source a resource or workflow file is evaluated from. It amends the
+// vendored schema, so that the options kdeps declares on top of the published schema can be set.
+func (dr *DependencyResolver) moduleSource(file string) (*pkl.ModuleSource, error) {
+	content, err := afero.ReadFile(dr.Fs, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return schema.Source(file, content), nil
+}
+
+// resourceSource returns the source a resource file is evaluated from. Resources use the standard
+// modules and the output modules of the request, such as exec, without importing them, and write
+// `@(...)` for string interpolation, so the source imports the modules the resource does not
+// import itself and turns the shorthand into Pkl interpolation. The file is left untouched.
+func (dr *DependencyResolver) resourceSource(file string) (*pkl.ModuleSource, error) {
+	source, err := dr.moduleSource(file)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := strings.ReplaceAll(source.Contents, "@(", `\(`)
+	imports := dr.resourceImports(contents)
+	lines := strings.SplitAfter(contents, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "amends") {
+			if !strings.HasSuffix(line, "\n") {
+				lines[i] += "\n"
+			}
+			lines[i] += imports
+			break
+		}
+	}
+	source.Contents = strings.Join(lines, "")
+	return source, nil
+}
+
+// resourceImports returns the import clauses of the modules the resources use without importing
+// them, leaving out the ones contents already has and the output modules that do not exist.
+func (dr *DependencyResolver) resourceImports(contents string) string {
+	version := schema.SchemaVersion(dr.Context)
+	imports := []string{
+		`import "pkl:json"`,
+		`import "pkl:test"`,
+		`import "pkl:math"`,
+		`import "pkl:platform"`,
+		`import "pkl:semver"`,
+		`import "pkl:shell"`,
+		`import "pkl:xml"`,
+		`import "pkl:yaml"`,
+		fmt.Sprintf(`import "package://schema.kdeps.com/core@%s#/Document.pkl" as document`, version),
+		fmt.Sprintf(`import "package://schema.kdeps.com/core@%s#/Skip.pkl" as skip`, version),
+		fmt.Sprintf(`import "package://schema.kdeps.com/core@%s#/Utils.pkl" as utils`, version),
+	}
+	for _, alias := range outputAliases {
+		if dr.hasOutput(alias) {
+			imports = append(imports, fmt.Sprintf(`import "%s" as %s`, outputImport(alias), alias))
+		}
+	}
+
+	var b strings.Builder
+	for _, line := range imports {
+		if !strings.Contains(contents, line) {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// outputImport returns the URI the resources import the output module with the given alias from.
+func outputImport(alias string) string {
+	return outputScheme + ":" + alias
+}
+
+// hasOutput reports whether the output module with the given alias exists.
+func (dr *DependencyResolver) hasOutput(alias string) bool {
+	dr.modulesMu.RLock()
+	defer dr.modulesMu.RUnlock()
+
+	_, ok := dr.modules[alias]
+	_, stale := dr.staleOutputs[alias]
+	return ok || stale
+}
+
+// readOutput returns the content of the output module with the given alias. An output module
+// recorded in the result store since it was last read is rendered first.
+func (dr *DependencyResolver) readOutput(alias string) (string, error) {
+	dr.modulesMu.Lock()
+	defer dr.modulesMu.Unlock()
+
+	if schemaFile, ok := dr.staleOutputs[alias]; ok {
+		if dr.modules == nil {
+			dr.modules = make(map[string]string)
+		}
+		dr.modules[alias] = dr.renderResults(alias, schemaFile)
+		delete(dr.staleOutputs, alias)
+	}
+
+	content, ok := dr.modules[alias]
+	if !ok {
+		return "", fmt.Errorf("output module %s does not exist", outputImport(alias))
+	}
+	return content, nil
+}
+
+// writeOutput replaces the content of the output module with the given alias.
+func (dr *DependencyResolver) writeOutput(alias, content string) error {
+	dr.modulesMu.Lock()
+	defer dr.modulesMu.Unlock()
+
+	if dr.modules == nil {
+		dr.modules = make(map[string]string)
+	}
+	dr.modules[alias] = content
+	delete(dr.staleOutputs, alias)
+	return nil
+}
+
+// evaluateOutput evaluates content and stores the result, prefixed with headerSection, as the
+// output module with the given alias.
+func (dr *DependencyResolver) evaluateOutput(alias, content, headerSection string) error {
+	var output string
+	err := dr.Evaluator.Evaluate(dr.Context, func(ev pkl.Evaluator) error {
+		var err error
+		output, err = ev.EvaluateOutputText(dr.Context, pkl.TextSource(content))
+		return err
+	}, dr.evaluatorOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate PKL: %w", err)
+	}
+
+	return dr.writeOutput(alias, headerSection+"\n"+output)
+}
+
+// loadOutput decodes the output module with the given alias with one of the generated Load
+// functions of the schema.
+func loadOutput[T any](ctx context.Context, dr *DependencyResolver, alias string, load func(context.Context, pkl.Evaluator, *pkl.ModuleSource) (T, error)) (T, error) {
+	return evaluator.Load(ctx, dr.Evaluator, pkl.UriSource(outputImport(alias)), load, dr.evaluatorOptions()...)
+}
@@ -0,0 +1,72 @@
+package resolver
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputModules(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	assert.False(t, dr.hasOutput("exec"))
+
+	require.NoError(t, dr.writeOutput("exec", "resources {\n}\n"))
+	assert.True(t, dr.hasOutput("exec"))
+
+	content, err := dr.readOutput("exec")
+	require.NoError(t, err)
+	assert.Equal(t, "resources {\n}\n", content)
+
+	reader := outputReader{dr: dr}
+	read, err := reader.Read(url.URL{Scheme: outputScheme, Opaque: "exec"})
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+
+	_, err = reader.Read(url.URL{Scheme: outputScheme, Opaque: "llm"})
+	require.ErrorContains(t, err, "kdeps:llm")
+}
+
+func TestResourceSource(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	require.NoError(t, dr.writeOutput("exec", "resources {\n}\n"))
+	content := "amends \"package://schema.kdeps.com/core@0.2.7#/Resource.pkl\"\nimport \"pkl:json\"\n\n" +
+		"actionID = \"echo\"\nrun {\n    exec {\n        command = \"echo @(exec.stdout(\"fetch\"))\"\n    }\n}\n"
+	require.NoError(t, afero.WriteFile(dr.Fs, "/agent/workflow/resources/echo.pkl", []byte(content), 0o644))
+
+	source, err := dr.resourceSource("/agent/workflow/resources/echo.pkl")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(source.Contents, "amends \"kdeps-schema:/Resource.pkl\"\nimport \"pkl:test\"\n"))
+	assert.Contains(t, source.Contents, "import \"kdeps:exec\" as exec\n")
+	assert.NotContains(t, source.Contents, "kdeps:llm")
+	assert.Equal(t, 1, strings.Count(source.Contents, "import \"pkl:json\""))
+	assert.Contains(t, source.Contents, `command = "echo \(exec.stdout("fetch"))"`)
+
+	onDisk, err := afero.ReadFile(dr.Fs, "/agent/workflow/resources/echo.pkl")
+	require.NoError(t, err)
+	assert.Equal(t, content, string(onDisk))
+}
+
+func TestModuleSource(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	content := "amends \"package://schema.kdeps.com/core@0.2.7#/Resource.pkl\"\n\nrun {\n    retry {\n        maxAttempts = 3\n    }\n}\n"
+	require.NoError(t, afero.WriteFile(dr.Fs, "/agent/workflow/resources/fetch.pkl", []byte(content), 0o644))
+
+	source, err := dr.moduleSource("/agent/workflow/resources/fetch.pkl")
+	require.NoError(t, err)
+	assert.Equal(t, "file:///agent/workflow/resources/fetch.pkl", source.Uri.String())
+	assert.True(t, strings.HasPrefix(source.Contents, "amends \"kdeps-schema:/Resource.pkl\"\n"))
+	assert.Contains(t, source.Contents, "maxAttempts = 3")
+
+	_, err = dr.moduleSource("/agent/workflow/resources/missing.pkl")
+	require.Error(t, err)
+}
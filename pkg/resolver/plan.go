@@ -0,0 +1,140 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kdeps/kdeps/pkg/utils"
+	pklRes "github.com/kdeps/schema/gen/resource"
+)
+
+// PlanStep describes what processing a resource file would do for the current request.
+type PlanStep struct {
+	Level           int      `json:"level"`
+	ActionID        string   `json:"actionID"`
+	File            string   `json:"file"`
+	Requires        []string `json:"requires,omitempty"`
+	Skipped         bool     `json:"skipped"`
+	PreflightFailed bool     `json:"preflightFailed"`
+	PreflightError  string   `json:"preflightError,omitempty"`
+	Steps           []string `json:"steps,omitempty"`
+	ForEachItems    *int     `json:"forEachItems,omitempty"`
+}
+
+// PrepareResources prepares the workflow directory and output modules and loads the resources,
+// unless the request was created from the compiled workflow.
+func (dr *DependencyResolver) PrepareResources() error {
+	if dr.compiled {
+		return nil
+	}
+
+	if err := dr.PrepareWorkflowDir(); err != nil {
+		return fmt.Errorf("failed to prepare workflow directory: %w", err)
+	}
+
+	if err := dr.PrepareImportFiles(); err != nil {
+		return fmt.Errorf("failed to prepare import files: %w", err)
+	}
+
+	if err := dr.LoadResourceEntries(); err != nil {
+		return fmt.Errorf("failed to load resources: %w", err)
+	}
+
+	dr.compiled = true
+	return nil
+}
+
+// Plan returns the resources HandleRunAction would process for actionID, in execution order,
+// together with their skip and preflight decisions and the run blocks they would execute. The
+// resources are evaluated against the current request and the placeholder outputs, so no exec,
+// python, LLM or HTTP step is run. An empty actionID plans the target action of the request, and
+// an actionID without an agent prefix is compiled like the packager does, e.g. "fetch" plans
+// "@agent/fetch:1.0.0".
+func (dr *DependencyResolver) Plan(ctx context.Context, actionID string) ([]PlanStep, error) {
+	if actionID == "" {
+		actionID = dr.targetActionID()
+	}
+	actionID = dr.compiledActionID(actionID)
+	if _, ok := dr.ResourceDependencies[actionID]; !ok {
+		return nil, fmt.Errorf("resource %s does not exist", actionID)
+	}
+
+	stack := dr.Graph.BuildDependencyStack(actionID, make(map[string]bool))
+	levels := buildExecutionLevels(stack, dr.ResourceDependencies)
+
+	var plan []PlanStep
+	for level, actionIDs := range levels {
+		for _, id := range actionIDs {
+			for _, res := range dr.Resources {
+				if res.ActionID != id {
+					continue
+				}
+
+				rsc, opts, err := dr.loadResource(ctx, res.File)
+				if err != nil {
+					return nil, fmt.Errorf("failed to evaluate resource %s: %w", id, err)
+				}
+
+				step := planResource(rsc, opts, dr.APIServerMode)
+				step.Level = level
+				step.ActionID = id
+				step.File = res.File
+				step.Requires = dr.ResourceDependencies[id]
+				if opts.ForEach != nil && len(step.Steps) > 0 {
+					items := 0
+					if opts.ForEach.Items != nil {
+						items = len(*opts.ForEach.Items)
+					}
+					step.ForEachItems = &items
+				}
+				plan = append(plan, step)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// planResource decides, like processResource, whether the resource is skipped, whether its
+// preflight check fails and which of its run blocks would be executed.
+func planResource(rsc *pklRes.Resource, opts *runOptions, apiServerMode bool) PlanStep {
+	var step PlanStep
+
+	runBlock := rsc.Run
+	if runBlock == nil {
+		return step
+	}
+
+	if runBlock.SkipCondition != nil && utils.ShouldSkip(runBlock.SkipCondition) {
+		step.Skipped = true
+		return step
+	}
+
+	if runBlock.PreflightCheck != nil && runBlock.PreflightCheck.Validations != nil &&
+		!utils.AllConditionsMet(runBlock.PreflightCheck.Validations) {
+		step.PreflightFailed = true
+		step.PreflightError = "Preflight check failed"
+		if runBlock.PreflightCheck.Error != nil {
+			step.PreflightError = fmt.Sprintf("%d: %s", runBlock.PreflightCheck.Error.Code, runBlock.PreflightCheck.Error.Message)
+		}
+		return step
+	}
+
+	if runBlock.Exec != nil && (runBlock.Exec.Command != "" || opts.Exec.hasScriptFile()) {
+		step.Steps = append(step.Steps, "exec")
+	}
+	if runBlock.Python != nil && runBlock.Python.Script != "" {
+		step.Steps = append(step.Steps, "python")
+	}
+	if runBlock.Chat != nil && runBlock.Chat.Model != "" && runBlock.Chat.Prompt != "" {
+		step.Steps = append(step.Steps, "llm")
+	}
+	if runBlock.HTTPClient != nil && runBlock.HTTPClient.Method != "" && runBlock.HTTPClient.Url != "" {
+		step.Steps = append(step.Steps, "client")
+	}
+	if apiServerMode && runBlock.APIResponse != nil {
+		step.Steps = append(step.Steps, "response")
+	}
+
+	return step
+}
@@ -0,0 +1,83 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/logging"
+	pklExec "github.com/kdeps/schema/gen/exec"
+	pklHTTP "github.com/kdeps/schema/gen/http"
+	pklRes "github.com/kdeps/schema/gen/resource"
+	pklWf "github.com/kdeps/schema/gen/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanResource(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NoRunBlock", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, PlanStep{}, planResource(&pklRes.Resource{}, &runOptions{}, true))
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		t.Parallel()
+		skip := []any{false, "TRUE"}
+		step := planResource(&pklRes.Resource{Run: &pklRes.ResourceAction{
+			SkipCondition: &skip,
+			Exec:          &pklExec.ResourceExec{Command: "echo hi"},
+		}}, &runOptions{}, true)
+		assert.True(t, step.Skipped)
+		assert.Empty(t, step.Steps)
+	})
+
+	t.Run("PreflightFailed", func(t *testing.T) {
+		t.Parallel()
+		validations := []any{true, false}
+		step := planResource(&pklRes.Resource{Run: &pklRes.ResourceAction{
+			PreflightCheck: &pklRes.ValidationCheck{
+				Validations: &validations,
+				Error:       &pklRes.APIError{Code: 422, Message: "missing query"},
+			},
+			Exec: &pklExec.ResourceExec{Command: "echo hi"},
+		}}, &runOptions{}, true)
+		assert.True(t, step.PreflightFailed)
+		assert.Equal(t, "422: missing query", step.PreflightError)
+		assert.Empty(t, step.Steps)
+	})
+
+	t.Run("RunBlocks", func(t *testing.T) {
+		t.Parallel()
+		run := &pklRes.ResourceAction{
+			Exec:       &pklExec.ResourceExec{Command: "echo hi"},
+			HTTPClient: &pklHTTP.ResourceHTTPClient{Method: "GET", Url: "https://example.com"},
+		}
+		assert.Equal(t, []string{"exec", "client"}, planResource(&pklRes.Resource{Run: run}, &runOptions{}, true).Steps)
+	})
+
+	t.Run("ScriptFile", func(t *testing.T) {
+		t.Parallel()
+		script := "scripts/setup.sh"
+		run := &pklRes.ResourceAction{Exec: &pklExec.ResourceExec{}}
+		assert.Empty(t, planResource(&pklRes.Resource{Run: run}, &runOptions{}, true).Steps)
+		assert.Equal(t, []string{"exec"}, planResource(&pklRes.Resource{Run: run}, &runOptions{Exec: &execOptions{ScriptFile: &script}}, true).Steps)
+	})
+}
+
+func TestPlanUnknownAction(t *testing.T) {
+	t.Parallel()
+
+	dr := &DependencyResolver{
+		Logger:               logging.NewTestLogger(),
+		ResourceDependencies: map[string][]string{"fetch": nil},
+	}
+
+	_, err := dr.Plan(context.Background(), "missing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing")
+
+	dr.Workflow = &pklWf.WorkflowImpl{Name: "agent", Version: "1.0.0"}
+	_, err = dr.Plan(context.Background(), "missing")
+	require.ErrorContains(t, err, "resource @agent/missing:1.0.0 does not exist")
+}
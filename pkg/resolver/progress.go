@@ -0,0 +1,65 @@
+package resolver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kdeps/kdeps/pkg/utils"
+)
+
+// OutputLine is a line written by the process of an exec or python step while it runs.
+type OutputLine struct {
+	ActionID string `json:"actionID"`
+	Stream   string `json:"stream"`
+	Line     string `json:"line"`
+}
+
+// outputLogFile returns the file that the lines written by the process of a step to stream are
+// appended to. It is stored in the logs directory of the agent, so that it is kept after the
+// directory of an API request is removed.
+func (dr *DependencyResolver) outputLogFile(actionID, stream string) string {
+	return filepath.Join(dr.baseActionDir(), "logs", utils.GenerateResourceIDFilename(actionID, dr.RequestID)+"."+stream+".log")
+}
+
+// streamOutput returns the callback that receives the lines written by the process of a step as
+// they are written. Every line is logged with the actionID, appended to the output log file of
+// its stream and passed to OnOutput, if set.
+func (dr *DependencyResolver) streamOutput(actionID string) func(stream, line string) {
+	var warnOnce sync.Once
+
+	return func(stream, line string) {
+		line = dr.Secrets.Redact(line)
+		dr.Logger.Info("step output", "actionID", actionID, "stream", stream, "line", line)
+
+		if err := dr.appendOutputLog(dr.outputLogFile(actionID, stream), line); err != nil {
+			warnOnce.Do(func() {
+				dr.Logger.Warn("failed to write output log", "actionID", actionID, "error", err)
+			})
+		}
+
+		if dr.OnOutput != nil {
+			dr.OnOutput(OutputLine{ActionID: actionID, Stream: stream, Line: line})
+		}
+	}
+}
+
+// appendOutputLog appends line to file. The file is opened for every line, so that a log file
+// that was rotated or removed while the step runs is created again.
+func (dr *DependencyResolver) appendOutputLog(file, line string) error {
+	if err := dr.Fs.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", file, err)
+	}
+
+	f, err := dr.Fs.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package resolver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamOutput(t *testing.T) {
+	t.Parallel()
+
+	var received []OutputLine
+	dr := newTestResolver(t)
+	dr.OnOutput = func(line OutputLine) {
+		received = append(received, line)
+	}
+
+	onLine := dr.streamOutput("@agent/train:1.0.0")
+	onLine("stdout", "epoch 1")
+	onLine("stderr", "warning")
+
+	// A rotated log file is created again by the next line.
+	logFile := dr.outputLogFile("@agent/train:1.0.0", "stdout")
+	assert.Equal(t, "/action/logs", filepath.Dir(logFile))
+	require.NoError(t, dr.Fs.Rename(logFile, logFile+".1"))
+	onLine("stdout", "epoch 2")
+
+	content, err := afero.ReadFile(dr.Fs, logFile)
+	require.NoError(t, err)
+	assert.Equal(t, "epoch 2\n", string(content))
+
+	content, err = afero.ReadFile(dr.Fs, dr.outputLogFile("@agent/train:1.0.0", "stderr"))
+	require.NoError(t, err)
+	assert.Equal(t, "warning\n", string(content))
+
+	assert.Equal(t, []OutputLine{
+		{ActionID: "@agent/train:1.0.0", Stream: "stdout", Line: "epoch 1"},
+		{ActionID: "@agent/train:1.0.0", Stream: "stderr", Line: "warning"},
+		{ActionID: "@agent/train:1.0.0", Stream: "stdout", Line: "epoch 2"},
+	}, received)
+	assert.Contains(t, dr.Logger.GetOutput(), "epoch 1")
+}
+
+func TestRequestOutputLogsOutliveRequest(t *testing.T) {
+	t.Parallel()
+
+	logger := logging.NewTestLogger()
+	base := &DependencyResolver{
+		Fs:        afero.NewMemMapFs(),
+		Logger:    logger,
+		AgentDir:  "/agent",
+		ActionDir: "/agent/action",
+		template:  &requestTemplate{dependencies: map[string][]string{}},
+	}
+
+	dr, err := NewRequestResolver(base, context.Background(), "req1", logger)
+	require.NoError(t, err)
+	dr.streamOutput("@agent/train:1.0.0")("stdout", "epoch 1")
+
+	// The API server removes the directory of the request once it completes.
+	require.NoError(t, dr.Fs.RemoveAll(dr.ActionDir))
+
+	content, err := afero.ReadFile(dr.Fs, dr.outputLogFile("@agent/train:1.0.0", "stdout"))
+	require.NoError(t, err)
+	assert.Equal(t, "epoch 1\n", string(content))
+	assert.Equal(t, "/agent/action/logs", filepath.Dir(dr.outputLogFile("@agent/train:1.0.0", "stdout")))
+}
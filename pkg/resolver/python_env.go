@@ -0,0 +1,172 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/apple/pkl-go/pkl"
+	pklPython "github.com/kdeps/schema/gen/python"
+	"github.com/spf13/afero"
+)
+
+// systemPythonEnvironment names the python3 of the image, used when a python block selects no
+// environment.
+const systemPythonEnvironment = "system"
+
+// pythonOptions holds the python block properties that are read on top of the generated schema type.
+type pythonOptions struct {
+	// Virtualenv the script runs in, relative to the project directory.
+	VirtualEnv *string `pkl:"virtualEnv"`
+
+	// JSON document of the inputs of the script, rendered from its inputs property.
+	Inputs *string `pkl:"-"`
+}
+
+// loadPythonOptions decodes the python block options, or returns nil when none is set.
+func loadPythonOptions(ctx context.Context, evaluator pkl.Evaluator, source *pkl.ModuleSource) (*pythonOptions, error) {
+	var (
+		opts = &pythonOptions{}
+		err  error
+	)
+	if opts.VirtualEnv, err = loadBlockOption[string](ctx, evaluator, source, "run.python", "virtualEnv"); err != nil {
+		return nil, err
+	}
+	if opts.Inputs, err = loadPythonInputs(ctx, evaluator, source); err != nil {
+		return nil, err
+	}
+	if opts.VirtualEnv == nil && opts.Inputs == nil {
+		return nil, nil
+	}
+	return opts, nil
+}
+
+// pythonEnvironment is the environment a python step runs in.
+type pythonEnvironment struct {
+	// Name is the conda environment, the virtualenv, or systemPythonEnvironment.
+	Name string
+	// Interpreter is the python executable of the environment.
+	Interpreter string
+	// Env holds the KEY=VALUE pairs that activate the environment.
+	Env []string
+}
+
+// pythonEnvironment resolves the environment of a python block: its virtualenv, its conda
+// environment when Anaconda is installed, or the python3 of the image.
+func (dr *DependencyResolver) pythonEnvironment(pythonBlock *pklPython.ResourcePython, opts *pythonOptions) (pythonEnvironment, error) {
+	condaEnv := ""
+	if pythonBlock.CondaEnvironment != nil {
+		condaEnv = *pythonBlock.CondaEnvironment
+	}
+
+	if opts != nil && opts.VirtualEnv != nil && *opts.VirtualEnv != "" {
+		if condaEnv != "" {
+			return pythonEnvironment{}, fmt.Errorf("python block sets both condaEnvironment %q and virtualEnv %q", condaEnv, *opts.VirtualEnv)
+		}
+		prefix := dr.projectPath(*opts.VirtualEnv)
+		return dr.activatePythonEnvironment(*opts.VirtualEnv, prefix, "virtualenv", "VIRTUAL_ENV="+prefix)
+	}
+
+	if condaEnv == "" {
+		return pythonEnvironment{Name: systemPythonEnvironment, Interpreter: "python3"}, nil
+	}
+	if !dr.AnacondaInstalled {
+		dr.Logger.Warn("Anaconda is not installed, ignoring condaEnvironment", "condaEnvironment", condaEnv)
+		return pythonEnvironment{Name: systemPythonEnvironment, Interpreter: "python3"}, nil
+	}
+
+	prefix, ok := dr.condaEnvironments[condaEnv]
+	if !ok {
+		return pythonEnvironment{}, fmt.Errorf("conda environment %q does not exist", condaEnv)
+	}
+	return dr.activatePythonEnvironment(condaEnv, prefix, "conda environment", "CONDA_PREFIX="+prefix, "CONDA_DEFAULT_ENV="+condaEnv)
+}
+
+// activatePythonEnvironment returns the environment installed at prefix, which must have a python
+// interpreter. Its bin directory is put first on the PATH, like its activation script does.
+func (dr *DependencyResolver) activatePythonEnvironment(name, prefix, kind string, env ...string) (pythonEnvironment, error) {
+	bin := filepath.Join(prefix, "bin")
+	interpreter := filepath.Join(bin, "python")
+	if exists, _ := afero.Exists(dr.Fs, interpreter); !exists {
+		return pythonEnvironment{}, fmt.Errorf("%s %q has no python interpreter at %s", kind, name, interpreter)
+	}
+
+	env = append(env, "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return pythonEnvironment{Name: name, Interpreter: interpreter, Env: env}, nil
+}
+
+// condaInfo is the part of the output of `conda info --json` that lists the environments.
+type condaInfo struct {
+	RootPrefix string   `json:"root_prefix"`
+	Envs       []string `json:"envs"`
+}
+
+// LoadCondaEnvironments discovers the conda environments of the image, which the python steps
+// run in, and checks that every environment declared in the condaPackages of the workflow exists.
+// It does nothing when Anaconda is not installed.
+func (dr *DependencyResolver) LoadCondaEnvironments(ctx context.Context) error {
+	if !dr.AnacondaInstalled {
+		return nil
+	}
+
+	conda := "/opt/conda/bin/conda"
+	if path, err := exec.LookPath("conda"); err == nil {
+		conda = path
+	}
+	result, err := runCommand(ctx, commandTask{Command: conda, Args: []string{"info", "--json"}})
+	if err != nil {
+		return fmt.Errorf("failed to list conda environments: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("failed to list conda environments: %w", &exitCodeError{exitCode: result.ExitCode, stderr: result.Stderr})
+	}
+
+	environments, err := parseCondaEnvironments(result.Stdout)
+	if err != nil {
+		return err
+	}
+	dr.condaEnvironments = environments
+
+	var declared []string
+	if dr.Workflow != nil && dr.Workflow.GetSettings() != nil {
+		if condaPackages := dr.Workflow.GetSettings().AgentSettings.CondaPackages; condaPackages != nil {
+			for name := range *condaPackages {
+				declared = append(declared, name)
+			}
+		}
+	}
+	sort.Strings(declared)
+	for _, name := range declared {
+		if _, ok := environments[name]; !ok {
+			return fmt.Errorf("conda environment %q of condaPackages does not exist", name)
+		}
+	}
+
+	dr.Logger.Debug("loaded conda environments", "environments", len(environments))
+	return nil
+}
+
+// parseCondaEnvironments returns the prefixes of the environments listed by `conda info --json`,
+// keyed by name. The root environment is named base.
+func parseCondaEnvironments(output string) (map[string]string, error) {
+	var info condaInfo
+	if err := json.Unmarshal([]byte(output), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse conda environments: %w", err)
+	}
+
+	environments := make(map[string]string, len(info.Envs))
+	for _, prefix := range info.Envs {
+		name := filepath.Base(prefix)
+		if prefix == info.RootPrefix {
+			name = "base"
+		}
+		if _, ok := environments[name]; !ok {
+			environments[name] = prefix
+		}
+	}
+	return environments, nil
+}
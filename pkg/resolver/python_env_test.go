@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"testing"
+
+	pklPython "github.com/kdeps/schema/gen/python"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPythonEnvironment(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	for _, interpreter := range []string{"/opt/conda/envs/ml/bin/python", "/workflow/venv/bin/python"} {
+		require.NoError(t, afero.WriteFile(dr.Fs, interpreter, nil, 0o755))
+	}
+	dr.AnacondaInstalled = true
+	dr.condaEnvironments = map[string]string{"base": "/opt/conda", "ml": "/opt/conda/envs/ml"}
+	strPtr := func(v string) *string { return &v }
+
+	t.Run("System", func(t *testing.T) {
+		t.Parallel()
+		env, err := dr.pythonEnvironment(&pklPython.ResourcePython{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, pythonEnvironment{Name: systemPythonEnvironment, Interpreter: "python3"}, env)
+	})
+
+	t.Run("Conda", func(t *testing.T) {
+		t.Parallel()
+		env, err := dr.pythonEnvironment(&pklPython.ResourcePython{CondaEnvironment: strPtr("ml")}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "ml", env.Name)
+		assert.Equal(t, "/opt/conda/envs/ml/bin/python", env.Interpreter)
+		assert.Contains(t, env.Env, "CONDA_PREFIX=/opt/conda/envs/ml")
+		assert.Contains(t, env.Env, "CONDA_DEFAULT_ENV=ml")
+	})
+
+	t.Run("CondaErrors", func(t *testing.T) {
+		t.Parallel()
+		_, err := dr.pythonEnvironment(&pklPython.ResourcePython{CondaEnvironment: strPtr("missing")}, nil)
+		require.ErrorContains(t, err, `conda environment "missing" does not exist`)
+
+		_, err = dr.pythonEnvironment(&pklPython.ResourcePython{CondaEnvironment: strPtr("base")}, nil)
+		require.ErrorContains(t, err, "has no python interpreter")
+	})
+
+	t.Run("AnacondaNotInstalled", func(t *testing.T) {
+		t.Parallel()
+		env, err := newTestResolver(t).pythonEnvironment(&pklPython.ResourcePython{CondaEnvironment: strPtr("ml")}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, systemPythonEnvironment, env.Name)
+	})
+
+	t.Run("VirtualEnv", func(t *testing.T) {
+		t.Parallel()
+		env, err := dr.pythonEnvironment(&pklPython.ResourcePython{}, &pythonOptions{VirtualEnv: strPtr("venv")})
+		require.NoError(t, err)
+		assert.Equal(t, "venv", env.Name)
+		assert.Equal(t, "/workflow/venv/bin/python", env.Interpreter)
+		assert.Contains(t, env.Env, "VIRTUAL_ENV=/workflow/venv")
+
+		_, err = dr.pythonEnvironment(&pklPython.ResourcePython{CondaEnvironment: strPtr("ml")}, &pythonOptions{VirtualEnv: strPtr("venv")})
+		require.ErrorContains(t, err, "sets both condaEnvironment")
+	})
+}
+
+func TestParseCondaEnvironments(t *testing.T) {
+	t.Parallel()
+
+	environments, err := parseCondaEnvironments(`{"root_prefix": "/opt/conda", "envs": ["/opt/conda", "/opt/conda/envs/ml", "/home/user/envs/ml"]}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"base": "/opt/conda", "ml": "/opt/conda/envs/ml"}, environments)
+
+	_, err = parseCondaEnvironments("not json")
+	require.Error(t, err)
+}
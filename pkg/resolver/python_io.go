@@ -0,0 +1,108 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/spf13/afero"
+)
+
+// Environment variables holding the paths of the JSON files a python script reads its inputs from
+// and writes its result to.
+const (
+	pythonInputsEnv = "KDEPS_PYTHON_INPUTS"
+	pythonResultEnv = "KDEPS_PYTHON_RESULT"
+)
+
+// pythonInputsExpr renders the inputs of the python block as a JSON document.
+const pythonInputsExpr = `let (inputs = run.python?.getPropertyOrNull("inputs")) if (inputs == null) null else new JsonRenderer {}.renderValue(inputs)`
+
+// pythonRun holds what a python step produced besides the schema fields of its output.
+type pythonRun struct {
+	// Environment is the environment the script ran in.
+	Environment string `json:"environment,omitempty"`
+	// Result is the JSON document the script wrote to its result file.
+	Result *string `json:"result,omitempty"`
+}
+
+// loadPythonInputs renders the inputs of the python block as a JSON document, or returns nil when
+// the block declares none.
+func loadPythonInputs(ctx context.Context, evaluator pkl.Evaluator, source *pkl.ModuleSource) (*string, error) {
+	var out *string
+	if err := evaluator.EvaluateExpression(ctx, source, pythonInputsExpr, &out); err != nil {
+		return nil, fmt.Errorf("invalid run option run.python.inputs: %w", err)
+	}
+	return out, nil
+}
+
+// pythonIO is the directory holding the inputs and the result file of a python step.
+type pythonIO struct {
+	dir string
+}
+
+// newPythonIO writes the inputs of a python step, an empty object when it declares none, to a new
+// directory that also receives its result file.
+func (dr *DependencyResolver) newPythonIO(opts *pythonOptions) (*pythonIO, error) {
+	dir, err := afero.TempDir(dr.Fs, "", "python-io-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create python io directory: %w", err)
+	}
+	files := &pythonIO{dir: dir}
+
+	inputs := "{}"
+	if opts != nil && opts.Inputs != nil {
+		inputs = *opts.Inputs
+	}
+	if err := afero.WriteFile(dr.Fs, files.inputsPath(), []byte(inputs), 0o600); err != nil {
+		dr.cleanupPythonIO(files)
+		return nil, fmt.Errorf("failed to write python inputs: %w", err)
+	}
+	return files, nil
+}
+
+func (p *pythonIO) inputsPath() string {
+	return filepath.Join(p.dir, "inputs.json")
+}
+
+func (p *pythonIO) resultPath() string {
+	return filepath.Join(p.dir, "result.json")
+}
+
+// env returns the variables that pass the paths of the files to the script.
+func (p *pythonIO) env() []string {
+	return []string{pythonInputsEnv + "=" + p.inputsPath(), pythonResultEnv + "=" + p.resultPath()}
+}
+
+// readPythonResult returns the JSON document the script wrote to its result file, or nil when it
+// wrote none. A result that is not valid JSON, or larger than KDEPS_MAX_OUTPUT_SIZE, fails the
+// step, since it cannot be truncated like a text output.
+func (dr *DependencyResolver) readPythonResult(files *pythonIO) (*string, error) {
+	content, err := afero.ReadFile(dr.Fs, files.resultPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read python result: %w", err)
+	}
+
+	if maxSize := dr.maxOutputSize(); maxSize > 0 && len(content) > maxSize {
+		return nil, fmt.Errorf("python result of %d bytes exceeds the maximum output size of %d bytes", len(content), maxSize)
+	}
+	if !json.Valid(content) {
+		return nil, errors.New("python result is not valid JSON")
+	}
+
+	result := dr.Secrets.Redact(string(content))
+	return &result, nil
+}
+
+func (dr *DependencyResolver) cleanupPythonIO(files *pythonIO) {
+	if err := dr.Fs.RemoveAll(files.dir); err != nil {
+		dr.Logger.Error("failed to clean up python io directory", "path", files.dir, "error", err)
+	}
+}
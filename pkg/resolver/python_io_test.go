@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/secrets"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPythonIO(t *testing.T) {
+	t.Parallel()
+
+	strPtr := func(v string) *string { return &v }
+
+	t.Run("ReadResult", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.Secrets = secrets.New(map[string]string{"TOKEN": "s3cr3t-token"})
+		files, err := dr.newPythonIO(nil)
+		require.NoError(t, err)
+
+		inputs, err := afero.ReadFile(dr.Fs, files.inputsPath())
+		require.NoError(t, err)
+		assert.Equal(t, "{}", string(inputs))
+
+		result, err := dr.readPythonResult(files)
+		require.NoError(t, err)
+		assert.Nil(t, result)
+
+		require.NoError(t, afero.WriteFile(dr.Fs, files.resultPath(), []byte(`{"token": "s3cr3t-token"}`), 0o600))
+		result, err = dr.readPythonResult(files)
+		require.NoError(t, err)
+		assert.Equal(t, `{"token": "[REDACTED]"}`, *result)
+
+		require.NoError(t, afero.WriteFile(dr.Fs, files.resultPath(), []byte(`{"token": `), 0o600))
+		_, err = dr.readPythonResult(files)
+		require.ErrorContains(t, err, "not valid JSON")
+
+		dr.Settings.MaxOutputSize = 4
+		require.NoError(t, afero.WriteFile(dr.Fs, files.resultPath(), []byte(`[1, 2, 3]`), 0o600))
+		_, err = dr.readPythonResult(files)
+		require.ErrorContains(t, err, "exceeds the maximum output size")
+
+		dr.cleanupPythonIO(files)
+		exists, err := afero.DirExists(dr.Fs, files.dir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("Script", func(t *testing.T) {
+		t.Parallel()
+		if _, err := exec.LookPath("python3"); err != nil {
+			t.Skip("python3 is not available")
+		}
+		dr := newTestResolver(t)
+		dr.Fs = afero.NewOsFs()
+		files, err := dr.newPythonIO(&pythonOptions{Inputs: strPtr(`{"numbers": [1, 2, 3]}`)})
+		require.NoError(t, err)
+		defer dr.cleanupPythonIO(files)
+
+		script := strings.Join([]string{
+			"import json, os",
+			"inputs = json.load(open(os.environ['KDEPS_PYTHON_INPUTS']))",
+			"json.dump({'sum': sum(inputs['numbers'])}, open(os.environ['KDEPS_PYTHON_RESULT'], 'w'))",
+		}, "\n")
+		_, err = runCommand(context.Background(), commandTask{Command: "python3", Args: []string{"-c", script}, Env: files.env()})
+		require.NoError(t, err)
+
+		result, err := dr.readPythonResult(files)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"sum": 6}`, *result)
+	})
+}
@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kdeps/kartographer/graph"
 	"github.com/kdeps/kdeps/pkg/environment"
+	"github.com/kdeps/kdeps/pkg/evaluator"
 	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/secrets"
 	"github.com/kdeps/kdeps/pkg/utils"
+	"github.com/kdeps/kdeps/pkg/workflow"
 	pklRes "github.com/kdeps/schema/gen/resource"
 	pklWf "github.com/kdeps/schema/gen/workflow"
 	"github.com/spf13/afero"
@@ -27,9 +32,11 @@ type DependencyResolver struct {
 	Context              context.Context //nolint:containedctx // TODO: move this context into function params
 	Graph                *graph.DependencyGraph
 	Environment          *environment.Environment
+	Settings             *workflow.Settings
+	Evaluator            *evaluator.Manager
 	Workflow             pklWf.Workflow
+	TargetActionID       string
 	RequestID            string
-	RequestPklFile       string
 	ResponsePklFile      string
 	ResponseTargetFile   string
 	ProjectDir           string
@@ -38,8 +45,75 @@ type DependencyResolver struct {
 	ActionDir            string
 	FilesDir             string
 	DataDir              string
+	CacheDir             string
 	APIServerMode        bool
 	AnacondaInstalled    bool
+
+	// Secrets holds the secrets the steps reference and that are redacted from their outputs.
+	Secrets *secrets.Store
+	// OnOutput, if set, receives the lines written by the exec and python steps as they run. It is
+	// called concurrently by the steps of independent resources.
+	OnOutput func(OutputLine)
+
+	// outputMu serializes the read-modify-write cycles on the shared output files
+	// while resources are processed concurrently.
+	outputMu sync.Mutex
+
+	// steps holds the completion futures of the resource steps started for this request.
+	steps   map[string]*stepFuture
+	stepsMu sync.Mutex
+
+	// modules holds the output modules of the request, keyed by import alias.
+	modules   map[string]string
+	modulesMu sync.RWMutex
+
+	// staleOutputs holds the schema module of the output modules that are rendered from the
+	// result store when they are next read, keyed by import alias, guarded by modulesMu.
+	staleOutputs map[string]string
+
+	// condaEnvironments holds the prefixes of the conda environments of the image, keyed by name.
+	condaEnvironments map[string]string
+
+	// statuses holds the outcome of each processed resource, guarded by outputMu.
+	statuses map[string]*resourceStatus
+
+	// routeTargets holds the target action of every API server route, empty for the routes that
+	// run the targetActionID of the workflow.
+	routeTargets []string
+
+	// errorMu serializes the error handlers, which share the error output.
+	errorMu sync.Mutex
+
+	// resourceHandlers holds the handler of the onError block of each loaded resource, keyed by
+	// actionID.
+	resourceHandlers map[string]string
+
+	// forEachResults holds the ordered outputs of the forEach resources, guarded by outputMu.
+	forEachResults map[string][]string
+
+	// results holds the outputs of the resource steps of the request. It is changed while holding
+	// both outputMu and modulesMu, so that the output modules can be rendered under modulesMu.
+	results *resultStore
+
+	// trace holds the spans of the resources and steps run for the request, guarded by outputMu.
+	trace []TraceSpan
+
+	// responses counts the API responses created by the resources of the request, guarded by
+	// outputMu.
+	responses int
+
+	// template is the compiled workflow that API requests are created from, if any.
+	template *requestTemplate
+	// compiled is set when the resources of the request were created from the template.
+	compiled bool
+	// projectRoot is the directory the project paths of the resources, such as the scriptFile of an
+	// exec block or the virtualEnv of a python block, resolve against. The WorkflowDir of a request
+	// created from the template only holds the compiled resource files, so the request uses the
+	// workflow directory of the resolver the template was compiled from. Empty means WorkflowDir.
+	projectRoot string
+	// agentActionDir is the action directory of the agent. The ActionDir of an API request is a
+	// directory under it that is removed once the request completes. Empty means ActionDir.
+	agentActionDir string
 }
 
 type ResourceNodeEntry struct {
@@ -49,7 +123,6 @@ type ResourceNodeEntry struct {
 
 func NewGraphResolver(fs afero.Fs, ctx context.Context, env *environment.Environment, agentDir, actionDir, graphID string, logger *logging.Logger) (*DependencyResolver, error) {
 	workflowDir := filepath.Join(agentDir, "/workflow/")
-	projectDir := filepath.Join(agentDir, "/project/")
 	pklWfFile := filepath.Join(workflowDir, "workflow.pkl")
 
 	exists, err := afero.Exists(fs, pklWfFile)
@@ -57,7 +130,43 @@ func NewGraphResolver(fs afero.Fs, ctx context.Context, env *environment.Environ
 		return nil, fmt.Errorf("error checking %s: %w", pklWfFile, err)
 	}
 
+	dependencyResolver, err := newResolver(fs, ctx, env, agentDir, actionDir, graphID, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	workflowConfiguration, err := workflow.LoadWorkflow(ctx, pklWfFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	dependencyResolver.Workflow = workflowConfiguration
+
+	settings, err := workflow.LoadSettings(ctx, pklWfFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	dependencyResolver.Settings = settings
+	if workflowConfiguration.GetSettings() != nil {
+		dependencyResolver.APIServerMode = workflowConfiguration.GetSettings().APIServerMode
+		agentSettings := workflowConfiguration.GetSettings().AgentSettings
+		dependencyResolver.AnacondaInstalled = agentSettings.InstallAnaconda
+	}
+
+	dependencyResolver.Graph = graph.NewDependencyGraph(fs, logger.BaseLogger(), dependencyResolver.ResourceDependencies)
+	if dependencyResolver.Graph == nil {
+		return nil, errors.New("failed to initialize dependency graph")
+	}
+
+	return dependencyResolver, nil
+}
+
+// newResolver creates the directories and stamp file of the given graphID and returns a resolver
+// for them, without loading the workflow.
+func newResolver(fs afero.Fs, ctx context.Context, env *environment.Environment, agentDir, actionDir, graphID string, logger *logging.Logger) (*DependencyResolver, error) {
+	workflowDir := filepath.Join(agentDir, "/workflow/")
+	projectDir := filepath.Join(agentDir, "/project/")
 	dataDir := filepath.Join(projectDir, "/data/")
+	cacheDir := filepath.Join(agentDir, "/cache/")
 	filesDir := filepath.Join(actionDir, "/files/")
 
 	directories := []string{
@@ -80,7 +189,15 @@ func NewGraphResolver(fs afero.Fs, ctx context.Context, env *environment.Environ
 		return nil, fmt.Errorf("error creating file: %w", err)
 	}
 
-	requestPklFile := filepath.Join(actionDir, "/api/"+graphID+"__request.pkl")
+	evaluatorMode := ""
+	if env != nil {
+		evaluatorMode = env.PklEvaluator
+	}
+	pklEvaluator, err := evaluator.NewManager(evaluatorMode)
+	if err != nil {
+		return nil, err
+	}
+
 	responsePklFile := filepath.Join(actionDir, "/api/"+graphID+"__response.pkl")
 	responseTargetFile := filepath.Join(actionDir, "/api/"+graphID+"__response.json")
 
@@ -91,58 +208,180 @@ func NewGraphResolver(fs afero.Fs, ctx context.Context, env *environment.Environ
 		VisitedPaths:         make(map[string]bool),
 		Context:              ctx,
 		Environment:          env,
+		Evaluator:            pklEvaluator,
 		WorkflowDir:          workflowDir,
 		AgentDir:             agentDir,
 		ActionDir:            actionDir,
 		FilesDir:             filesDir,
 		DataDir:              dataDir,
+		CacheDir:             cacheDir,
 		RequestID:            graphID,
-		RequestPklFile:       requestPklFile,
 		ResponsePklFile:      responsePklFile,
 		ResponseTargetFile:   responseTargetFile,
 		ProjectDir:           projectDir,
 	}
 
-	workflowConfiguration, err := pklWf.LoadFromPath(ctx, pklWfFile)
+	return dependencyResolver, nil
+}
+
+// resourceError describes a failed resource and the API error response it should produce.
+type resourceError struct {
+	code    int
+	message string
+	fatal   bool
+	// step is the step of the run block that failed, if any.
+	step string
+	// handled is set when an error handler already created the API response of the failure.
+	handled bool
+}
+
+func (e *resourceError) Error() string {
+	return e.message
+}
+
+// newStepError reports a failed resource step. A step that ran out of time is reported with the
+// 504 Gateway Timeout status code, so that clients can tell it apart from a failing step.
+func newStepError(step, label, actionID string, err error, fatal bool) *resourceError {
+	var limitErr *sandboxError
+	if errors.As(err, &limitErr) {
+		return &resourceError{code: limitErr.statusCode(), message: fmt.Sprintf("%s stopped by its sandbox for resource: %s - %s", label, actionID, err), fatal: fatal, step: step}
+	}
+	if errors.Is(err, errStepTimeout) {
+		return &resourceError{code: 504, message: fmt.Sprintf("%s timed out for resource: %s - %s", label, actionID, err), fatal: fatal, step: step}
+	}
+	return &resourceError{code: 500, message: fmt.Sprintf("%s failed for resource: %s - %s", label, actionID, err), fatal: fatal, step: step}
+}
+
+// NewRequestResolver creates the resolver of a single API request. Every request gets its own
+// action directory, holding its outputs and a private copy of the workflow resources, so that
+// concurrent requests never modify each other's files. The action directory is removed once the
+// request completes, so the files the steps write, which the response may refer to, are kept in
+// the files directory of the agent instead. When the workflow was compiled with
+// CompileRequestTemplate, the request reuses the compiled resources instead of preparing them.
+func NewRequestResolver(base *DependencyResolver, ctx context.Context, requestID string, logger *logging.Logger) (*DependencyResolver, error) {
+	actionDir := filepath.Join(base.ActionDir, "requests", requestID)
+
+	if base.template == nil {
+		dr, err := NewGraphResolver(base.Fs, ctx, base.Environment, base.AgentDir, actionDir, requestID, logger)
+		if err != nil {
+			return nil, err
+		}
+		dr.WorkflowDir = filepath.Join(actionDir, "workflow")
+		dr.agentActionDir = base.baseActionDir()
+		dr.FilesDir = base.FilesDir
+		dr.Evaluator = base.Evaluator
+		dr.Secrets = base.Secrets
+		dr.condaEnvironments = base.condaEnvironments
+		dr.routeTargets = base.routeTargets
+
+		return dr, nil
+	}
+
+	dr, err := base.newChildResolver(ctx, actionDir, requestID, logger)
 	if err != nil {
 		return nil, err
 	}
-	dependencyResolver.Workflow = workflowConfiguration
-	if workflowConfiguration.GetSettings() != nil {
-		dependencyResolver.APIServerMode = workflowConfiguration.GetSettings().APIServerMode
-		agentSettings := workflowConfiguration.GetSettings().AgentSettings
-		dependencyResolver.AnacondaInstalled = agentSettings.InstallAnaconda
+	if err := base.template.apply(dr); err != nil {
+		return nil, fmt.Errorf("failed to create request from the compiled workflow: %w", err)
 	}
 
-	dependencyResolver.Graph = graph.NewDependencyGraph(fs, logger.BaseLogger(), dependencyResolver.ResourceDependencies)
-	if dependencyResolver.Graph == nil {
+	return dr, nil
+}
+
+// newChildResolver creates a resolver that shares the loaded workflow of dr.
+func (dr *DependencyResolver) newChildResolver(ctx context.Context, actionDir, requestID string, logger *logging.Logger) (*DependencyResolver, error) {
+	child, err := newResolver(dr.Fs, ctx, dr.Environment, dr.AgentDir, actionDir, requestID, logger)
+	if err != nil {
+		return nil, err
+	}
+	child.WorkflowDir = filepath.Join(actionDir, "workflow")
+	child.projectRoot = dr.projectDir()
+	child.agentActionDir = dr.baseActionDir()
+	child.FilesDir = dr.FilesDir
+	child.Evaluator = dr.Evaluator
+	child.Secrets = dr.Secrets
+	child.Workflow = dr.Workflow
+	child.Settings = dr.Settings
+	child.APIServerMode = dr.APIServerMode
+	child.AnacondaInstalled = dr.AnacondaInstalled
+	child.condaEnvironments = dr.condaEnvironments
+	child.routeTargets = dr.routeTargets
+
+	child.Graph = graph.NewDependencyGraph(dr.Fs, logger.BaseLogger(), child.ResourceDependencies)
+	if child.Graph == nil {
 		return nil, errors.New("failed to initialize dependency graph")
 	}
 
-	return dependencyResolver, nil
+	return child, nil
 }
 
-// processResourceStep consolidates the pattern of: get timestamp, run a handler, adjust timeout (if provided),
-// then wait for the timestamp change.
-func (dr *DependencyResolver) processResourceStep(resourceID, step string, timeoutPtr *int, handler func() error) error {
-	timestamp, err := dr.GetCurrentTimestamp(resourceID, step)
-	if err != nil {
-		return fmt.Errorf("%s error: %w", step, err)
+// baseActionDir returns the action directory of the agent, which holds the files that outlive
+// the requests, such as the output logs of the steps.
+func (dr *DependencyResolver) baseActionDir() string {
+	if dr.agentActionDir != "" {
+		return dr.agentActionDir
+	}
+	return dr.ActionDir
+}
+
+// settings returns the settings of the workflow, or the default settings when none were loaded.
+func (dr *DependencyResolver) settings() *workflow.Settings {
+	if dr.Settings == nil {
+		return workflow.DefaultSettings()
 	}
+	return dr.Settings
+}
 
+// compiledActionID returns the actionID as compiled by the packager: an actionID without an agent
+// prefix belongs to the agent of the workflow, e.g. "fetch" becomes "@agent/fetch:1.0.0".
+func (dr *DependencyResolver) compiledActionID(actionID string) string {
+	actionID = strings.TrimSpace(actionID)
+	if dr.Workflow == nil || actionID == "" || strings.HasPrefix(actionID, "@") {
+		return actionID
+	}
+	return fmt.Sprintf("@%s/%s:%s", dr.Workflow.GetName(), actionID, dr.Workflow.GetVersion())
+}
+
+// Compiled reports whether the workflow directory, output files and resources of the request
+// were already created from the compiled workflow.
+func (dr *DependencyResolver) Compiled() bool {
+	return dr.compiled
+}
+
+// processResourceStep starts a resource step through its handler and waits for the step to
+// signal its completion. The step runs under its own context, which is canceled once the timeout
+// (if provided) expires so that the work started by the step is terminated.
+func (dr *DependencyResolver) processResourceStep(ctx context.Context, resourceID, step string, timeoutPtr *int, handler func(ctx context.Context) error) error {
 	timeout := 60 * time.Second
 	if timeoutPtr != nil {
 		timeout = time.Duration(*timeoutPtr) * time.Second
 		dr.Logger.Infof("Timeout duration for '%s' is set to '%.0f' seconds", resourceID, timeout.Seconds())
 	}
 
-	if err := handler(); err != nil {
-		return fmt.Errorf("%s error: %w", step, err)
+	stepCtx, cancel := context.WithCancel(ctx)
+	if timeout > 0 {
+		stepCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	startTime := time.Now()
+	err := handler(stepCtx)
+	if err == nil {
+		future := dr.stepFuture(resourceID, step)
+		if future == nil {
+			return fmt.Errorf("%s error: no step was started for resource %s", step, resourceID)
+		}
+		err = future.wait(stepCtx)
 	}
 
-	if err := dr.WaitForTimestampChange(resourceID, timestamp, timeout, step); err != nil {
-		return fmt.Errorf("%s timeout awaiting for output: %w", step, err)
+	if err != nil {
+		if errors.Is(stepCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return fmt.Errorf("%s %w after %s", step, errStepTimeout, formatDuration(timeout))
+		}
+		return fmt.Errorf("%s error: %w", step, err)
 	}
+
+	dr.Logger.Infof("resource '%s' (type: %s) completed in %s", resourceID, step, formatDuration(time.Since(startTime)))
 	return nil
 }
 
@@ -158,109 +397,192 @@ func (dr *DependencyResolver) HandleRunAction() (bool, error) {
 		}
 	}()
 
+	defer func() {
+		if err := dr.writeTrace(); err != nil {
+			dr.Logger.Warn("failed to persist the request trace", "error", err)
+		}
+	}()
+
 	requestFilePath := filepath.Join(dr.ActionDir, dr.RequestID)
 
 	visited := make(map[string]bool)
-	actionID := dr.Workflow.GetTargetActionID()
-	dr.Logger.Debug("processing resources...")
+	actionID := dr.targetActionID()
+	dr.Logger.Debug("processing resources...", "targetActionID", actionID)
 
-	if err := dr.LoadResourceEntries(); err != nil {
-		return dr.HandleAPIErrorResponse(500, err.Error(), true)
+	if !dr.compiled {
+		if err := dr.LoadResourceEntries(); err != nil {
+			return dr.HandleAPIErrorResponse(500, err.Error(), true)
+		}
 	}
 
-	// Build dependency stack for the target action
+	// Build dependency stack for the target action and group it into levels of
+	// resources that can run concurrently.
 	stack := dr.Graph.BuildDependencyStack(actionID, visited)
-
-	// Process each resource in the dependency stack
-	for _, nodeActionID := range stack {
-		for _, res := range dr.Resources {
-			if res.ActionID != nodeActionID {
-				continue
+	levels := buildExecutionLevels(stack, dr.ResourceDependencies)
+
+	for _, level := range levels {
+		if err := dr.runLevel(dr.Context, level); err != nil {
+			var resErr *resourceError
+			if errors.As(err, &resErr) {
+				if resErr.handled {
+					dr.Logger.Debug("resource failure answered by its error handler", "error", resErr.message)
+					return false, nil
+				}
+				return dr.HandleAPIErrorResponse(resErr.code, resErr.message, resErr.fatal)
 			}
+			return dr.HandleAPIErrorResponse(500, err.Error(), true)
+		}
+	}
 
-			rsc, err := pklRes.LoadFromPath(dr.Context, res.File)
-			if err != nil {
-				return dr.HandleAPIErrorResponse(500, err.Error(), true)
-			}
+	// Remove the request stamp file
+	if err := dr.Fs.RemoveAll(requestFilePath); err != nil {
+		dr.Logger.Error("failed to delete old requestID file", "file", requestFilePath, "error", err)
+		return false, err
+	}
 
-			runBlock := rsc.Run
-			if runBlock == nil {
-				continue
-			}
+	dr.Logger.Debug("all resources finished processing")
+	return false, nil
+}
 
-			// Skip condition
-			if runBlock.SkipCondition != nil && utils.ShouldSkip(runBlock.SkipCondition) {
-				dr.Logger.Infof("skip condition met, skipping: %s", res.ActionID)
-				continue
-			}
+// processResource runs the run blocks of every resource file declaring the given actionID. A
+// failing resource is passed to its error handler, if any.
+func (dr *DependencyResolver) processResource(ctx context.Context, nodeActionID string) error {
+	for _, res := range dr.Resources {
+		if res.ActionID != nodeActionID {
+			continue
+		}
 
-			// Preflight check
-			if runBlock.PreflightCheck != nil && runBlock.PreflightCheck.Validations != nil &&
-				!utils.AllConditionsMet(runBlock.PreflightCheck.Validations) {
-				dr.Logger.Error("preflight check not met, failing:", res.ActionID)
-				if runBlock.PreflightCheck.Error != nil {
-					return dr.HandleAPIErrorResponse(
-						runBlock.PreflightCheck.Error.Code,
-						fmt.Sprintf("%s: %s", runBlock.PreflightCheck.Error.Message, res.ActionID), false)
-				}
-				return dr.HandleAPIErrorResponse(500, "Preflight check failed for resource: "+res.ActionID, false)
-			}
+		rsc, opts, err := dr.loadResource(ctx, res.File)
+		if err != nil {
+			return &resourceError{code: 500, message: err.Error(), fatal: true}
+		}
 
-			// Process Exec step, if defined
-			if runBlock.Exec != nil && runBlock.Exec.Command != "" {
-				if err := dr.processResourceStep(res.ActionID, "exec", runBlock.Exec.TimeoutDuration, func() error {
-					return dr.HandleExec(res.ActionID, runBlock.Exec)
-				}); err != nil {
-					dr.Logger.Error("exec error:", res.ActionID)
-					return dr.HandleAPIErrorResponse(500, fmt.Sprintf("Exec failed for resource: %s - %s", res.ActionID, err), false)
-				}
+		if err := dr.runResource(ctx, res, rsc, opts); err != nil {
+			if err := dr.handleResourceError(ctx, res.ActionID, opts.OnError, err); err != nil {
+				return err
 			}
+		}
+	}
 
-			// Process Python step, if defined
-			if runBlock.Python != nil && runBlock.Python.Script != "" {
-				if err := dr.processResourceStep(res.ActionID, "python", runBlock.Python.TimeoutDuration, func() error {
-					return dr.HandlePython(res.ActionID, runBlock.Python)
-				}); err != nil {
-					dr.Logger.Error("python error:", res.ActionID)
-					return dr.HandleAPIErrorResponse(500, fmt.Sprintf("Python script failed for resource: %s - %s", res.ActionID, err), false)
-				}
-			}
+	return nil
+}
 
-			// Process Chat (LLM) step, if defined
-			if runBlock.Chat != nil && runBlock.Chat.Model != "" && runBlock.Chat.Prompt != "" {
-				if err := dr.processResourceStep(res.ActionID, "llm", runBlock.Chat.TimeoutDuration, func() error {
-					return dr.HandleLLMChat(res.ActionID, runBlock.Chat)
-				}); err != nil {
-					dr.Logger.Error("lLM chat error:", res.ActionID)
-					return dr.HandleAPIErrorResponse(500, fmt.Sprintf("LLM chat failed for resource: %s - %s", res.ActionID, err), true)
-				}
-			}
+// runResource runs the run block of a loaded resource file. The outputs are stored under the
+// actionID of res.
+func (dr *DependencyResolver) runResource(ctx context.Context, res ResourceNodeEntry, rsc *pklRes.Resource, opts *runOptions) (err error) {
+	runBlock := rsc.Run
+	if runBlock == nil {
+		return nil
+	}
+	span := TraceSpan{ActionID: res.ActionID, Step: "resource", Start: time.Now(), Outcome: traceSucceeded}
+	defer func() { dr.recordSpan(span, err) }()
 
-			// Process HTTP Client step, if defined
-			if runBlock.HTTPClient != nil && runBlock.HTTPClient.Method != "" && runBlock.HTTPClient.Url != "" {
-				if err := dr.processResourceStep(res.ActionID, "client", runBlock.HTTPClient.TimeoutDuration, func() error {
-					return dr.HandleHTTPClient(res.ActionID, runBlock.HTTPClient)
-				}); err != nil {
-					dr.Logger.Error("HTTP client error:", res.ActionID)
-					return dr.HandleAPIErrorResponse(500, fmt.Sprintf("HTTP client failed for resource: %s - %s", res.ActionID, err), false)
-				}
+	retry := dr.retrySettings(opts.Retry)
+	cache := dr.cacheSettings(res.ActionID, opts.Cache)
+
+	// Skip condition
+	if runBlock.SkipCondition != nil && utils.ShouldSkip(runBlock.SkipCondition) {
+		dr.Logger.Infof("skip condition met, skipping: %s", res.ActionID)
+		span.Outcome = traceSkipped
+		return nil
+	}
+
+	// Preflight check
+	if runBlock.PreflightCheck != nil && runBlock.PreflightCheck.Validations != nil &&
+		!utils.AllConditionsMet(runBlock.PreflightCheck.Validations) {
+		dr.Logger.Error("preflight check not met, failing:", res.ActionID)
+		span.Outcome = tracePreflightFailed
+		if runBlock.PreflightCheck.Error != nil {
+			return &resourceError{
+				code:    runBlock.PreflightCheck.Error.Code,
+				message: fmt.Sprintf("%s: %s", runBlock.PreflightCheck.Error.Message, res.ActionID),
+				step:    "preflight",
 			}
+		}
+		return &resourceError{code: 500, message: "Preflight check failed for resource: " + res.ActionID, step: "preflight"}
+	}
 
-			// API Response
-			if dr.APIServerMode && runBlock.APIResponse != nil {
-				if err := dr.CreateResponsePklFile(*runBlock.APIResponse); err != nil {
-					return dr.HandleAPIErrorResponse(500, err.Error(), true)
-				}
+	if opts.ForEach != nil {
+		if err := dr.processForEach(ctx, res, opts.ForEach, retry, cache); err != nil {
+			return err
+		}
+
+		// Evaluate the resource again so that its API response sees the forEach results.
+		if dr.APIServerMode && runBlock.APIResponse != nil {
+			reloaded, _, err := dr.loadResource(ctx, res.File)
+			if err != nil {
+				return &resourceError{code: 500, message: err.Error(), fatal: true}
 			}
+			runBlock = reloaded.Run
 		}
+	} else if _, err := dr.runSteps(ctx, res.ActionID, runBlock, opts, retry, cache); err != nil {
+		return err
 	}
 
-	// Remove the request stamp file
-	if err := dr.Fs.RemoveAll(requestFilePath); err != nil {
-		dr.Logger.Error("failed to delete old requestID file", "file", requestFilePath, "error", err)
-		return false, err
+	// API Response
+	if dr.APIServerMode && runBlock.APIResponse != nil {
+		dr.outputMu.Lock()
+		err := dr.CreateResponsePklFile(*runBlock.APIResponse)
+		if err == nil {
+			dr.responses++
+		}
+		dr.outputMu.Unlock()
+		if err != nil {
+			return &resourceError{code: 500, message: err.Error(), fatal: true, step: "response"}
+		}
 	}
 
-	dr.Logger.Debug("all resources finished processing")
-	return false, nil
+	return nil
+}
+
+// runSteps runs the exec, python, LLM and HTTP client steps of the run block, in that order, and
+// returns the last step that ran. The exec and python steps run in the given sandbox.
+func (dr *DependencyResolver) runSteps(ctx context.Context, actionID string, runBlock *pklRes.ResourceAction, opts *runOptions, retry retrySettings, cache cacheSettings) (string, error) {
+	var step string
+
+	// Process Exec step, if defined
+	if runBlock.Exec != nil && (runBlock.Exec.Command != "" || opts.Exec.hasScriptFile()) {
+		step = "exec"
+		if err := dr.runResourceStep(ctx, actionID, step, runBlock, opts, runBlock.Exec.TimeoutDuration, retry, cache, func(ctx context.Context) error {
+			return dr.HandleExec(ctx, actionID, runBlock.Exec, opts)
+		}); err != nil {
+			dr.Logger.Error("exec error:", actionID)
+			return step, newStepError(step, "Exec", actionID, err, false)
+		}
+	}
+
+	// Process Python step, if defined
+	if runBlock.Python != nil && runBlock.Python.Script != "" {
+		step = "python"
+		if err := dr.runResourceStep(ctx, actionID, step, runBlock, opts, runBlock.Python.TimeoutDuration, retry, cache, func(ctx context.Context) error {
+			return dr.HandlePython(ctx, actionID, runBlock.Python, opts)
+		}); err != nil {
+			dr.Logger.Error("python error:", actionID)
+			return step, newStepError(step, "Python script", actionID, err, false)
+		}
+	}
+
+	// Process Chat (LLM) step, if defined
+	if runBlock.Chat != nil && runBlock.Chat.Model != "" && runBlock.Chat.Prompt != "" {
+		step = "llm"
+		if err := dr.runResourceStep(ctx, actionID, step, runBlock, opts, runBlock.Chat.TimeoutDuration, retry, cache, func(ctx context.Context) error {
+			return dr.HandleLLMChat(ctx, actionID, runBlock.Chat)
+		}); err != nil {
+			dr.Logger.Error("lLM chat error:", actionID)
+			return step, newStepError(step, "LLM chat", actionID, err, true)
+		}
+	}
+
+	// Process HTTP Client step, if defined
+	if runBlock.HTTPClient != nil && runBlock.HTTPClient.Method != "" && runBlock.HTTPClient.Url != "" {
+		step = "client"
+		if err := dr.runResourceStep(ctx, actionID, step, runBlock, opts, runBlock.HTTPClient.TimeoutDuration, retry, cache, func(ctx context.Context) error {
+			return dr.HandleHTTPClient(ctx, actionID, runBlock.HTTPClient)
+		}); err != nil {
+			dr.Logger.Error("HTTP client error:", actionID)
+			return step, newStepError(step, "HTTP client", actionID, err, false)
+		}
+	}
+
+	return step, nil
 }
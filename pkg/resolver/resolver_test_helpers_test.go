@@ -0,0 +1,37 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/environment"
+	"github.com/kdeps/kdeps/pkg/evaluator"
+	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/workflow"
+	pklWf "github.com/kdeps/schema/gen/workflow"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestResolver returns a resolver of the request "req" of the workflow @agent, version 1.0.0,
+// with an in-memory file system, an in-process evaluator and the default settings. Tests set the
+// fields they need on top of it.
+func newTestResolver(t *testing.T) *DependencyResolver {
+	t.Helper()
+	manager, err := evaluator.NewManager(evaluator.ModeInProcess)
+	require.NoError(t, err)
+	return &DependencyResolver{
+		Fs:          afero.NewMemMapFs(),
+		Logger:      logging.NewTestLogger(),
+		Context:     context.Background(),
+		Evaluator:   manager,
+		Environment: &environment.Environment{},
+		Settings:    workflow.DefaultSettings(),
+		Workflow:    &pklWf.WorkflowImpl{Name: "agent", Version: "1.0.0"},
+		WorkflowDir: "/workflow",
+		ActionDir:   "/action",
+		FilesDir:    "/files",
+		CacheDir:    "/agent/cache",
+		RequestID:   "req",
+	}
+}
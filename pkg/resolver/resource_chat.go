@@ -1,13 +1,14 @@
 package resolver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/kdeps/kdeps/pkg/evaluator"
-	"github.com/kdeps/kdeps/pkg/schema"
+	"github.com/kdeps/kdeps/pkg/metrics"
 	"github.com/kdeps/kdeps/pkg/utils"
 	pklLLM "github.com/kdeps/schema/gen/llm"
 	"github.com/spf13/afero"
@@ -16,91 +17,74 @@ import (
 	"github.com/zerjioang/time32"
 )
 
-func (dr *DependencyResolver) HandleLLMChat(actionID string, chatBlock *pklLLM.ResourceChat) error {
-	if err := dr.decodeChatBlock(chatBlock); err != nil {
-		dr.Logger.Error("failed to decode chat block", "actionID", actionID, "error", err)
-		return err
-	}
-
-	go func(aID string, block *pklLLM.ResourceChat) {
-		if err := dr.processLLMChat(aID, block); err != nil {
-			dr.Logger.Error("failed to process LLM chat", "actionID", aID, "error", err)
+func (dr *DependencyResolver) HandleLLMChat(ctx context.Context, actionID string, chatBlock *pklLLM.ResourceChat) error {
+	// Process the block in the background; its completion is signaled through the step future.
+	dr.runStep(ctx, actionID, "llm", func(ctx context.Context) error {
+		if err := dr.processLLMChat(ctx, actionID, chatBlock); err != nil {
+			dr.Logger.Error("failed to process LLM chat", "actionID", actionID, "error", err)
+			return err
 		}
-	}(actionID, chatBlock)
+		return nil
+	})
 
 	return nil
 }
 
-func (dr *DependencyResolver) decodeChatBlock(chatBlock *pklLLM.ResourceChat) error {
-	decodedPrompt, err := utils.DecodeBase64IfNeeded(chatBlock.Prompt)
+func (dr *DependencyResolver) processLLMChat(ctx context.Context, actionID string, chatBlock *pklLLM.ResourceChat) error {
+	llm, err := ollama.New(ollama.WithModel(chatBlock.Model))
 	if err != nil {
-		return fmt.Errorf("failed to decode Prompt: %w", err)
-	}
-	chatBlock.Prompt = decodedPrompt
-
-	if chatBlock.JSONResponseKeys != nil {
-		decodedKeys, err := utils.DecodeStringSlice(chatBlock.JSONResponseKeys, "JSONResponseKeys")
-		if err != nil {
-			return fmt.Errorf("failed to decode JSONResponseKeys: %w", err)
-		}
-		chatBlock.JSONResponseKeys = decodedKeys
+		return err
 	}
 
-	return nil
-}
-
-func (dr *DependencyResolver) processLLMChat(actionID string, chatBlock *pklLLM.ResourceChat) error {
-	var completion string
-
-	llm, err := ollama.New(ollama.WithModel(chatBlock.Model))
+	start := time.Now()
+	completion, err := generateCompletion(ctx, llm, chatBlock)
+	metrics.ObserveLLMCall(chatBlock.Model, err, time.Since(start))
 	if err != nil {
 		return err
 	}
 
-	if chatBlock.JSONResponse != nil && *chatBlock.JSONResponse {
-		systemPrompt := "Respond in JSON format."
-		if chatBlock.JSONResponseKeys != nil && len(*chatBlock.JSONResponseKeys) > 0 {
-			systemPrompt = fmt.Sprintf("Respond in JSON format, include `%s` in response keys.", strings.Join(*chatBlock.JSONResponseKeys, "`, `"))
-		}
+	completion = dr.Secrets.Redact(completion)
+	chatBlock.Response = &completion
+	return dr.AppendChatEntry(actionID, chatBlock)
+}
 
-		content := []llms.MessageContent{
-			llms.TextParts(llms.ChatMessageTypeSystem, systemPrompt),
-			llms.TextParts(llms.ChatMessageTypeHuman, chatBlock.Prompt),
-		}
+// generateCompletion sends the prompt of the chat block to the model, asking for a JSON
+// response when the block requires one.
+func generateCompletion(ctx context.Context, llm *ollama.LLM, chatBlock *pklLLM.ResourceChat) (string, error) {
+	if chatBlock.JSONResponse == nil || !*chatBlock.JSONResponse {
+		return llm.Call(ctx, chatBlock.Prompt)
+	}
 
-		response, err := llm.GenerateContent(dr.Context, content, llms.WithJSONMode())
-		if err != nil {
-			return err
-		}
+	systemPrompt := "Respond in JSON format."
+	if chatBlock.JSONResponseKeys != nil && len(*chatBlock.JSONResponseKeys) > 0 {
+		systemPrompt = fmt.Sprintf("Respond in JSON format, include `%s` in response keys.", strings.Join(*chatBlock.JSONResponseKeys, "`, `"))
+	}
 
-		if len(response.Choices) == 0 {
-			return errors.New("empty response from model")
-		}
-		completion = response.Choices[0].Content
-	} else {
-		completion, err = llm.Call(dr.Context, chatBlock.Prompt)
-		if err != nil {
-			return err
-		}
+	content := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, systemPrompt),
+		llms.TextParts(llms.ChatMessageTypeHuman, chatBlock.Prompt),
 	}
 
-	chatBlock.Response = &completion
-	return dr.AppendChatEntry(actionID, chatBlock)
+	response, err := llm.GenerateContent(ctx, content, llms.WithJSONMode())
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("empty response from model")
+	}
+	return response.Choices[0].Content, nil
 }
 
+// AppendChatEntry records the output of the chat block of a resource in the result store.
 func (dr *DependencyResolver) AppendChatEntry(resourceID string, newChat *pklLLM.ResourceChat) error {
-	pklPath := filepath.Join(dr.ActionDir, "llm/"+dr.RequestID+"__llm_output.pkl")
-	newTimestamp := uint32(time32.Epoch())
-
-	pklRes, err := pklLLM.LoadFromPath(dr.Context, pklPath)
-	if err != nil {
-		return fmt.Errorf("failed to load PKL file: %w", err)
-	}
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
 
-	existingResources := *pklRes.GetResources()
+	newTimestamp := int(time32.Epoch())
 
 	var filePath string
 	if newChat.Response != nil {
+		var err error
 		filePath, err = dr.WriteResponseToFile(resourceID, newChat.Response)
 		if err != nil {
 			return fmt.Errorf("failed to write response to file: %w", err)
@@ -108,94 +92,27 @@ func (dr *DependencyResolver) AppendChatEntry(resourceID string, newChat *pklLLM
 		newChat.File = &filePath
 	}
 
-	encodedModel := utils.EncodeValue(newChat.Model)
-	encodedPrompt := utils.EncodeValue(newChat.Prompt)
-	encodedResponse := utils.EncodeValuePtr(newChat.Response)
-	encodedJSONResponseKeys := dr.encodeChatJSONResponseKeys(newChat.JSONResponseKeys)
-
-	existingResources[resourceID] = &pklLLM.ResourceChat{
-		Model:            encodedModel,
-		Prompt:           encodedPrompt,
-		JSONResponse:     newChat.JSONResponse,
-		JSONResponseKeys: encodedJSONResponseKeys,
-		Response:         encodedResponse,
-		File:             &filePath,
-		Timestamp:        &newTimestamp,
-		TimeoutDuration:  newChat.TimeoutDuration,
-	}
-
-	var pklContent strings.Builder
-	pklContent.WriteString(fmt.Sprintf("extends \"package://schema.kdeps.com/core@%s#/LLM.pkl\"\n\n", schema.SchemaVersion(dr.Context)))
-	pklContent.WriteString("resources {\n")
-
-	for id, res := range existingResources {
-		pklContent.WriteString(fmt.Sprintf("  [\"%s\"] {\n", id))
-		pklContent.WriteString(fmt.Sprintf("    model = \"%s\"\n", res.Model))
-		pklContent.WriteString(fmt.Sprintf("    prompt = \"%s\"\n", res.Prompt))
-
-		if res.JSONResponse != nil {
-			pklContent.WriteString(fmt.Sprintf("    JSONResponse = %t\n", *res.JSONResponse))
-		}
-
-		pklContent.WriteString("    JSONResponseKeys ")
-		if res.JSONResponseKeys != nil {
-			pklContent.WriteString(utils.EncodePklSlice(res.JSONResponseKeys))
-		} else {
-			pklContent.WriteString("{}\n")
-		}
-
-		pklContent.WriteString(fmt.Sprintf("    timeoutDuration = %d\n", res.TimeoutDuration))
-		pklContent.WriteString(fmt.Sprintf("    timestamp = %d\n", *res.Timestamp))
-
-		if res.Response != nil {
-			pklContent.WriteString(fmt.Sprintf("    response = #\"\"\"\n%s\n\"\"\"#\n", *res.Response))
-		} else {
-			pklContent.WriteString("    response = \"\"\n")
-		}
-
-		pklContent.WriteString(fmt.Sprintf("    file = \"%s\"\n", *res.File))
-		pklContent.WriteString("  }\n")
-	}
-	pklContent.WriteString("}\n")
-
-	if err := afero.WriteFile(dr.Fs, pklPath, []byte(pklContent.String()), 0o644); err != nil {
-		return fmt.Errorf("failed to write PKL file: %w", err)
-	}
-
-	evaluatedContent, err := evaluator.EvalPkl(dr.Fs, dr.Context, pklPath,
-		fmt.Sprintf("extends \"package://schema.kdeps.com/core@%s#/LLM.pkl\"", schema.SchemaVersion(dr.Context)), dr.Logger)
-	if err != nil {
-		return fmt.Errorf("failed to evaluate PKL file: %w", err)
-	}
-
-	return afero.WriteFile(dr.Fs, pklPath, []byte(evaluatedContent), 0o644)
+	return dr.recordResult("llm", "LLM.pkl", resourceID,
+		textField("model", newChat.Model),
+		textField("prompt", newChat.Prompt),
+		boolField("JSONResponse", newChat.JSONResponse != nil && *newChat.JSONResponse),
+		listField("JSONResponseKeys", newChat.JSONResponseKeys),
+		intField("timeoutDuration", newChat.TimeoutDuration, 60),
+		intField("timestamp", &newTimestamp, 0),
+		textPtrField("response", newChat.Response),
+		stringField("file", filePath),
+	)
 }
 
-func (dr *DependencyResolver) encodeChatJSONResponseKeys(keys *[]string) *[]string {
-	if keys == nil {
-		return nil
-	}
-	encoded := make([]string, len(*keys))
-	for i, v := range *keys {
-		encoded[i] = utils.EncodeValue(v)
-	}
-	return &encoded
-}
-
-func (dr *DependencyResolver) WriteResponseToFile(resourceID string, responseEncoded *string) (string, error) {
-	if responseEncoded == nil {
+func (dr *DependencyResolver) WriteResponseToFile(resourceID string, response *string) (string, error) {
+	if response == nil {
 		return "", nil
 	}
 
 	resourceIDFile := utils.GenerateResourceIDFilename(resourceID, dr.RequestID)
 	outputFilePath := filepath.Join(dr.FilesDir, resourceIDFile)
 
-	content, err := utils.DecodeBase64IfNeeded(*responseEncoded)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	if err := afero.WriteFile(dr.Fs, outputFilePath, []byte(content), 0o644); err != nil {
+	if err := afero.WriteFile(dr.Fs, outputFilePath, []byte(*response), 0o644); err != nil {
 		return "", fmt.Errorf("failed to write file: %w", err)
 	}
 
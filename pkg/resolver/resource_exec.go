@@ -1,217 +1,260 @@
 package resolver
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
 
-	"github.com/alexellis/go-execute/v2"
-	"github.com/kdeps/kdeps/pkg/evaluator"
-	"github.com/kdeps/kdeps/pkg/schema"
-	"github.com/kdeps/kdeps/pkg/utils"
+	"github.com/apple/pkl-go/pkl"
 	pklExec "github.com/kdeps/schema/gen/exec"
 	"github.com/spf13/afero"
 	"github.com/zerjioang/time32"
 )
 
-func (dr *DependencyResolver) HandleExec(actionID string, execBlock *pklExec.ResourceExec) error {
-	// Decode the exec block synchronously
-	if err := dr.decodeExecBlock(execBlock); err != nil {
-		dr.Logger.Error("failed to decode exec block", "actionID", actionID, "error", err)
-		return err
-	}
+// execOptions holds the exec block properties that are read on top of the generated schema type.
+type execOptions struct {
+	// Arguments of the command. When set, the command is the executable and runs without a shell.
+	Args *[]string `pkl:"args"`
 
-	// Run processExecBlock asynchronously in a goroutine
-	go func(aID string, block *pklExec.ResourceExec) {
-		if err := dr.processExecBlock(aID, block); err != nil {
-			// Log the error; consider additional error handling as needed.
-			dr.Logger.Error("failed to process exec block", "actionID", aID, "error", err)
-		}
-	}(actionID, execBlock)
+	// Value written to the standard input of the process.
+	Stdin *string `pkl:"stdin"`
 
-	// Return immediately; the exec block is being processed in the background.
-	return nil
+	// Directory the process runs in, relative to the project directory.
+	WorkingDir *string `pkl:"workingDir"`
+
+	// Script of the project, relative to the project directory, that runs instead of the command.
+	// The command, when set, is the interpreter of the script.
+	ScriptFile *string `pkl:"scriptFile"`
 }
 
-func (dr *DependencyResolver) decodeExecBlock(execBlock *pklExec.ResourceExec) error {
-	// Decode Command
-	decodedCommand, err := utils.DecodeBase64IfNeeded(execBlock.Command)
-	if err != nil {
-		return fmt.Errorf("failed to decode command: %w", err)
+// loadExecOptions decodes the exec block options, or returns nil when none is set.
+func loadExecOptions(ctx context.Context, evaluator pkl.Evaluator, source *pkl.ModuleSource) (*execOptions, error) {
+	var (
+		opts = &execOptions{}
+		err  error
+	)
+	if opts.Args, err = loadBlockOption[[]string](ctx, evaluator, source, "run.exec", "args"); err != nil {
+		return nil, err
 	}
-	execBlock.Command = decodedCommand
-
-	// Decode Stderr
-	if execBlock.Stderr != nil {
-		decodedStderr, err := utils.DecodeBase64IfNeeded(*execBlock.Stderr)
-		if err != nil {
-			return fmt.Errorf("failed to decode stderr: %w", err)
-		}
-		execBlock.Stderr = &decodedStderr
+	if opts.Stdin, err = loadBlockOption[string](ctx, evaluator, source, "run.exec", "stdin"); err != nil {
+		return nil, err
 	}
-
-	// Decode Stdout
-	if execBlock.Stdout != nil {
-		decodedStdout, err := utils.DecodeBase64IfNeeded(*execBlock.Stdout)
-		if err != nil {
-			return fmt.Errorf("failed to decode stdout: %w", err)
-		}
-		execBlock.Stdout = &decodedStdout
+	if opts.WorkingDir, err = loadBlockOption[string](ctx, evaluator, source, "run.exec", "workingDir"); err != nil {
+		return nil, err
 	}
-
-	// Decode Env values
-	decodedEnv, err := utils.DecodeStringMap(execBlock.Env, "env")
-	if err != nil {
-		return fmt.Errorf("failed to decode env: %w", err)
+	if opts.ScriptFile, err = loadBlockOption[string](ctx, evaluator, source, "run.exec", "scriptFile"); err != nil {
+		return nil, err
+	}
+	if opts.Args == nil && opts.Stdin == nil && opts.WorkingDir == nil && opts.ScriptFile == nil {
+		return nil, nil
 	}
-	execBlock.Env = decodedEnv
+	return opts, nil
+}
 
-	return nil
+// hasScriptFile reports whether the exec block runs a script file of the project.
+func (o *execOptions) hasScriptFile() bool {
+	return o != nil && o.ScriptFile != nil && *o.ScriptFile != ""
 }
 
-func (dr *DependencyResolver) processExecBlock(actionID string, execBlock *pklExec.ResourceExec) error {
-	var env []string
-	if execBlock.Env != nil {
-		for key, value := range *execBlock.Env {
-			env = append(env, fmt.Sprintf(`%s=%s`, key, value))
+func (dr *DependencyResolver) HandleExec(ctx context.Context, actionID string, execBlock *pklExec.ResourceExec, opts *runOptions) error {
+	// Process the block in the background; its completion is signaled through the step future.
+	dr.runStep(ctx, actionID, "exec", func(ctx context.Context) error {
+		if err := dr.processExecBlock(ctx, actionID, execBlock, opts); err != nil {
+			dr.Logger.Error("failed to process exec block", "actionID", actionID, "error", err)
+			return err
 		}
-	}
+		return nil
+	})
 
-	dr.Logger.Info("executing command", "command", execBlock.Command, "env", env)
+	// Return immediately; the exec block is being processed in the background.
+	return nil
+}
 
-	cmd := execute.ExecTask{
-		Command:     execBlock.Command,
-		Shell:       true,
-		Env:         env,
-		StreamStdio: false,
+func (dr *DependencyResolver) processExecBlock(ctx context.Context, actionID string, execBlock *pklExec.ResourceExec, opts *runOptions) error {
+	task, err := dr.execTask(execBlock, opts.Exec, opts.Sandbox)
+	if err != nil {
+		return err
 	}
 
-	result, err := cmd.Execute(dr.Context)
+	env, envKeys, err := dr.stepEnv(execBlock.Env)
 	if err != nil {
 		return err
 	}
+	task.Env = env
+	task.OnLine = dr.streamOutput(actionID)
+	task.Sandbox = opts.Sandbox
 
-	execBlock.Stdout = &result.Stdout
-	execBlock.Stderr = &result.Stderr
-
-	return dr.AppendExecEntry(actionID, execBlock)
-}
-
-func (dr *DependencyResolver) WriteStdoutToFile(resourceID string, stdoutEncoded *string) (string, error) {
-	if stdoutEncoded == nil {
-		return "", nil
+	stdoutFile, err := dr.createStdoutFile(actionID)
+	if err != nil {
+		return err
 	}
+	task.Stdout = stdoutFile
 
-	resourceIDFile := utils.GenerateResourceIDFilename(resourceID, dr.RequestID)
-	outputFilePath := filepath.Join(dr.FilesDir, resourceIDFile)
+	dr.Logger.Info("executing command", "command", task.Command, "args", task.Args, "dir", task.Dir, "env", envKeys)
 
-	content, err := utils.DecodeBase64IfNeeded(*stdoutEncoded)
+	result, err := runCommand(ctx, task)
+	if closeErr := stdoutFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to decode stdout: %w", err)
+		return err
 	}
+	dr.redactResult(&result)
 
-	if err := afero.WriteFile(dr.Fs, outputFilePath, []byte(content), 0o644); err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+	file := stdoutFile.path()
+	execBlock.Stdout = &result.Stdout
+	execBlock.Stderr = &result.Stderr
+	execBlock.ExitCode = &result.ExitCode
+	execBlock.File = &file
+
+	if err := dr.AppendExecEntry(actionID, execBlock); err != nil {
+		return err
 	}
 
-	return outputFilePath, nil
+	return dr.checkExitCode(opts.FailOnNonZeroExit, result)
 }
 
-//nolint:dupl
-func (dr *DependencyResolver) AppendExecEntry(resourceID string, newExec *pklExec.ResourceExec) error {
-	pklPath := filepath.Join(dr.ActionDir, "exec/"+dr.RequestID+"__exec_output.pkl")
-	newTimestamp := uint32(time32.Epoch())
-
-	pklRes, err := pklExec.LoadFromPath(dr.Context, pklPath)
-	if err != nil {
-		return fmt.Errorf("failed to load PKL file: %w", err)
+// execTask returns the process of an exec block, after checking it against the sandbox policy.
+// The command runs through the shell, unless args are set, in which case the command is the
+// executable and receives the args as they are, so they cannot inject shell syntax. A script
+// file runs through the shell, or through the command when one is set.
+func (dr *DependencyResolver) execTask(execBlock *pklExec.ResourceExec, opts *execOptions, sandbox *sandboxPolicy) (commandTask, error) {
+	task := commandTask{Command: execBlock.Command, Shell: true}
+	if opts == nil {
+		return task, sandbox.checkScript(task.Command)
 	}
 
-	existingResources := *pklRes.GetResources()
+	task.Stdin = opts.Stdin
+	if opts.WorkingDir != nil && *opts.WorkingDir != "" {
+		task.Dir = dr.projectPath(*opts.WorkingDir)
+	}
+	var args []string
+	if opts.Args != nil {
+		args = *opts.Args
+	}
 
-	// Prepare file path and write stdout to file
-	var filePath string
-	if newExec.Stdout != nil {
-		filePath, err = dr.WriteStdoutToFile(resourceID, newExec.Stdout)
+	switch {
+	case opts.hasScriptFile():
+		script := dr.projectPath(*opts.ScriptFile)
+		if !isWithin(dr.projectDir(), script) {
+			return task, fmt.Errorf("script file %s is outside of the project directory", *opts.ScriptFile)
+		}
+		// The path is checked again with its links resolved, so that a link in the project cannot
+		// point outside of it.
+		script, err := dr.realPath(script)
 		if err != nil {
-			return fmt.Errorf("failed to write stdout to file: %w", err)
+			return task, fmt.Errorf("failed to read script file: %w", err)
+		}
+		projectDir, err := dr.realPath(dr.projectDir())
+		if err != nil {
+			return task, fmt.Errorf("failed to resolve the project directory: %w", err)
+		}
+		if !isWithin(projectDir, script) {
+			return task, fmt.Errorf("script file %s is outside of the project directory", *opts.ScriptFile)
+		}
+		content, err := afero.ReadFile(dr.Fs, script)
+		if err != nil {
+			return task, fmt.Errorf("failed to read script file: %w", err)
+		}
+		if task.Command == "" {
+			if err := sandbox.checkScript(string(content)); err != nil {
+				return task, err
+			}
+			task.Command = shellPath()
+		} else if err := sandbox.checkArgs(task.Command, append([]string{script}, args...)); err != nil {
+			return task, err
+		}
+		task.Shell = false
+		task.Args = append([]string{script}, args...)
+	case opts.Args != nil:
+		if err := sandbox.checkArgs(task.Command, args); err != nil {
+			return task, err
+		}
+		task.Shell = false
+		task.Args = args
+	default:
+		if err := sandbox.checkScript(task.Command); err != nil {
+			return task, err
 		}
-		newExec.File = &filePath
 	}
+	return task, nil
+}
 
-	// Encode fields for PKL storage
-	encodedCommand := utils.EncodeValue(newExec.Command)
-	encodedEnv := dr.encodeExecEnv(newExec.Env)
-	encodedStderr, encodedStdout := dr.encodeExecOutputs(newExec.Stderr, newExec.Stdout)
-
-	existingResources[resourceID] = &pklExec.ResourceExec{
-		Env:       encodedEnv,
-		Command:   encodedCommand,
-		Stderr:    encodedStderr,
-		Stdout:    encodedStdout,
-		File:      &filePath,
-		Timestamp: &newTimestamp,
+// projectDir returns the project directory of the request, which holds the files of the project
+// next to the workflow resources.
+func (dr *DependencyResolver) projectDir() string {
+	if dr.projectRoot != "" {
+		return dr.projectRoot
 	}
+	return dr.WorkflowDir
+}
 
-	var pklContent strings.Builder
-	pklContent.WriteString(fmt.Sprintf("extends \"package://schema.kdeps.com/core@%s#/Exec.pkl\"\n\n", schema.SchemaVersion(dr.Context)))
-	pklContent.WriteString("resources {\n")
-
-	for id, res := range existingResources {
-		pklContent.WriteString(fmt.Sprintf("  [\"%s\"] {\n", id))
-		pklContent.WriteString(fmt.Sprintf("    command = \"%s\"\n", res.Command))
-		pklContent.WriteString(fmt.Sprintf("    timeoutDuration = %d\n", res.TimeoutDuration))
-		pklContent.WriteString(fmt.Sprintf("    timestamp = %d\n", *res.Timestamp))
-
-		pklContent.WriteString("    env ")
-		pklContent.WriteString(utils.EncodePklMap(res.Env))
+// isWithin reports whether path is dir or inside of it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
 
-		pklContent.WriteString(dr.encodeExecStderr(res.Stderr))
-		pklContent.WriteString(dr.encodeExecStdout(res.Stdout))
-		pklContent.WriteString(fmt.Sprintf("    file = \"%s\"\n", *res.File))
+// realPath returns path with its symbolic links resolved. Only the OS filesystem has symbolic
+// links, so the paths of the other filesystems are returned as they are.
+func (dr *DependencyResolver) realPath(path string) (string, error) {
+	if _, ok := dr.Fs.(*afero.OsFs); !ok {
+		return path, nil
+	}
+	return filepath.EvalSymlinks(path)
+}
 
-		pklContent.WriteString("  }\n")
+// projectPath resolves a path relative to the project directory of the request.
+func (dr *DependencyResolver) projectPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
 	}
-	pklContent.WriteString("}\n")
+	return filepath.Join(dr.projectDir(), path)
+}
 
-	if err := afero.WriteFile(dr.Fs, pklPath, []byte(pklContent.String()), 0o644); err != nil {
-		return fmt.Errorf("failed to write PKL file: %w", err)
+func (dr *DependencyResolver) WriteStdoutToFile(resourceID string, stdout *string) (string, error) {
+	if stdout == nil {
+		return "", nil
 	}
 
-	evaluatedContent, err := evaluator.EvalPkl(dr.Fs, dr.Context, pklPath,
-		fmt.Sprintf("extends \"package://schema.kdeps.com/core@%s#/Exec.pkl\"", schema.SchemaVersion(dr.Context)), dr.Logger)
-	if err != nil {
-		return fmt.Errorf("failed to evaluate PKL: %w", err)
+	outputFilePath := dr.stdoutFile(resourceID)
+	if err := afero.WriteFile(dr.Fs, outputFilePath, []byte(dr.truncateOutput("stdout", *stdout)), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
 	}
 
-	return afero.WriteFile(dr.Fs, pklPath, []byte(evaluatedContent), 0o644)
+	return outputFilePath, nil
 }
 
-func (dr *DependencyResolver) encodeExecEnv(env *map[string]string) *map[string]string {
-	if env == nil {
-		return nil
-	}
-	encoded := make(map[string]string)
-	for k, v := range *env {
-		encoded[k] = utils.EncodeValue(v)
-	}
-	return &encoded
-}
+// AppendExecEntry records the output of the exec block of a resource in the result store. The
+// stdout is written to the stdout file of the resource, unless the process already streamed it
+// there.
+func (dr *DependencyResolver) AppendExecEntry(resourceID string, newExec *pklExec.ResourceExec) error {
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
 
-func (dr *DependencyResolver) encodeExecOutputs(stderr, stdout *string) (*string, *string) {
-	return utils.EncodeValuePtr(stderr), utils.EncodeValuePtr(stdout)
-}
+	newTimestamp := int(time32.Epoch())
 
-func (dr *DependencyResolver) encodeExecStderr(stderr *string) string {
-	if stderr == nil {
-		return "    stderr = \"\"\n"
+	// Prepare file path and write stdout to file
+	var filePath string
+	if newExec.Stdout != nil {
+		filePath = dr.stdoutFile(resourceID)
+		if newExec.File == nil || *newExec.File != filePath {
+			if _, err := dr.WriteStdoutToFile(resourceID, newExec.Stdout); err != nil {
+				return fmt.Errorf("failed to write stdout to file: %w", err)
+			}
+		}
+		newExec.File = &filePath
 	}
-	return fmt.Sprintf("    stderr = #\"\"\"\n%s\n\"\"\"#\n", *stderr)
-}
 
-func (dr *DependencyResolver) encodeExecStdout(stdout *string) string {
-	if stdout == nil {
-		return "    stdout = \"\"\n"
-	}
-	return fmt.Sprintf("    stdout = #\"\"\"\n%s\n\"\"\"#\n", *stdout)
+	return dr.recordResult("exec", "Exec.pkl", resourceID,
+		textField("command", newExec.Command),
+		intField("timeoutDuration", newExec.TimeoutDuration, 60),
+		intField("timestamp", &newTimestamp, 0),
+		mapField("env", newExec.Env),
+		textPtrField("stderr", newExec.Stderr),
+		stdoutField(newExec.Stdout, filePath),
+		intField("exitCode", newExec.ExitCode, 0),
+		stringField("file", filePath),
+	)
 }
@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	pklExec "github.com/kdeps/schema/gen/exec"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecTask(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	require.NoError(t, afero.WriteFile(dr.Fs, "/workflow/scripts/setup.sh", []byte("echo ready"), 0o755))
+	strPtr := func(v string) *string { return &v }
+
+	t.Run("Shell", func(t *testing.T) {
+		t.Parallel()
+		task, err := dr.execTask(&pklExec.ResourceExec{Command: "echo hi"}, nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, commandTask{Command: "echo hi", Shell: true}, task)
+	})
+
+	t.Run("Args", func(t *testing.T) {
+		t.Parallel()
+		args := []string{"-n", "$(whoami); rm -rf /"}
+		task, err := dr.execTask(&pklExec.ResourceExec{Command: "echo"}, &execOptions{
+			Args:       &args,
+			Stdin:      strPtr("body"),
+			WorkingDir: strPtr("data"),
+		}, nil)
+		require.NoError(t, err)
+		assert.False(t, task.Shell)
+		assert.Equal(t, args, task.Args)
+		assert.Equal(t, "body", *task.Stdin)
+		assert.Equal(t, "/workflow/data", task.Dir)
+
+		denied := []string{"echo"}
+		_, err = dr.execTask(&pklExec.ResourceExec{Command: "/bin/echo"}, &execOptions{Args: &args}, &sandboxPolicy{DeniedCommands: &denied})
+		require.ErrorContains(t, err, `command "echo" is denied`)
+	})
+
+	t.Run("ScriptFile", func(t *testing.T) {
+		t.Parallel()
+		args := []string{"--verbose"}
+		task, err := dr.execTask(&pklExec.ResourceExec{}, &execOptions{ScriptFile: strPtr("scripts/setup.sh"), Args: &args}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, shellPath(), task.Command)
+		assert.Equal(t, []string{"/workflow/scripts/setup.sh", "--verbose"}, task.Args)
+
+		task, err = dr.execTask(&pklExec.ResourceExec{Command: "python3"}, &execOptions{ScriptFile: strPtr("scripts/setup.sh")}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "python3", task.Command)
+		assert.Equal(t, []string{"/workflow/scripts/setup.sh"}, task.Args)
+
+		allowed := []string{"ls"}
+		_, err = dr.execTask(&pklExec.ResourceExec{}, &execOptions{ScriptFile: strPtr("scripts/setup.sh")}, &sandboxPolicy{AllowedCommands: &allowed})
+		require.ErrorContains(t, err, `command "echo" is not allowed`)
+
+		_, err = dr.execTask(&pklExec.ResourceExec{}, &execOptions{ScriptFile: strPtr("../secrets.sh")}, nil)
+		require.ErrorContains(t, err, "is outside of the project directory")
+
+		_, err = dr.execTask(&pklExec.ResourceExec{}, &execOptions{ScriptFile: strPtr("missing.sh")}, nil)
+		require.ErrorContains(t, err, "failed to read script file")
+	})
+
+	t.Run("ScriptFileSymlink", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		dr := newTestResolver(t)
+		dr.Fs = afero.NewOsFs()
+		dr.WorkflowDir = filepath.Join(dir, "workflow")
+		require.NoError(t, os.MkdirAll(filepath.Join(dr.WorkflowDir, "scripts"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dr.WorkflowDir, "scripts", "setup.sh"), []byte("echo ready"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "secrets.sh"), []byte("cat /etc/shadow"), 0o755))
+		require.NoError(t, os.Symlink(filepath.Join(dir, "secrets.sh"), filepath.Join(dr.WorkflowDir, "scripts", "outside.sh")))
+		require.NoError(t, os.Symlink("setup.sh", filepath.Join(dr.WorkflowDir, "scripts", "inside.sh")))
+
+		_, err := dr.execTask(&pklExec.ResourceExec{}, &execOptions{ScriptFile: strPtr("scripts/outside.sh")}, nil)
+		require.ErrorContains(t, err, "script file scripts/outside.sh is outside of the project directory")
+
+		task, err := dr.execTask(&pklExec.ResourceExec{}, &execOptions{ScriptFile: strPtr("scripts/inside.sh")}, nil)
+		require.NoError(t, err)
+		realDir, err := filepath.EvalSymlinks(dr.WorkflowDir)
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(realDir, "scripts", "setup.sh")}, task.Args)
+
+		_, err = dr.execTask(&pklExec.ResourceExec{}, &execOptions{ScriptFile: strPtr("scripts/missing.sh")}, nil)
+		require.ErrorContains(t, err, "failed to read script file")
+	})
+}
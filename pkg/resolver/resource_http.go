@@ -2,6 +2,7 @@ package resolver
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -11,174 +12,98 @@ import (
 	"strings"
 	"time"
 
-	"github.com/kdeps/kdeps/pkg/evaluator"
-	"github.com/kdeps/kdeps/pkg/schema"
+	"github.com/kdeps/kdeps/pkg/telemetry"
 	"github.com/kdeps/kdeps/pkg/utils"
 	pklHTTP "github.com/kdeps/schema/gen/http"
 	"github.com/spf13/afero"
 	"github.com/zerjioang/time32"
 )
 
-func (dr *DependencyResolver) HandleHTTPClient(actionID string, httpBlock *pklHTTP.ResourceHTTPClient) error {
-	// Synchronously decode the HTTP block.
-	if err := dr.decodeHTTPBlock(httpBlock); err != nil {
-		dr.Logger.Error("failed to decode HTTP block", "actionID", actionID, "error", err)
-		return err
-	}
-
-	// Process the HTTP block asynchronously in a goroutine.
-	go func(aID string, block *pklHTTP.ResourceHTTPClient) {
-		if err := dr.processHTTPBlock(aID, block); err != nil {
-			// Log the error; you can adjust error handling as needed.
-			dr.Logger.Error("failed to process HTTP block", "actionID", aID, "error", err)
+func (dr *DependencyResolver) HandleHTTPClient(ctx context.Context, actionID string, httpBlock *pklHTTP.ResourceHTTPClient) error {
+	// Process the block in the background; its completion is signaled through the step future.
+	dr.runStep(ctx, actionID, "client", func(ctx context.Context) error {
+		if err := dr.processHTTPBlock(ctx, actionID, httpBlock); err != nil {
+			dr.Logger.Error("failed to process HTTP block", "actionID", actionID, "error", err)
+			return err
 		}
-	}(actionID, httpBlock)
+		return nil
+	})
 
 	// Return immediately; the HTTP block is processed in the background.
 	return nil
 }
 
-func (dr *DependencyResolver) processHTTPBlock(actionID string, httpBlock *pklHTTP.ResourceHTTPClient) error {
-	if err := dr.DoRequest(httpBlock); err != nil {
-		return err
-	}
-	return dr.AppendHTTPEntry(actionID, httpBlock)
-}
-
-func (dr *DependencyResolver) decodeHTTPBlock(httpBlock *pklHTTP.ResourceHTTPClient) error {
-	if utils.IsBase64Encoded(httpBlock.Url) {
-		decodedURL, err := utils.DecodeBase64String(httpBlock.Url)
-		if err != nil {
-			return fmt.Errorf("failed to decode URL: %w", err)
-		}
-		httpBlock.Url = decodedURL
-	}
-
-	var err error
-	httpBlock.Headers, err = utils.DecodeStringMap(httpBlock.Headers, "header")
+func (dr *DependencyResolver) processHTTPBlock(ctx context.Context, actionID string, httpBlock *pklHTTP.ResourceHTTPClient) error {
+	statusCode, err := dr.DoRequest(ctx, httpBlock)
 	if err != nil {
 		return err
 	}
-
-	httpBlock.Params, err = utils.DecodeStringMap(httpBlock.Params, "param")
-	if err != nil {
+	if err := dr.AppendHTTPEntry(actionID, httpBlock); err != nil {
 		return err
 	}
+	if err := dr.updateStatus(actionID, func(status *resourceStatus) { status.statusCode = statusCode }); err != nil {
+		dr.Logger.Error("failed to record HTTP status code", "actionID", actionID, "error", err)
+	}
 
-	httpBlock.Data, err = utils.DecodeStringSlice(httpBlock.Data, "data")
-	return err
+	if statusCode >= http.StatusInternalServerError {
+		return &httpStatusError{statusCode: statusCode}
+	}
+	return nil
 }
 
-func (dr *DependencyResolver) WriteResponseBodyToFile(resourceID string, responseBodyEncoded *string) (string, error) {
-	if responseBodyEncoded == nil {
+func (dr *DependencyResolver) WriteResponseBodyToFile(resourceID string, responseBody *string) (string, error) {
+	if responseBody == nil {
 		return "", nil
 	}
 
 	resourceIDFile := utils.GenerateResourceIDFilename(resourceID, dr.RequestID)
 	outputFilePath := filepath.Join(dr.FilesDir, resourceIDFile)
 
-	content, err := utils.DecodeBase64IfNeeded(*responseBodyEncoded)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode response body: %w", err)
-	}
-
-	if err := afero.WriteFile(dr.Fs, outputFilePath, []byte(content), 0o644); err != nil {
+	if err := afero.WriteFile(dr.Fs, outputFilePath, []byte(*responseBody), 0o644); err != nil {
 		return "", fmt.Errorf("failed to write file: %w", err)
 	}
 	return outputFilePath, nil
 }
 
+// AppendHTTPEntry records the request and response of the HTTP client block of a resource in
+// the result store.
 func (dr *DependencyResolver) AppendHTTPEntry(resourceID string, client *pklHTTP.ResourceHTTPClient) error {
-	pklPath := filepath.Join(dr.ActionDir, "client/"+dr.RequestID+"__client_output.pkl")
-	timestamp := uint32(time32.Epoch())
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
 
-	pklRes, err := pklHTTP.LoadFromPath(dr.Context, pklPath)
-	if err != nil {
-		return fmt.Errorf("failed to load PKL: %w", err)
-	}
-
-	existingResources := *pklRes.GetResources()
+	timestamp := int(time32.Epoch())
 	resourceIDFile := utils.GenerateResourceIDFilename(resourceID, dr.RequestID)
 	filePath := filepath.Join(dr.FilesDir, resourceIDFile)
 
-	encodedURL := client.Url
-	if !utils.IsBase64Encoded(encodedURL) {
-		encodedURL = utils.EncodeBase64String(encodedURL)
-	}
-
-	existingResources[resourceID] = &pklHTTP.ResourceHTTPClient{
-		Method:    client.Method,
-		Url:       encodedURL,
-		Data:      client.Data,
-		Headers:   client.Headers,
-		Response:  client.Response,
-		File:      &filePath,
-		Timestamp: &timestamp,
-	}
-
-	var pklContent strings.Builder
-	pklContent.WriteString(fmt.Sprintf("extends \"package://schema.kdeps.com/core@%s#/HTTP.pkl\"\n\n", schema.SchemaVersion(dr.Context)))
-	pklContent.WriteString("resources {\n")
-
-	for id, res := range existingResources {
-		pklContent.WriteString(fmt.Sprintf("  [\"%s\"] {\n", id))
-		pklContent.WriteString(fmt.Sprintf("    method = \"%s\"\n", res.Method))
-		pklContent.WriteString(fmt.Sprintf("    url = \"%s\"\n", res.Url))
-		pklContent.WriteString(fmt.Sprintf("    timeoutDuration = %d\n", res.TimeoutDuration))
-		pklContent.WriteString(fmt.Sprintf("    timestamp = %d\n", *res.Timestamp))
-
-		pklContent.WriteString("    data ")
-		pklContent.WriteString(utils.EncodePklSlice(res.Data))
-		pklContent.WriteString("    headers ")
-		pklContent.WriteString(utils.EncodePklMap(res.Headers))
-		pklContent.WriteString("    params ")
-		pklContent.WriteString(utils.EncodePklMap(res.Params))
-		pklContent.WriteString("    response {\n")
-		pklContent.WriteString(encodeResponseHeaders(res.Response))
-		pklContent.WriteString(encodeResponseBody(res.Response, dr, resourceID))
-		pklContent.WriteString("    }\n")
-		pklContent.WriteString(fmt.Sprintf("    file = \"%s\"\n", *res.File))
-		pklContent.WriteString("  }\n")
-	}
-	pklContent.WriteString("}\n")
-
-	if err := afero.WriteFile(dr.Fs, pklPath, []byte(pklContent.String()), 0o644); err != nil {
-		return fmt.Errorf("failed to write PKL: %w", err)
-	}
-
-	evaluatedContent, err := evaluator.EvalPkl(dr.Fs, dr.Context, pklPath,
-		fmt.Sprintf("extends \"package://schema.kdeps.com/core@%s#/HTTP.pkl\"\n\n", schema.SchemaVersion(dr.Context)), dr.Logger)
-	if err != nil {
-		return fmt.Errorf("failed to evaluate PKL: %w", err)
+	response := client.Response
+	if response == nil {
+		response = &pklHTTP.ResponseBlock{}
 	}
-
-	return afero.WriteFile(dr.Fs, pklPath, []byte(evaluatedContent), 0o644)
-}
-
-func encodeResponseHeaders(response *pklHTTP.ResponseBlock) string {
-	if response == nil || response.Headers == nil {
-		return "    headers {[\"\"] = \"\"}\n"
-	}
-	var builder strings.Builder
-	builder.WriteString("    headers {\n")
-	for k, v := range *response.Headers {
-		builder.WriteString(fmt.Sprintf("      [\"%s\"] = #\"\"\"\n%s\n\"\"\"#\n", k, utils.EncodeValue(v)))
+	if response.Body != nil {
+		if _, err := dr.WriteResponseBodyToFile(resourceID, response.Body); err != nil {
+			return fmt.Errorf("failed to write HTTP response body to file: %w", err)
+		}
 	}
-	builder.WriteString("    }\n")
-	return builder.String()
-}
 
-func encodeResponseBody(response *pklHTTP.ResponseBlock, dr *DependencyResolver, resourceID string) string {
-	if response == nil || response.Body == nil {
-		return "    body=\"\"\n"
-	}
-	if _, err := dr.WriteResponseBodyToFile(resourceID, response.Body); err != nil {
-		dr.Logger.Fatalf("unable to write HTTP response body to file for resource %s", resourceID)
-	}
-	return fmt.Sprintf("    body = #\"\"\"\n%s\n\"\"\"#\n", utils.EncodeValue(*response.Body))
+	return dr.recordResult("client", "HTTP.pkl", resourceID,
+		stringField("method", client.Method),
+		textField("url", client.Url),
+		intField("timeoutDuration", client.TimeoutDuration, 60),
+		intField("timestamp", &timestamp, 0),
+		listField("data", client.Data),
+		mapField("headers", client.Headers),
+		mapField("params", client.Params),
+		objectField("response",
+			mapField("headers", response.Headers),
+			textPtrField("body", response.Body),
+		),
+		stringField("file", filePath),
+	)
 }
 
-func (dr *DependencyResolver) DoRequest(client *pklHTTP.ResourceHTTPClient) error {
+// DoRequest sends the HTTP request of the client block, stores the response on the block and
+// returns the response status code.
+func (dr *DependencyResolver) DoRequest(ctx context.Context, client *pklHTTP.ResourceHTTPClient) (int, error) {
 	timeout := 30
 	if client.TimeoutDuration != nil {
 		timeout = *client.TimeoutDuration
@@ -189,66 +114,86 @@ func (dr *DependencyResolver) DoRequest(client *pklHTTP.ResourceHTTPClient) erro
 	}
 
 	if client.Method == "" {
-		return errors.New("HTTP method required")
+		return 0, errors.New("HTTP method required")
 	}
 
+	// The secret references and the params are applied to a copy of the URL, so that the block
+	// keeps the references and a retry does not add the params again.
+	requestURL, err := dr.Secrets.Expand(client.Url)
+	if err != nil {
+		return 0, fmt.Errorf("url: %w", err)
+	}
 	if client.Params != nil {
-		parsedURL, err := url.Parse(client.Url)
+		parsedURL, err := url.Parse(requestURL)
 		if err != nil {
-			return fmt.Errorf("invalid URL: %w", err)
+			return 0, fmt.Errorf("invalid URL: %w", err)
 		}
 		query := parsedURL.Query()
 		for k, v := range *client.Params {
-			query.Add(k, v)
+			value, err := dr.Secrets.Expand(v)
+			if err != nil {
+				return 0, fmt.Errorf("param %s: %w", k, err)
+			}
+			query.Add(k, value)
 		}
 		parsedURL.RawQuery = query.Encode()
-		client.Url = parsedURL.String()
+		requestURL = parsedURL.String()
 	}
 
 	var reqBody io.Reader
 	if isMethodWithBody(client.Method) {
 		if client.Data == nil {
-			return fmt.Errorf("%s requires data body", client.Method)
+			return 0, fmt.Errorf("%s requires data body", client.Method)
 		}
-		reqBody = bytes.NewBufferString(strings.Join(*client.Data, ""))
+		data, err := dr.Secrets.Expand(strings.Join(*client.Data, ""))
+		if err != nil {
+			return 0, fmt.Errorf("data: %w", err)
+		}
+		reqBody = bytes.NewBufferString(data)
 	}
 
-	req, err := http.NewRequestWithContext(dr.Context, client.Method, client.Url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, client.Method, requestURL, reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	// Propagate the trace of the step; trace headers set by the resource take precedence.
+	telemetry.Inject(ctx, req.Header)
 	if client.Headers != nil {
 		for k, v := range *client.Headers {
-			req.Header.Set(k, v)
+			value, err := dr.Secrets.Expand(v)
+			if err != nil {
+				return 0, fmt.Errorf("header %s: %w", k, err)
+			}
+			req.Header.Set(k, value)
 		}
 	}
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return 0, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return 0, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	if client.Response == nil {
 		client.Response = &pklHTTP.ResponseBlock{}
 	}
-	client.Response.Body = &[]string{string(body)}[0]
+	client.Response.Body = &[]string{dr.Secrets.Redact(string(body))}[0]
 
 	headers := make(map[string]string)
 	for k, v := range resp.Header {
-		headers[k] = v[0]
+		headers[k] = dr.Secrets.Redact(v[0])
 	}
 	client.Response.Headers = &headers
 	ts := uint32(time32.Epoch())
 	client.Timestamp = &ts
 
-	return nil
+	return resp.StatusCode, nil
 }
 
 func isMethodWithBody(method string) bool {
@@ -3,147 +3,89 @@ package resolver
 import (
 	"context"
 	"fmt"
-	"path/filepath"
-	"strings"
 
-	"github.com/alexellis/go-execute/v2"
-	"github.com/kdeps/kdeps/pkg/evaluator"
-	"github.com/kdeps/kdeps/pkg/schema"
-	"github.com/kdeps/kdeps/pkg/utils"
 	pklPython "github.com/kdeps/schema/gen/python"
 	"github.com/spf13/afero"
 	"github.com/zerjioang/time32"
 )
 
-func (dr *DependencyResolver) HandlePython(actionID string, pythonBlock *pklPython.ResourcePython) error {
-	// Synchronously decode the python block.
-	if err := dr.decodePythonBlock(pythonBlock); err != nil {
-		dr.Logger.Error("failed to decode python block", "actionID", actionID, "error", err)
-		return err
-	}
-
-	// Process the python block asynchronously in a goroutine.
-	go func(aID string, block *pklPython.ResourcePython) {
-		if err := dr.processPythonBlock(aID, block); err != nil {
-			// Log the error; additional error handling can be added here if needed.
-			dr.Logger.Error("failed to process python block", "actionID", aID, "error", err)
+func (dr *DependencyResolver) HandlePython(ctx context.Context, actionID string, pythonBlock *pklPython.ResourcePython, opts *runOptions) error {
+	// Process the block in the background; its completion is signaled through the step future.
+	dr.runStep(ctx, actionID, "python", func(ctx context.Context) error {
+		if err := dr.processPythonBlock(ctx, actionID, pythonBlock, opts); err != nil {
+			dr.Logger.Error("failed to process python block", "actionID", actionID, "error", err)
+			return err
 		}
-	}(actionID, pythonBlock)
+		return nil
+	})
 
 	// Return immediately while the python block is processed in the background.
 	return nil
 }
 
-func (dr *DependencyResolver) decodePythonBlock(pythonBlock *pklPython.ResourcePython) error {
-	// Decode Script
-	decodedScript, err := utils.DecodeBase64IfNeeded(pythonBlock.Script)
+func (dr *DependencyResolver) processPythonBlock(ctx context.Context, actionID string, pythonBlock *pklPython.ResourcePython, opts *runOptions) error {
+	pythonEnv, err := dr.pythonEnvironment(pythonBlock, opts.Python)
 	if err != nil {
-		return fmt.Errorf("failed to decode script: %w", err)
-	}
-	pythonBlock.Script = decodedScript
-
-	// Decode Stderr
-	if pythonBlock.Stderr != nil {
-		decodedStderr, err := utils.DecodeBase64IfNeeded(*pythonBlock.Stderr)
-		if err != nil {
-			return fmt.Errorf("failed to decode stderr: %w", err)
-		}
-		pythonBlock.Stderr = &decodedStderr
+		return err
 	}
 
-	// Decode Stdout
-	if pythonBlock.Stdout != nil {
-		decodedStdout, err := utils.DecodeBase64IfNeeded(*pythonBlock.Stdout)
-		if err != nil {
-			return fmt.Errorf("failed to decode stdout: %w", err)
-		}
-		pythonBlock.Stdout = &decodedStdout
+	env, envKeys, err := dr.stepEnv(pythonBlock.Env)
+	if err != nil {
+		return err
 	}
 
-	// Decode Env
-	decodedEnv, err := utils.DecodeStringMap(pythonBlock.Env, "env")
+	tmpFile, err := dr.createPythonTempFile(pythonBlock.Script)
 	if err != nil {
-		return fmt.Errorf("failed to decode env: %w", err)
+		return err
 	}
-	pythonBlock.Env = decodedEnv
-
-	return nil
-}
+	defer dr.cleanupTempFile(tmpFile.Name())
 
-func (dr *DependencyResolver) processPythonBlock(actionID string, pythonBlock *pklPython.ResourcePython) error {
-	if dr.AnacondaInstalled && pythonBlock.CondaEnvironment != nil && *pythonBlock.CondaEnvironment != "" {
-		if err := dr.activateCondaEnvironment(*pythonBlock.CondaEnvironment); err != nil {
-			return err
-		}
-		//nolint:errcheck
-		defer dr.deactivateCondaEnvironment()
+	files, err := dr.newPythonIO(opts.Python)
+	if err != nil {
+		return err
 	}
+	defer dr.cleanupPythonIO(files)
 
-	env := dr.formatPythonEnv(pythonBlock.Env)
+	dr.Logger.Info("running python", "script", tmpFile.Name(), "environment", pythonEnv.Name, "env", envKeys)
 
-	tmpFile, err := dr.createPythonTempFile(pythonBlock.Script)
+	stdoutFile, err := dr.createStdoutFile(actionID)
 	if err != nil {
 		return err
 	}
-	defer dr.cleanupTempFile(tmpFile.Name())
 
-	dr.Logger.Info("running python", "script", tmpFile.Name(), "env", env)
-
-	cmd := execute.ExecTask{
-		Command:     "python3",
-		Args:        []string{tmpFile.Name()},
-		Shell:       false,
-		Env:         env,
-		StreamStdio: false,
+	result, err := runCommand(ctx, commandTask{
+		Command: pythonEnv.Interpreter,
+		Args:    []string{tmpFile.Name()},
+		// The step env comes last, so that it can override the variables of the environment.
+		Env:     append(append(pythonEnv.Env, files.env()...), env...),
+		OnLine:  dr.streamOutput(actionID),
+		Stdout:  stdoutFile,
+		Sandbox: opts.Sandbox,
+	})
+	if closeErr := stdoutFile.Close(); err == nil {
+		err = closeErr
 	}
-
-	result, err := cmd.Execute(dr.Context)
 	if err != nil {
 		return fmt.Errorf("execution failed: %w", err)
 	}
+	dr.redactResult(&result)
 
-	pythonBlock.Stdout = &result.Stdout
-	pythonBlock.Stderr = &result.Stderr
-
-	return dr.AppendPythonEntry(actionID, pythonBlock)
-}
-
-func (dr *DependencyResolver) activateCondaEnvironment(envName string) error {
-	execCommand := execute.ExecTask{
-		Command:     "conda",
-		Args:        []string{"activate", "--name", envName},
-		Shell:       false,
-		StreamStdio: false,
+	document, err := dr.readPythonResult(files)
+	if err != nil {
+		return err
 	}
 
-	if _, err := execCommand.Execute(dr.Context); err != nil {
-		return fmt.Errorf("conda activate failed: %w", err)
-	}
-	return nil
-}
+	file := stdoutFile.path()
+	pythonBlock.Stdout = &result.Stdout
+	pythonBlock.Stderr = &result.Stderr
+	pythonBlock.ExitCode = &result.ExitCode
+	pythonBlock.File = &file
 
-func (dr *DependencyResolver) deactivateCondaEnvironment() error {
-	execCommand := execute.ExecTask{
-		Command:     "conda",
-		Args:        []string{"deactivate"},
-		Shell:       false,
-		StreamStdio: false,
+	if err := dr.AppendPythonEntry(actionID, pythonBlock, pythonRun{Environment: pythonEnv.Name, Result: document}); err != nil {
+		return err
 	}
 
-	if _, err := execCommand.Execute(context.Background()); err != nil {
-		return fmt.Errorf("conda deactivate failed: %w", err)
-	}
-	return nil
-}
-
-func (dr *DependencyResolver) formatPythonEnv(env *map[string]string) []string {
-	var formatted []string
-	if env != nil {
-		for k, v := range *env {
-			formatted = append(formatted, fmt.Sprintf("%s=%s", k, v))
-		}
-	}
-	return formatted
+	return dr.checkExitCode(opts.FailOnNonZeroExit, result)
 }
 
 //nolint:ireturn
@@ -170,119 +112,50 @@ func (dr *DependencyResolver) cleanupTempFile(name string) {
 	}
 }
 
-func (dr *DependencyResolver) WritePythonStdoutToFile(resourceID string, stdoutEncoded *string) (string, error) {
-	if stdoutEncoded == nil {
+func (dr *DependencyResolver) WritePythonStdoutToFile(resourceID string, stdout *string) (string, error) {
+	if stdout == nil {
 		return "", nil
 	}
 
-	content, err := utils.DecodeBase64IfNeeded(*stdoutEncoded)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode stdout: %w", err)
-	}
-
-	resourceIDFile := utils.GenerateResourceIDFilename(resourceID, dr.RequestID)
-	outputFilePath := filepath.Join(dr.FilesDir, resourceIDFile)
-
-	if err := afero.WriteFile(dr.Fs, outputFilePath, []byte(content), 0o644); err != nil {
+	outputFilePath := dr.stdoutFile(resourceID)
+	if err := afero.WriteFile(dr.Fs, outputFilePath, []byte(dr.truncateOutput("stdout", *stdout)), 0o644); err != nil {
 		return "", fmt.Errorf("failed to write stdout to file: %w", err)
 	}
 
 	return outputFilePath, nil
 }
 
-//nolint:dupl
-func (dr *DependencyResolver) AppendPythonEntry(resourceID string, newPython *pklPython.ResourcePython) error {
-	pklPath := filepath.Join(dr.ActionDir, "python/"+dr.RequestID+"__python_output.pkl")
-	newTimestamp := uint32(time32.Epoch())
-
-	pklRes, err := pklPython.LoadFromPath(dr.Context, pklPath)
-	if err != nil {
-		return fmt.Errorf("failed to load PKL file: %w", err)
-	}
+// AppendPythonEntry records the output of the python block of a resource, with the environment it
+// ran in and its JSON result, in the result store. The stdout is written to the stdout file of the
+// resource, unless the process already streamed it there.
+func (dr *DependencyResolver) AppendPythonEntry(resourceID string, newPython *pklPython.ResourcePython, run pythonRun) error {
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
 
-	existingResources := *pklRes.GetResources()
+	newTimestamp := int(time32.Epoch())
 
 	var filePath string
 	if newPython.Stdout != nil {
-		filePath, err = dr.WritePythonStdoutToFile(resourceID, newPython.Stdout)
-		if err != nil {
-			return fmt.Errorf("failed to write stdout to file: %w", err)
+		filePath = dr.stdoutFile(resourceID)
+		if newPython.File == nil || *newPython.File != filePath {
+			if _, err := dr.WritePythonStdoutToFile(resourceID, newPython.Stdout); err != nil {
+				return fmt.Errorf("failed to write stdout to file: %w", err)
+			}
 		}
 		newPython.File = &filePath
 	}
 
-	encodedScript := utils.EncodeValue(newPython.Script)
-	encodedEnv := dr.encodePythonEnv(newPython.Env)
-	encodedStderr, encodedStdout := dr.encodePythonOutputs(newPython.Stderr, newPython.Stdout)
-
-	existingResources[resourceID] = &pklPython.ResourcePython{
-		Env:       encodedEnv,
-		Script:    encodedScript,
-		Stderr:    encodedStderr,
-		Stdout:    encodedStdout,
-		File:      &filePath,
-		Timestamp: &newTimestamp,
-	}
-
-	var pklContent strings.Builder
-	pklContent.WriteString(fmt.Sprintf("extends \"package://schema.kdeps.com/core@%s#/Python.pkl\"\n\n", schema.SchemaVersion(dr.Context)))
-	pklContent.WriteString("resources {\n")
-
-	for id, res := range existingResources {
-		pklContent.WriteString(fmt.Sprintf("  [\"%s\"] {\n", id))
-		pklContent.WriteString(fmt.Sprintf("    script = \"%s\"\n", res.Script))
-		pklContent.WriteString(fmt.Sprintf("    timeoutDuration = %d\n", res.TimeoutDuration))
-		pklContent.WriteString(fmt.Sprintf("    timestamp = %d\n", *res.Timestamp))
-
-		pklContent.WriteString("    env ")
-		pklContent.WriteString(utils.EncodePklMap(res.Env))
-
-		pklContent.WriteString(dr.encodePythonStderr(res.Stderr))
-		pklContent.WriteString(dr.encodePythonStdout(res.Stdout))
-		pklContent.WriteString(fmt.Sprintf("    file = \"%s\"\n", *res.File))
-
-		pklContent.WriteString("  }\n")
-	}
-	pklContent.WriteString("}\n")
-
-	if err := afero.WriteFile(dr.Fs, pklPath, []byte(pklContent.String()), 0o644); err != nil {
-		return fmt.Errorf("failed to write PKL file: %w", err)
-	}
-
-	evaluatedContent, err := evaluator.EvalPkl(dr.Fs, dr.Context, pklPath,
-		fmt.Sprintf("extends \"package://schema.kdeps.com/core@%s#/Python.pkl\"", schema.SchemaVersion(dr.Context)), dr.Logger)
-	if err != nil {
-		return fmt.Errorf("failed to evaluate PKL: %w", err)
-	}
-
-	return afero.WriteFile(dr.Fs, pklPath, []byte(evaluatedContent), 0o644)
-}
-
-func (dr *DependencyResolver) encodePythonEnv(env *map[string]string) *map[string]string {
-	if env == nil {
-		return nil
-	}
-	encoded := make(map[string]string)
-	for k, v := range *env {
-		encoded[k] = utils.EncodeValue(v)
-	}
-	return &encoded
-}
-
-func (dr *DependencyResolver) encodePythonOutputs(stderr, stdout *string) (*string, *string) {
-	return utils.EncodeValuePtr(stderr), utils.EncodeValuePtr(stdout)
-}
-
-func (dr *DependencyResolver) encodePythonStderr(stderr *string) string {
-	if stderr == nil {
-		return "    stderr = \"\"\n"
-	}
-	return fmt.Sprintf("    stderr = #\"\"\"\n%s\n\"\"\"#\n", *stderr)
-}
-
-func (dr *DependencyResolver) encodePythonStdout(stdout *string) string {
-	if stdout == nil {
-		return "    stdout = \"\"\n"
+	if err := dr.recordPythonRun(resourceID, run); err != nil {
+		return err
 	}
-	return fmt.Sprintf("    stdout = #\"\"\"\n%s\n\"\"\"#\n", *stdout)
+	return dr.recordResult("python", "Python.pkl", resourceID,
+		textField("script", newPython.Script),
+		intField("timeoutDuration", newPython.TimeoutDuration, 60),
+		intField("timestamp", &newTimestamp, 0),
+		mapField("env", newPython.Env),
+		textPtrField("stderr", newPython.Stderr),
+		stdoutField(newPython.Stdout, filePath),
+		intField("exitCode", newPython.ExitCode, 0),
+		stringField("file", filePath),
+	)
 }
@@ -7,7 +7,6 @@ import (
 	"reflect"
 	"strings"
 
-	"github.com/alexellis/go-execute/v2"
 	"github.com/google/uuid"
 	"github.com/kdeps/kdeps/pkg/evaluator"
 	"github.com/kdeps/kdeps/pkg/logging"
@@ -26,7 +25,7 @@ func (dr *DependencyResolver) CreateResponsePklFile(apiResponseBlock apiserverre
 	}
 
 	sections := dr.buildResponseSections(dr.RequestID, apiResponseBlock)
-	if err := evaluator.CreateAndProcessPklFile(dr.Fs, dr.Context, sections, dr.ResponsePklFile, "APIServerResponse.pkl", dr.Logger, evaluator.EvalPkl, false); err != nil {
+	if err := evaluator.CreateAndProcessPklFile(dr.Fs, dr.Context, sections, dr.ResponsePklFile, "APIServerResponse.pkl", dr.Logger, dr.Evaluator.EvalPkl, false); err != nil {
 		return fmt.Errorf("create/process PKL file: %w", err)
 	}
 
@@ -233,15 +232,10 @@ func (dr *DependencyResolver) EvalPklFormattedResponseFile() (string, error) {
 		return "", fmt.Errorf("ensure target file not exists: %w", err)
 	}
 
-	if err := evaluator.EnsurePklBinaryExists(dr.Context, dr.Logger); err != nil {
-		return "", fmt.Errorf("PKL binary check: %w", err)
-	}
-
-	result, err := dr.executePklEvalCommand()
-	if err != nil {
+	if err := dr.Evaluator.EvalJSON(dr.Fs, dr.Context, dr.ResponsePklFile, dr.ResponseTargetFile, dr.Logger); err != nil {
 		return "", fmt.Errorf("execute PKL eval: %w", err)
 	}
-	return result.Stdout, nil
+	return "", nil
 }
 
 func (dr *DependencyResolver) validatePklFileExtension() error {
@@ -265,28 +259,10 @@ func (dr *DependencyResolver) ensureResponseTargetFileNotExists() error {
 	return nil
 }
 
-func (dr *DependencyResolver) executePklEvalCommand() (execute.ExecResult, error) {
-	cmd := execute.ExecTask{
-		Command:     "pkl",
-		Args:        []string{"eval", "--format", "json", "--output-path", dr.ResponseTargetFile, dr.ResponsePklFile},
-		StreamStdio: false,
-	}
-
-	result, err := cmd.Execute(dr.Context)
-	if err != nil {
-		return execute.ExecResult{}, fmt.Errorf("execute command: %w", err)
-	}
-
-	if result.ExitCode != 0 {
-		return execute.ExecResult{}, fmt.Errorf("command failed with exit code %d: %s", result.ExitCode, result.Stderr)
-	}
-	return result, nil
-}
-
 // HandleAPIErrorResponse creates an error response PKL file.
 func (dr *DependencyResolver) HandleAPIErrorResponse(code int, message string, fatal bool) (bool, error) {
 	if dr.APIServerMode {
-		errorResponse := utils.NewAPIServerResponse(false, nil, code, message)
+		errorResponse := utils.NewAPIServerResponse(false, nil, code, dr.Secrets.Redact(message))
 		if err := dr.CreateResponsePklFile(errorResponse); err != nil {
 			return fatal, fmt.Errorf("create error response: %w", err)
 		}
@@ -5,7 +5,9 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/apple/pkl-go/pkl"
 	"github.com/kdeps/kdeps/pkg/resource"
+	pklResource "github.com/kdeps/schema/gen/resource"
 	"github.com/spf13/afero"
 )
 
@@ -23,10 +25,10 @@ func (dr *DependencyResolver) LoadResourceEntries() error {
 
 		// Check if the file has a .pkl extension
 		if !info.IsDir() && filepath.Ext(path) == ".pkl" {
-			// Handle dynamic and placeholder imports
-			if err := dr.handleFileImports(path); err != nil {
-				dr.Logger.Errorf("error processing imports for file %s: %v", path, err)
-				return err
+			// Add the placeholder outputs of the resource
+			if err := dr.AddPlaceholderImports(path); err != nil {
+				dr.Logger.Errorf("error adding placeholder outputs for file %s: %v", path, err)
+				return fmt.Errorf("failed to add placeholder imports for file %s: %w", path, err)
 			}
 
 			// Add the file to the list of .pkl files
@@ -46,19 +48,28 @@ func (dr *DependencyResolver) LoadResourceEntries() error {
 		}
 	}
 
-	return nil
+	return dr.validateGraph()
 }
 
-// handleFileImports handles dynamic and placeholder imports for a given file.
-func (dr *DependencyResolver) handleFileImports(path string) error {
-	// Prepend dynamic imports
-	if err := dr.PrependDynamicImports(path); err != nil {
-		return fmt.Errorf("failed to prepend dynamic imports for file %s: %w", path, err)
+// validateGraph checks the dependency graph of the loaded resources, including their error
+// handlers, and logs the resources that neither the workflow nor its API routes ever run.
+func (dr *DependencyResolver) validateGraph() error {
+	nodes := make([]resource.GraphNode, 0, len(dr.Resources))
+	for _, res := range dr.Resources {
+		nodes = append(nodes, resource.GraphNode{
+			ActionID: res.ActionID,
+			File:     res.File,
+			Requires: dr.ResourceDependencies[res.ActionID],
+			Handler:  dr.resourceHandlers[res.ActionID],
+		})
 	}
 
-	// Add placeholder imports
-	if err := dr.AddPlaceholderImports(path); err != nil {
-		return fmt.Errorf("failed to add placeholder imports for file %s: %w", path, err)
+	warnings, err := resource.ValidateGraph(nodes, dr.errorHandler(nil), dr.targetActionIDs()...)
+	if err != nil {
+		return fmt.Errorf("invalid resource graph: %w", err)
+	}
+	for _, warning := range warnings {
+		dr.Logger.Warn(warning)
 	}
 
 	return nil
@@ -66,8 +77,27 @@ func (dr *DependencyResolver) handleFileImports(path string) error {
 
 // processPklFile processes an individual .pkl file and updates dependencies.
 func (dr *DependencyResolver) processPklFile(file string) error {
-	// Load the resource file
-	pklRes, err := resource.LoadResource(dr.Context, file, dr.Logger)
+	source, err := dr.resourceSource(file)
+	if err != nil {
+		return err
+	}
+
+	// Load the resource file together with the handler of its onError block
+	var (
+		pklRes  *pklResource.Resource
+		onError *errorPolicy
+	)
+	err = dr.Evaluator.Evaluate(dr.Context, func(ev pkl.Evaluator) error {
+		var err error
+		if pklRes, err = pklResource.Load(dr.Context, ev, source); err != nil {
+			return err
+		}
+		if pklRes.Run == nil {
+			return nil
+		}
+		onError, err = loadRunOption[errorPolicy](dr.Context, ev, source, "onError")
+		return err
+	}, dr.evaluatorOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to load resource from .pkl file %s: %w", file, err)
 	}
@@ -84,6 +114,12 @@ func (dr *DependencyResolver) processPklFile(file string) error {
 	} else {
 		dr.ResourceDependencies[pklRes.ActionID] = nil
 	}
+	if onError != nil && onError.Handler != nil {
+		if dr.resourceHandlers == nil {
+			dr.resourceHandlers = make(map[string]string)
+		}
+		dr.resourceHandlers[pklRes.ActionID] = dr.compiledActionID(*onError.Handler)
+	}
 
 	return nil
 }
@@ -0,0 +1,506 @@
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kdeps/kdeps/pkg/schema"
+	"github.com/kdeps/kdeps/pkg/utils"
+	"github.com/spf13/afero"
+)
+
+// inlineResultSize is the size above which a text value is stored in its own file, which the
+// output module reads on demand, instead of in the output module itself.
+const inlineResultSize = 4096
+
+// Kinds of result fields.
+const (
+	fieldText   = "text"   // text, stored in a file when large
+	fieldString = "string" // short string, always inline
+	fieldInt    = "int"
+	fieldBool   = "bool"
+	fieldMap    = "map"  // mapping of strings
+	fieldList   = "list" // listing of strings
+	fieldObject = "object"
+)
+
+// resultStore holds the outputs of the resource steps of a request, keyed by output alias and
+// actionID. Every recorded output is persisted in a JSON file of its own, next to the files
+// holding the large values, and the output modules are rendered from the store when they are
+// read, so that recording a step neither loads nor rewrites the outputs of the previous steps.
+type resultStore struct {
+	Results map[string]map[string][]resultField
+	// Environments holds the environment each python step ran in, keyed by actionID.
+	Environments map[string]string
+	// Documents holds the JSON result each python step wrote, keyed by actionID.
+	Documents map[string]resultField
+}
+
+// resultField is a property of the output of a resource step.
+type resultField struct {
+	Name   string            `json:"name"`
+	Kind   string            `json:"kind"`
+	Value  string            `json:"value,omitempty"`
+	File   string            `json:"file,omitempty"`
+	Int    int               `json:"int,omitempty"`
+	Bool   bool              `json:"bool,omitempty"`
+	Map    map[string]string `json:"map,omitempty"`
+	List   []string          `json:"list,omitempty"`
+	Fields []resultField     `json:"fields,omitempty"`
+}
+
+// clone returns a copy of the store that can record results without changing s. The recorded
+// fields are never modified, so they are shared.
+func (s *resultStore) clone() *resultStore {
+	if s == nil {
+		return nil
+	}
+	c := &resultStore{Results: make(map[string]map[string][]resultField, len(s.Results))}
+	for alias, results := range s.Results {
+		c.Results[alias] = make(map[string][]resultField, len(results))
+		for id, fields := range results {
+			c.Results[alias][id] = fields
+		}
+	}
+	if s.Environments != nil {
+		c.Environments = make(map[string]string, len(s.Environments))
+		for id, environment := range s.Environments {
+			c.Environments[id] = environment
+		}
+	}
+	if s.Documents != nil {
+		c.Documents = make(map[string]resultField, len(s.Documents))
+		for id, document := range s.Documents {
+			c.Documents[id] = document
+		}
+	}
+	return c
+}
+
+func textField(name, value string) resultField {
+	return resultField{Name: name, Kind: fieldText, Value: value}
+}
+
+func textPtrField(name string, value *string) resultField {
+	if value == nil {
+		return textField(name, "")
+	}
+	return textField(name, *value)
+}
+
+func stringField(name, value string) resultField {
+	return resultField{Name: name, Kind: fieldString, Value: value}
+}
+
+func intField(name string, value *int, defaultValue int) resultField {
+	if value == nil {
+		return resultField{Name: name, Kind: fieldInt, Int: defaultValue}
+	}
+	return resultField{Name: name, Kind: fieldInt, Int: *value}
+}
+
+func boolField(name string, value bool) resultField {
+	return resultField{Name: name, Kind: fieldBool, Bool: value}
+}
+
+func mapField(name string, value *map[string]string) resultField {
+	field := resultField{Name: name, Kind: fieldMap}
+	if value != nil {
+		field.Map = make(map[string]string, len(*value))
+		for k, v := range *value {
+			field.Map[k] = v
+		}
+	}
+	return field
+}
+
+func listField(name string, value *[]string) resultField {
+	field := resultField{Name: name, Kind: fieldList}
+	if value != nil {
+		field.List = append(field.List, *value...)
+	}
+	return field
+}
+
+func objectField(name string, fields ...resultField) resultField {
+	return resultField{Name: name, Kind: fieldObject, Fields: fields}
+}
+
+// resultsDir returns the directory of the result store of the request.
+func (dr *DependencyResolver) resultsDir() string {
+	return filepath.Join(dr.ActionDir, "results", dr.RequestID)
+}
+
+// recordPythonRun stores the environment the python step of a resource ran in and its JSON
+// result, which the python output renders after the next recordResult. The caller must hold
+// outputMu.
+func (dr *DependencyResolver) recordPythonRun(actionID string, run pythonRun) error {
+	var field *resultField
+	if run.Result != nil {
+		document := textField("result", *run.Result)
+		if err := dr.storeText(&document, filepath.Join(dr.resultsDir(), "python", utils.GenerateResourceIDFilename(actionID, ""))); err != nil {
+			return fmt.Errorf("failed to store python result of %s: %w", actionID, err)
+		}
+		field = &document
+	}
+
+	// The store is read by the output modules as they are rendered, under modulesMu.
+	dr.modulesMu.Lock()
+	defer dr.modulesMu.Unlock()
+
+	store := dr.resultStore()
+	if run.Environment != "" {
+		if store.Environments == nil {
+			store.Environments = make(map[string]string)
+		}
+		store.Environments[actionID] = run.Environment
+	}
+
+	if field == nil {
+		delete(store.Documents, actionID)
+		return nil
+	}
+	if store.Documents == nil {
+		store.Documents = make(map[string]resultField)
+	}
+	store.Documents[actionID] = *field
+	return nil
+}
+
+// resultStore returns the result store of the request, creating it if needed.
+func (dr *DependencyResolver) resultStore() *resultStore {
+	if dr.results == nil {
+		dr.results = &resultStore{Results: make(map[string]map[string][]resultField)}
+	}
+	return dr.results
+}
+
+// recordedPythonRun returns the environment the python step of a resource ran in and its JSON
+// result, as recorded by recordPythonRun.
+func (dr *DependencyResolver) recordedPythonRun(actionID string) (pythonRun, error) {
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
+
+	var run pythonRun
+	if dr.results == nil {
+		return run, nil
+	}
+	run.Environment = dr.results.Environments[actionID]
+
+	document, ok := dr.results.Documents[actionID]
+	if !ok {
+		return run, nil
+	}
+	if document.File != "" {
+		content, err := afero.ReadFile(dr.Fs, document.File)
+		if err != nil {
+			return run, fmt.Errorf("failed to read python result of %s: %w", actionID, err)
+		}
+		result := string(content)
+		run.Result = &result
+		return run, nil
+	}
+	result := document.Value
+	run.Result = &result
+	return run, nil
+}
+
+// recordResult stores the output of a resource step under the output with the given alias, in a
+// JSON file of its own, and marks the output module as stale, so that it is rendered from the
+// store the next time it is read. schemaFile is the schema module the output module extends. The
+// caller must hold outputMu.
+func (dr *DependencyResolver) recordResult(alias, schemaFile, actionID string, fields ...resultField) error {
+	prefix := filepath.Join(dr.resultsDir(), alias, utils.GenerateResourceIDFilename(actionID, ""))
+	for i := range fields {
+		if err := dr.storeText(&fields[i], prefix); err != nil {
+			return fmt.Errorf("failed to store %s output of %s: %w", alias, actionID, err)
+		}
+	}
+
+	content, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s output of %s: %w", alias, actionID, err)
+	}
+	if err := dr.Fs.MkdirAll(filepath.Dir(prefix), 0o755); err != nil {
+		return fmt.Errorf("failed to create results directory: %w", err)
+	}
+	if err := afero.WriteFile(dr.Fs, prefix+".json", content, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s output of %s: %w", alias, actionID, err)
+	}
+
+	dr.modulesMu.Lock()
+	defer dr.modulesMu.Unlock()
+
+	store := dr.resultStore()
+	if store.Results[alias] == nil {
+		store.Results[alias] = make(map[string][]resultField)
+	}
+	store.Results[alias][actionID] = fields
+
+	if dr.staleOutputs == nil {
+		dr.staleOutputs = make(map[string]string)
+	}
+	dr.staleOutputs[alias] = schemaFile
+	return nil
+}
+
+// storeText truncates the text values of a field to the maxOutputSize of the workflow, and writes
+// the values larger than inlineResultSize to files named after prefix. A field whose value is
+// already read from a file, such as the stdout of a process, is left as it is.
+func (dr *DependencyResolver) storeText(field *resultField, prefix string) error {
+	if field.Kind == fieldObject {
+		for i := range field.Fields {
+			if err := dr.storeText(&field.Fields[i], prefix+"."+field.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if field.Kind != fieldText || field.File != "" {
+		return nil
+	}
+
+	content := dr.truncateOutput(field.Name, field.Value)
+	if len(content) <= inlineResultSize {
+		field.Value = content
+		return nil
+	}
+
+	file := prefix + "." + field.Name
+	if err := dr.Fs.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", file, err)
+	}
+	if err := afero.WriteFile(dr.Fs, file, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	field.Value, field.File = "", file
+	return nil
+}
+
+// maxOutputSize returns the maximum size of a stored text value in bytes, or 0 when unlimited.
+func (dr *DependencyResolver) maxOutputSize() int {
+	return dr.settings().MaxOutputSize
+}
+
+// truncateOutput returns the text output with the given name truncated to maxOutputSize.
+func (dr *DependencyResolver) truncateOutput(name, content string) string {
+	if maxSize := dr.maxOutputSize(); maxSize > 0 && len(content) > maxSize {
+		dr.Logger.Warn("output exceeds the maximum size, truncating", "field", name, "size", len(content), "maxSize", maxSize)
+		return content[:maxSize]
+	}
+	return content
+}
+
+// stdoutFile returns the file the standard output of the exec or python step of a resource is
+// written to, which its output refers to as its file.
+func (dr *DependencyResolver) stdoutFile(resourceID string) string {
+	return filepath.Join(dr.FilesDir, utils.GenerateResourceIDFilename(resourceID, dr.RequestID))
+}
+
+// stdoutField returns the stdout field of the output of a process whose standard output is also
+// in file. A value larger than inlineResultSize is read from file instead of being stored twice.
+func stdoutField(stdout *string, file string) resultField {
+	field := textPtrField("stdout", stdout)
+	if file != "" && len(field.Value) > inlineResultSize {
+		field.Value, field.File = "", file
+	}
+	return field
+}
+
+// outputFile streams the standard output of a process to its stdout file as it is written. The
+// secret values are hidden line by line, and the output is truncated to maxOutputSize, so that
+// the file holds the stored output.
+type outputFile struct {
+	file   afero.File
+	redact func(string) string
+	limit  int
+	size   int
+	buf    []byte
+	err    error
+}
+
+// createStdoutFile creates the stdout file of a resource and returns the outputFile that streams
+// the standard output of its process to it. The outputFile must be closed once the process exited.
+func (dr *DependencyResolver) createStdoutFile(resourceID string) (*outputFile, error) {
+	if err := dr.Fs.MkdirAll(dr.FilesDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create files directory: %w", err)
+	}
+	file, err := dr.Fs.Create(dr.stdoutFile(resourceID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout file: %w", err)
+	}
+	return &outputFile{file: file, redact: dr.Secrets.Redact, limit: dr.maxOutputSize()}, nil
+}
+
+// Write never fails, so that the process is not interrupted. The first write error is returned by
+// Close.
+func (f *outputFile) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			if len(f.buf) >= maxLineSize {
+				f.flush(len(f.buf))
+			}
+			return len(p), nil
+		}
+		f.flush(i + 1)
+	}
+}
+
+// flush writes the first n buffered bytes to the file.
+func (f *outputFile) flush(n int) {
+	line := f.redact(string(f.buf[:n]))
+	f.buf = f.buf[n:]
+	if f.limit > 0 {
+		if f.size >= f.limit {
+			return
+		}
+		if len(line) > f.limit-f.size {
+			line = line[:f.limit-f.size]
+		}
+	}
+
+	written, err := io.WriteString(f.file, line)
+	f.size += written
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+}
+
+// Close writes the incomplete last line, if any, and closes the file.
+func (f *outputFile) Close() error {
+	f.flush(len(f.buf))
+	if err := f.file.Close(); err != nil && f.err == nil {
+		f.err = err
+	}
+	if f.err != nil {
+		return fmt.Errorf("failed to write stdout file: %w", f.err)
+	}
+	return nil
+}
+
+// path returns the path of the stdout file.
+func (f *outputFile) path() string {
+	return f.file.Name()
+}
+
+// renderResults returns the output module with the given alias. Values stored in files are read
+// by Pkl when they are accessed.
+func (dr *DependencyResolver) renderResults(alias, schemaFile string) string {
+	results := dr.results.Results[alias]
+	ids := make([]string, 0, len(results))
+	for id := range results {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var pklContent strings.Builder
+	pklContent.WriteString(fmt.Sprintf("extends \"%s\"\n\n", schema.VendoredModule(schemaFile)))
+	if alias == "python" {
+		pklContent.WriteString("import \"pkl:json\"\n\n")
+	}
+	pklContent.WriteString("resources {\n")
+	for _, id := range ids {
+		pklContent.WriteString(fmt.Sprintf("  [%s] {\n", utils.PklString(id)))
+		for _, field := range results[id] {
+			renderField(&pklContent, field, "    ")
+		}
+		pklContent.WriteString("  }\n")
+	}
+	pklContent.WriteString("}\n")
+
+	if alias == "python" {
+		renderPythonRuns(&pklContent, dr.results)
+	}
+
+	return pklContent.String()
+}
+
+// renderPythonRuns adds the environments the python steps ran in, and their JSON results, to the
+// python output.
+func renderPythonRuns(b *strings.Builder, store *resultStore) {
+	b.WriteString("\n/// The environment each python step ran in, keyed by actionID.\n")
+	b.WriteString("environments: Mapping<String, String> = new {\n")
+	for _, id := range sortedKeys(store.Environments) {
+		b.WriteString(fmt.Sprintf("  [%s] = %s\n", utils.PklString(id), utils.PklString(store.Environments[id])))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("/// The JSON result each python step wrote, keyed by actionID.\n")
+	b.WriteString("documents: Mapping<String, String> = new {\n")
+	for _, id := range sortedKeys(store.Documents) {
+		document := store.Documents[id]
+		document.Name = fmt.Sprintf("[%s]", utils.PklString(id))
+		renderField(b, document, "  ")
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("/// Retrieves the environment the python step of the resource [actionID] ran in: its conda\n")
+	b.WriteString("/// environment, its virtualenv, or \"system\".\n")
+	b.WriteString("function environment(actionID: String): String = environments.getOrNull(actionID) ?? \"\"\n\n")
+	b.WriteString("/// Retrieves the JSON document the python script of the resource [actionID] wrote to its result\n")
+	b.WriteString("/// file, or an empty string.\n")
+	b.WriteString("function result(actionID: String): String = documents.getOrNull(actionID) ?? \"\"\n\n")
+	b.WriteString("/// Retrieves the parsed result of the python script of the resource [actionID], or null.\n")
+	b.WriteString("function resultData(actionID: String): Any =\n")
+	b.WriteString("  if (result(actionID).isEmpty) null else (new json.Parser { useMapping = true }).parse(result(actionID))\n")
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func renderField(b *strings.Builder, field resultField, indent string) {
+	switch field.Kind {
+	case fieldText:
+		if field.File != "" {
+			fileURL := url.URL{Scheme: "file", Path: field.File}
+			b.WriteString(fmt.Sprintf("%s%s = read(%s).text\n", indent, field.Name, utils.PklString(fileURL.String())))
+			return
+		}
+		b.WriteString(fmt.Sprintf("%s%s = %s\n", indent, field.Name, utils.PklString(field.Value)))
+	case fieldString:
+		b.WriteString(fmt.Sprintf("%s%s = %s\n", indent, field.Name, utils.PklString(field.Value)))
+	case fieldInt:
+		b.WriteString(fmt.Sprintf("%s%s = %d\n", indent, field.Name, field.Int))
+	case fieldBool:
+		b.WriteString(fmt.Sprintf("%s%s = %t\n", indent, field.Name, field.Bool))
+	case fieldMap:
+		keys := make([]string, 0, len(field.Map))
+		for k := range field.Map {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.WriteString(fmt.Sprintf("%s%s {\n", indent, field.Name))
+		for _, k := range keys {
+			b.WriteString(fmt.Sprintf("%s  [%s] = %s\n", indent, utils.PklString(k), utils.PklString(field.Map[k])))
+		}
+		b.WriteString(indent + "}\n")
+	case fieldList:
+		b.WriteString(fmt.Sprintf("%s%s {\n", indent, field.Name))
+		for _, v := range field.List {
+			b.WriteString(fmt.Sprintf("%s  %s\n", indent, utils.PklString(v)))
+		}
+		b.WriteString(indent + "}\n")
+	case fieldObject:
+		b.WriteString(fmt.Sprintf("%s%s {\n", indent, field.Name))
+		for _, f := range field.Fields {
+			renderField(b, f, indent+"  ")
+		}
+		b.WriteString(indent + "}\n")
+	}
+}
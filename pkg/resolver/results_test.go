@@ -0,0 +1,159 @@
+package resolver
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/secrets"
+	pklExec "github.com/kdeps/schema/gen/exec"
+	pklHTTP "github.com/kdeps/schema/gen/http"
+	pklPython "github.com/kdeps/schema/gen/python"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordResult(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Inline", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		stdout := "hello \"world\""
+		env := map[string]string{"NAME": "value"}
+
+		require.NoError(t, dr.AppendExecEntry("@agent/first:1.0.0", &pklExec.ResourceExec{Command: "echo", Stdout: &stdout, Env: &env}))
+		require.NoError(t, dr.AppendExecEntry("@agent/second:1.0.0", &pklExec.ResourceExec{Command: "true"}))
+
+		content, err := dr.readOutput("exec")
+		require.NoError(t, err)
+		assert.Contains(t, content, `["@agent/first:1.0.0"] {`)
+		assert.Contains(t, content, `["@agent/second:1.0.0"] {`)
+		assert.Contains(t, content, `stdout = "hello \"world\""`)
+		assert.Contains(t, content, `["NAME"] = "value"`)
+		assert.Contains(t, content, `file = "/files/req_agent_first_1.0.0"`)
+		assert.Less(t, strings.Index(content, "first"), strings.Index(content, "second"))
+
+		stored, err := afero.ReadFile(dr.Fs, "/action/results/req/exec/agent_first_1.0.0.json")
+		require.NoError(t, err)
+		var fields []resultField
+		require.NoError(t, json.Unmarshal(stored, &fields))
+		assert.Contains(t, fields, textField("stdout", stdout))
+		assert.True(t, dr.hasOutput("exec"))
+	})
+
+	t.Run("LargeStdout", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		stdout := strings.Repeat("x", inlineResultSize+1)
+
+		require.NoError(t, dr.AppendExecEntry("@agent/run:1.0.0", &pklExec.ResourceExec{Command: "echo", Stdout: &stdout}))
+
+		file := "/files/req_agent_run_1.0.0"
+		stored, err := afero.ReadFile(dr.Fs, file)
+		require.NoError(t, err)
+		assert.Equal(t, stdout, string(stored))
+
+		content, err := dr.readOutput("exec")
+		require.NoError(t, err)
+		assert.Contains(t, content, `stdout = read("file://`+file+`").text`)
+		assert.NotContains(t, content, stdout)
+	})
+
+	t.Run("LargeValue", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		body := strings.Repeat("x", inlineResultSize+1)
+
+		require.NoError(t, dr.AppendHTTPEntry("@agent/fetch:1.0.0", &pklHTTP.ResourceHTTPClient{
+			Method:   "GET",
+			Url:      "https://example.com",
+			Response: &pklHTTP.ResponseBlock{Body: &body},
+		}))
+
+		file := filepath.Join("/action/results/req/client", "agent_fetch_1.0.0.response.body")
+		stored, err := afero.ReadFile(dr.Fs, file)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(stored))
+
+		content, err := dr.readOutput("client")
+		require.NoError(t, err)
+		assert.Contains(t, content, `body = read("file://`+file+`").text`)
+		assert.NotContains(t, content, body)
+	})
+
+	t.Run("MaxOutputSize", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.Settings.MaxOutputSize = 5
+		stdout := "truncated output"
+
+		require.NoError(t, dr.AppendExecEntry("@agent/run:1.0.0", &pklExec.ResourceExec{Command: "echo", Stdout: &stdout}))
+
+		content, err := dr.readOutput("exec")
+		require.NoError(t, err)
+		assert.Contains(t, content, `stdout = "trunc"`)
+	})
+}
+
+func TestRecordPythonRun(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Inline", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		result := `{"score": 0.9}`
+
+		require.NoError(t, dr.AppendPythonEntry("@agent/train:1.0.0", &pklPython.ResourcePython{Script: "print(1)"}, pythonRun{Environment: "ml", Result: &result}))
+		run, err := dr.recordedPythonRun("@agent/train:1.0.0")
+		require.NoError(t, err)
+		assert.Equal(t, pythonRun{Environment: "ml", Result: &result}, run)
+
+		content, err := dr.readOutput("python")
+		require.NoError(t, err)
+		assert.Contains(t, content, "import \"pkl:json\"")
+		assert.Contains(t, content, `["@agent/train:1.0.0"] = "ml"`)
+		assert.Contains(t, content, `["@agent/train:1.0.0"] = "{\"score\": 0.9}"`)
+		assert.Contains(t, content, "function result(actionID: String): String")
+		assert.Equal(t, "ml", dr.results.clone().Environments["@agent/train:1.0.0"])
+	})
+
+	t.Run("LargeResult", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		result := `{"text": "` + strings.Repeat("x", inlineResultSize) + `"}`
+
+		require.NoError(t, dr.AppendPythonEntry("@agent/train:1.0.0", &pklPython.ResourcePython{Script: "print(1)"}, pythonRun{Result: &result}))
+		run, err := dr.recordedPythonRun("@agent/train:1.0.0")
+		require.NoError(t, err)
+		require.NotNil(t, run.Result)
+		assert.Equal(t, result, *run.Result)
+
+		content, err := dr.readOutput("python")
+		require.NoError(t, err)
+		assert.Contains(t, content, `["@agent/train:1.0.0"] = read("file:///action/results/req/python/agent_train_1.0.0.result").text`)
+	})
+}
+
+func TestOutputFile(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	dr.Secrets = secrets.New(map[string]string{"API_KEY": "sk-12345"})
+	dr.Settings.MaxOutputSize = 28
+
+	out, err := dr.createStdoutFile("@agent/run:1.0.0")
+	require.NoError(t, err)
+	for _, chunk := range []string{"token sk-1", "2345\nsecond ", "line\nthird line\n"} {
+		n, err := out.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	require.NoError(t, out.Close())
+
+	content, err := afero.ReadFile(dr.Fs, out.path())
+	require.NoError(t, err)
+	assert.Equal(t, "token [REDACTED]\nsecond line", string(content))
+}
@@ -0,0 +1,178 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Conditions accepted in the retryOn list of a retry policy.
+const (
+	retryOnTimeout  = "timeout"
+	retryOnExitCode = "exitCode"
+	retryOnHTTP5xx  = "http5xx"
+	retryOnError    = "error"
+)
+
+// Backoff strategies of a retry policy.
+const (
+	backoffConstant    = "constant"
+	backoffLinear      = "linear"
+	backoffExponential = "exponential"
+)
+
+// retryPolicy is the retry block of a resource run block. Unset fields fall back to the retry
+// settings of the workflow.
+type retryPolicy struct {
+	// Maximum number of attempts, including the first one.
+	MaxAttempts *int `pkl:"maxAttempts"`
+
+	// Backoff strategy between attempts: constant, linear or exponential.
+	Backoff *string `pkl:"backoff"`
+
+	// Delay (in seconds) before the first retry.
+	Delay *int `pkl:"delay"`
+
+	// Upper bound (in seconds) of the delay between attempts.
+	MaxDelay *int `pkl:"maxDelay"`
+
+	// Failures that are retried: timeout, exitCode, http5xx and error.
+	RetryOn *[]string `pkl:"retryOn"`
+}
+
+// retrySettings is a retry policy with its defaults applied.
+type retrySettings struct {
+	maxAttempts int
+	backoff     string
+	delay       time.Duration
+	maxDelay    time.Duration
+	retryOn     map[string]bool
+}
+
+// httpStatusError is returned by an HTTP client step that received a 5xx response.
+type httpStatusError struct {
+	statusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("server responded with status %d", e.statusCode)
+}
+
+// retrySettings merges the resource retry policy with the workflow-wide defaults.
+func (dr *DependencyResolver) retrySettings(policy *retryPolicy) retrySettings {
+	defaults := dr.settings().Retry
+	settings := retrySettings{
+		maxAttempts: defaults.MaxAttempts,
+		backoff:     defaults.Backoff,
+		delay:       time.Duration(defaults.Delay) * time.Second,
+		maxDelay:    time.Duration(defaults.MaxDelay) * time.Second,
+	}
+	retryOn := strings.Join(defaults.RetryOn, ",")
+
+	if policy != nil {
+		if policy.MaxAttempts != nil {
+			settings.maxAttempts = *policy.MaxAttempts
+		}
+		if policy.Backoff != nil {
+			settings.backoff = *policy.Backoff
+		}
+		if policy.Delay != nil {
+			settings.delay = time.Duration(*policy.Delay) * time.Second
+		}
+		if policy.MaxDelay != nil {
+			settings.maxDelay = time.Duration(*policy.MaxDelay) * time.Second
+		}
+		if policy.RetryOn != nil {
+			retryOn = strings.Join(*policy.RetryOn, ",")
+		}
+	}
+
+	if settings.maxAttempts < 1 {
+		settings.maxAttempts = 1
+	}
+
+	// Without explicit conditions, every kind of failure is retried.
+	if retryOn = strings.TrimSpace(retryOn); retryOn == "" {
+		retryOn = strings.Join([]string{retryOnTimeout, retryOnExitCode, retryOnHTTP5xx, retryOnError}, ",")
+	}
+	settings.retryOn = make(map[string]bool)
+	for _, condition := range strings.Split(retryOn, ",") {
+		settings.retryOn[strings.TrimSpace(condition)] = true
+	}
+
+	return settings
+}
+
+// backoffDelay returns the delay before the attempt following the given (1-based) attempt.
+func (s retrySettings) backoffDelay(attempt int) time.Duration {
+	delay := s.delay
+	switch s.backoff {
+	case backoffConstant:
+	case backoffLinear:
+		delay = s.delay * time.Duration(attempt)
+	default:
+		for i := 1; i < attempt && (s.maxDelay <= 0 || delay < s.maxDelay); i++ {
+			delay *= 2
+		}
+	}
+
+	if s.maxDelay > 0 && delay > s.maxDelay {
+		delay = s.maxDelay
+	}
+	return delay
+}
+
+// retries reports whether a step that failed with err should be attempted again.
+func (s retrySettings) retries(err error) bool {
+	var (
+		exitErr   *exitCodeError
+		statusErr *httpStatusError
+		limitErr  *sandboxError
+	)
+
+	switch {
+	case errors.Is(err, errStepTimeout):
+		return s.retryOn[retryOnTimeout]
+	case errors.As(err, &exitErr):
+		return s.retryOn[retryOnExitCode]
+	case errors.As(err, &statusErr):
+		return s.retryOn[retryOnHTTP5xx]
+	case errors.Is(err, context.Canceled), errors.As(err, &limitErr):
+		return false
+	default:
+		return s.retryOn[retryOnError]
+	}
+}
+
+// processResourceStepWithRetry runs processResourceStep until it succeeds, fails with a
+// condition that is not retried or runs out of attempts. The number of attempts is recorded in
+// the status output of the request.
+func (dr *DependencyResolver) processResourceStepWithRetry(ctx context.Context, resourceID, step string, timeoutPtr *int, settings retrySettings, handler func(ctx context.Context) error) error {
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = dr.processResourceStep(ctx, resourceID, step, timeoutPtr, handler)
+		if err == nil || attempt >= settings.maxAttempts || !settings.retries(err) {
+			break
+		}
+
+		delay := settings.backoffDelay(attempt)
+		dr.Logger.Warn("resource step failed, retrying", "actionID", resourceID, "step", step, "attempt", attempt, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s error: %w", step, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	if recordErr := dr.updateStatus(resourceID, func(status *resourceStatus) { status.attempts = attempt }); recordErr != nil {
+		dr.Logger.Error("failed to record resource attempts", "actionID", resourceID, "error", recordErr)
+	}
+
+	return err
+}
@@ -0,0 +1,173 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kdeps/kdeps/pkg/workflow"
+	pklHTTP "github.com/kdeps/schema/gen/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrySettings(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults", func(t *testing.T) {
+		t.Parallel()
+		dr := &DependencyResolver{}
+		settings := dr.retrySettings(nil)
+		assert.Equal(t, 1, settings.maxAttempts)
+		assert.Equal(t, backoffExponential, settings.backoff)
+		assert.True(t, settings.retryOn[retryOnTimeout])
+		assert.True(t, settings.retryOn[retryOnError])
+	})
+
+	t.Run("PolicyOverridesWorkflow", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.Settings.Retry = workflow.RetrySettings{
+			MaxAttempts: 2,
+			Backoff:     backoffLinear,
+			Delay:       1,
+			RetryOn:     []string{retryOnTimeout},
+		}
+		maxAttempts, backoff := 5, backoffConstant
+		settings := dr.retrySettings(&retryPolicy{
+			MaxAttempts: &maxAttempts,
+			Backoff:     &backoff,
+			RetryOn:     &[]string{retryOnHTTP5xx},
+		})
+		assert.Equal(t, 5, settings.maxAttempts)
+		assert.Equal(t, backoffConstant, settings.backoff)
+		assert.Equal(t, time.Second, settings.delay)
+		assert.Equal(t, map[string]bool{retryOnHTTP5xx: true}, settings.retryOn)
+	})
+}
+
+func TestBackoffDelay(t *testing.T) {
+	t.Parallel()
+
+	settings := retrySettings{delay: time.Second, maxDelay: 5 * time.Second}
+
+	settings.backoff = backoffConstant
+	assert.Equal(t, time.Second, settings.backoffDelay(3))
+
+	settings.backoff = backoffLinear
+	assert.Equal(t, 3*time.Second, settings.backoffDelay(3))
+
+	settings.backoff = backoffExponential
+	assert.Equal(t, time.Second, settings.backoffDelay(1))
+	assert.Equal(t, 4*time.Second, settings.backoffDelay(3))
+	assert.Equal(t, 5*time.Second, settings.backoffDelay(10))
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	settings := retrySettings{retryOn: map[string]bool{retryOnTimeout: true, retryOnHTTP5xx: true}}
+	assert.True(t, settings.retries(fmt.Errorf("llm %w after 1s", errStepTimeout)))
+	assert.True(t, settings.retries(fmt.Errorf("client error: %w", &httpStatusError{statusCode: 503})))
+	assert.False(t, settings.retries(&exitCodeError{exitCode: 1}))
+	assert.False(t, settings.retries(errors.New("connection refused")))
+	assert.False(t, settings.retries(context.Canceled))
+}
+
+func TestProcessResourceStepWithRetry(t *testing.T) {
+	t.Parallel()
+
+	// failing returns a step handler that fails the given number of times before succeeding.
+	failing := func(dr *DependencyResolver, failures int, stepErr error) func(ctx context.Context) error {
+		calls := 0
+		return func(ctx context.Context) error {
+			calls++
+			fail := calls <= failures
+			dr.runStep(ctx, "action", "exec", func(context.Context) error {
+				if fail {
+					return stepErr
+				}
+				return nil
+			})
+			return nil
+		}
+	}
+	settings := retrySettings{maxAttempts: 3, retryOn: map[string]bool{retryOnError: true, retryOnHTTP5xx: true}}
+
+	t.Run("SucceedsAfterRetries", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		err := dr.processResourceStepWithRetry(context.Background(), "action", "exec", nil, settings,
+			failing(dr, 2, errors.New("connection refused")))
+		require.NoError(t, err)
+		assert.Equal(t, 3, dr.statuses["action"].attempts)
+
+		content, err := dr.readOutput("status")
+		require.NoError(t, err)
+		assert.Contains(t, content, `["action"] = 3`)
+	})
+
+	t.Run("GivesUp", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		err := dr.processResourceStepWithRetry(context.Background(), "action", "exec", nil, settings,
+			failing(dr, 5, errors.New("connection refused")))
+		require.ErrorContains(t, err, "connection refused")
+		assert.Equal(t, 3, dr.statuses["action"].attempts)
+	})
+
+	t.Run("ConditionNotRetried", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		err := dr.processResourceStepWithRetry(context.Background(), "action", "exec", nil, settings,
+			failing(dr, 5, &exitCodeError{exitCode: 1}))
+		require.Error(t, err)
+		assert.Equal(t, 1, dr.statuses["action"].attempts)
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		err := dr.processResourceStepWithRetry(context.Background(), "action", "exec", nil, settings,
+			failing(dr, 5, &httpStatusError{statusCode: 502}))
+		var statusErr *httpStatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, 3, dr.statuses["action"].attempts)
+	})
+}
+
+func TestDoRequestRetry(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu      sync.Mutex
+		queries []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		queries = append(queries, r.URL.RawQuery)
+	}))
+	defer server.Close()
+
+	dr := newTestResolver(t)
+	client := &pklHTTP.ResourceHTTPClient{
+		Method: "GET",
+		Url:    server.URL,
+		Params: &map[string]string{"q": "kdeps"},
+	}
+	for range 2 {
+		statusCode, err := dr.DoRequest(context.Background(), client)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, statusCode)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"q=kdeps", "q=kdeps"}, queries)
+	assert.Equal(t, server.URL, client.Url)
+}
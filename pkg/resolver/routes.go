@@ -0,0 +1,76 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/apple/pkl-go/pkl"
+)
+
+// LoadRouteTargets reads the targetActionID of every API server route of the workflow, which the
+// vendored schema declares. Like the run options, the property is looked up by name, so a route
+// that does not set one, or a workflow of another schema version, falls back to the
+// targetActionID of the workflow.
+func (dr *DependencyResolver) LoadRouteTargets(ctx context.Context) error {
+	settings := dr.Workflow.GetSettings()
+	if settings == nil || settings.APIServer == nil {
+		return nil
+	}
+
+	source, err := dr.moduleSource(filepath.Join(dr.WorkflowDir, "workflow.pkl"))
+	if err != nil {
+		return fmt.Errorf("failed to read route targets: %w", err)
+	}
+	targets := make([]string, len(settings.APIServer.Routes))
+
+	err = dr.Evaluator.Evaluate(ctx, func(evaluator pkl.Evaluator) error {
+		for i := range targets {
+			var target *string
+			expr := fmt.Sprintf("settings.APIServer.routes[%d].getPropertyOrNull(\"targetActionID\")", i)
+			if err := evaluator.EvaluateExpression(ctx, source, expr, &target); err != nil {
+				dr.Logger.Debug("route option unavailable", "option", "targetActionID", "route", i, "error", err)
+				continue
+			}
+			if target != nil {
+				targets[i] = *target
+			}
+		}
+		return nil
+	}, dr.evaluatorOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to read route targets: %w", err)
+	}
+
+	dr.routeTargets = targets
+	return nil
+}
+
+// RouteTarget returns the target action of the API server route with the given index, or an
+// empty string when the route runs the targetActionID of the workflow.
+func (dr *DependencyResolver) RouteTarget(index int) string {
+	if index < 0 || index >= len(dr.routeTargets) {
+		return ""
+	}
+	return dr.routeTargets[index]
+}
+
+// targetActionID returns the action the request resolves: the target of its route, if any, or the
+// targetActionID of the workflow.
+func (dr *DependencyResolver) targetActionID() string {
+	if dr.TargetActionID != "" {
+		return dr.TargetActionID
+	}
+	return dr.Workflow.GetTargetActionID()
+}
+
+// targetActionIDs returns the targetActionID of the workflow followed by the targets of its routes.
+func (dr *DependencyResolver) targetActionIDs() []string {
+	targets := []string{dr.Workflow.GetTargetActionID()}
+	for _, target := range dr.routeTargets {
+		if target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
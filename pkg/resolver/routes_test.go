@@ -0,0 +1,26 @@
+package resolver
+
+import (
+	"testing"
+
+	pklWf "github.com/kdeps/schema/gen/workflow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteTargets(t *testing.T) {
+	t.Parallel()
+
+	dr := &DependencyResolver{
+		Workflow:     &pklWf.WorkflowImpl{TargetActionID: "chat"},
+		routeTargets: []string{"", "summarize"},
+	}
+
+	assert.Equal(t, "", dr.RouteTarget(0))
+	assert.Equal(t, "summarize", dr.RouteTarget(1))
+	assert.Equal(t, "", dr.RouteTarget(2))
+	assert.Equal(t, []string{"chat", "summarize"}, dr.targetActionIDs())
+
+	assert.Equal(t, "chat", dr.targetActionID())
+	dr.TargetActionID = dr.RouteTarget(1)
+	assert.Equal(t, "summarize", dr.targetActionID())
+}
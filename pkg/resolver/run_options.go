@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apple/pkl-go/pkl"
+	pklRes "github.com/kdeps/schema/gen/resource"
+)
+
+// runOptions holds the run block settings that are read on top of the generated schema types.
+// They are declared by the vendored schema, see schema.WithVendoredSchema. Every option is looked
+// up by name, so resources written against another schema version still load and simply leave
+// it unset.
+type runOptions struct {
+	Retry   *retryPolicy
+	Cache   *cachePolicy
+	ForEach *forEachPolicy
+	OnError *errorPolicy
+	Sandbox *sandboxPolicy
+	Exec    *execOptions
+	Python  *pythonOptions
+
+	// FailOnNonZeroExit overrides the failOnNonZeroExit setting of the workflow for the exec and
+	// python steps.
+	FailOnNonZeroExit *bool
+}
+
+// loadResource loads a resource file together with its run options. The given evaluator options
+// are applied on top of the ones that let the resource import the output modules.
+func (dr *DependencyResolver) loadResource(ctx context.Context, file string, evaluatorOpts ...func(options *pkl.EvaluatorOptions)) (*pklRes.Resource, *runOptions, error) {
+	source, err := dr.resourceSource(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		rsc  *pklRes.Resource
+		opts = &runOptions{}
+	)
+	err = dr.Evaluator.Evaluate(ctx, func(evaluator pkl.Evaluator) error {
+		var err error
+		rsc, err = pklRes.Load(ctx, evaluator, source)
+		if err != nil {
+			return err
+		}
+
+		if rsc.Run == nil {
+			return nil
+		}
+		if opts.Retry, err = loadRunOption[retryPolicy](ctx, evaluator, source, "retry"); err != nil {
+			return err
+		}
+		if opts.Cache, err = loadRunOption[cachePolicy](ctx, evaluator, source, "cache"); err != nil {
+			return err
+		}
+		if opts.ForEach, err = loadRunOption[forEachPolicy](ctx, evaluator, source, "forEach"); err != nil {
+			return err
+		}
+		if opts.OnError, err = loadRunOption[errorPolicy](ctx, evaluator, source, "onError"); err != nil {
+			return err
+		}
+		if opts.Sandbox, err = loadRunOption[sandboxPolicy](ctx, evaluator, source, "sandbox"); err != nil {
+			return err
+		}
+		if opts.Exec, err = loadExecOptions(ctx, evaluator, source); err != nil {
+			return err
+		}
+		if opts.Python, err = loadPythonOptions(ctx, evaluator, source); err != nil {
+			return err
+		}
+		opts.FailOnNonZeroExit, err = loadRunOption[bool](ctx, evaluator, source, "failOnNonZeroExit")
+		return err
+	}, append(dr.evaluatorOptions(), evaluatorOpts...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rsc, opts, nil
+}
+
+// loadRunOption decodes the run block property with the given name, or returns nil when the
+// property is not set.
+func loadRunOption[T any](ctx context.Context, evaluator pkl.Evaluator, source *pkl.ModuleSource, name string) (*T, error) {
+	return loadBlockOption[T](ctx, evaluator, source, "run", name)
+}
+
+// loadBlockOption decodes the property with the given name of the block at path, such as
+// run.exec, or returns nil when the block or the property is not set. A property that is set but
+// fails to evaluate, or does not decode into T, is an error.
+func loadBlockOption[T any](ctx context.Context, evaluator pkl.Evaluator, source *pkl.ModuleSource, path, name string) (*T, error) {
+	var out *T
+	expr := fmt.Sprintf("%s?.getPropertyOrNull(%q)", path, name)
+	if err := evaluator.EvaluateExpression(ctx, source, expr, &out); err != nil {
+		return nil, fmt.Errorf("invalid run option %s.%s: %w", path, name, err)
+	}
+	return out, nil
+}
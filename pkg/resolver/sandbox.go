@@ -0,0 +1,450 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Limits of a sandbox policy, reported by sandboxError.
+const (
+	limitCPUTime        = "cpuTime"
+	limitMaxOutputBytes = "maxOutputBytes"
+	limitWallTime       = "wallTime"
+	limitCommand        = "command"
+	limitNetwork        = "denyNetwork"
+	limitWorkingDir     = "workingDir"
+)
+
+// sandboxPolicy is the sandbox block of a resource run block. It limits the processes started by
+// the exec and python steps of the resource.
+type sandboxPolicy struct {
+	// CPU time (in seconds) the process may use.
+	CPUTime *int `pkl:"cpuTime"`
+
+	// Virtual memory (in megabytes) the process may allocate. Breaking it is not reported as a
+	// sandboxError, see exceeded.
+	Memory *int `pkl:"memory"`
+
+	// Combined size (in bytes) of the stdout and stderr of the process.
+	MaxOutputBytes *int `pkl:"maxOutputBytes"`
+
+	// Time (in seconds) the process may run, independently of the timeoutDuration of the step.
+	WallTime *int `pkl:"wallTime"`
+
+	// Directory the process runs in.
+	WorkingDir *string `pkl:"workingDir"`
+
+	// Commands the exec script may run. When set, any other command is rejected. Like
+	// DeniedCommands, this is an advisory check of the script, see checkScript.
+	AllowedCommands *[]string `pkl:"allowedCommands"`
+
+	// Commands the exec script may not run.
+	DeniedCommands *[]string `pkl:"deniedCommands"`
+
+	// Whether the process runs in its own network namespace, without network access.
+	DenyNetwork *bool `pkl:"denyNetwork"`
+}
+
+// sandboxError is returned when a step process breaks a limit of its sandbox.
+type sandboxError struct {
+	limit   string
+	message string
+}
+
+func (e *sandboxError) Error() string {
+	return e.message
+}
+
+// statusCode returns the HTTP status code the failure is reported with.
+func (e *sandboxError) statusCode() int {
+	switch e.limit {
+	case limitWallTime:
+		return http.StatusGatewayTimeout
+	case limitCommand, limitWorkingDir:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// shellWrappers are the commands that run the command given as their first operand, with the
+// options of each wrapper that take a value.
+var shellWrappers = map[string][]string{
+	"builtin": nil,
+	"command": nil,
+	"env":     {"-u", "--unset", "-C", "--chdir", "-S", "--split-string"},
+	"exec":    {"-a"},
+	"nice":    {"-n", "--adjustment"},
+	"nohup":   nil,
+	"stdbuf":  {"-i", "-o", "-e"},
+	"sudo":    {"-u", "--user", "-g", "--group", "-C", "--close-from", "-D", "--chdir", "-h", "--host", "-p", "--prompt", "-r", "--role", "-t", "--type", "-T", "--command-timeout", "-U", "--other-user"},
+	"time":    {"-f", "--format", "-o", "--output"},
+	"timeout": {"-s", "--signal", "-k", "--kill-after"},
+	"xargs":   {"-a", "--arg-file", "-d", "--delimiter", "-E", "-I", "-L", "--max-lines", "-n", "--max-args", "-P", "--max-procs", "-s", "--max-chars"},
+}
+
+// shells are the commands that run the script given with their -c option.
+var shells = map[string]bool{
+	"ash":  true,
+	"bash": true,
+	"dash": true,
+	"ksh":  true,
+	"sh":   true,
+	"zsh":  true,
+}
+
+// checkScript rejects a shell script that runs a command the policy denies, or any command
+// outside the allowed commands. The scripts run through `bash -c`, `eval` or `env -S` are checked
+// as well. Commands whose name is only known at runtime, such as `$cmd`, are rejected when the
+// policy has allowed commands. The check is advisory, not a security boundary: the programs the
+// commands start themselves, such as with `python3 -c`, `awk 'BEGIN { system(...) }'`, `find
+// -exec` or `source`, are not checked, and neither are the scripts of the python steps.
+func (p *sandboxPolicy) checkScript(script string) error {
+	if p == nil || (p.AllowedCommands == nil && p.DeniedCommands == nil) {
+		return nil
+	}
+
+	file, err := syntax.NewParser().Parse(strings.NewReader(script), "")
+	if err != nil {
+		return &sandboxError{limit: limitCommand, message: fmt.Sprintf("failed to parse command: %v", err)}
+	}
+
+	var checkErr error
+	syntax.Walk(file, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || checkErr != nil {
+			return checkErr == nil
+		}
+		args := make([]commandArg, len(call.Args))
+		for i, word := range call.Args {
+			args[i].value, args[i].static = wordValue(word)
+		}
+		checkErr = p.checkCall(args)
+		return checkErr == nil
+	})
+	return checkErr
+}
+
+// checkArgs rejects a command run with args, without a shell, like checkScript rejects a call of
+// the script.
+func (p *sandboxPolicy) checkArgs(command string, args []string) error {
+	if p == nil || (p.AllowedCommands == nil && p.DeniedCommands == nil) {
+		return nil
+	}
+
+	call := []commandArg{{value: command, static: true}}
+	for _, arg := range args {
+		call = append(call, commandArg{value: arg, static: true})
+	}
+	return p.checkCall(call)
+}
+
+// checkCall checks the commands a call runs and the scripts it passes to a shell.
+func (p *sandboxPolicy) checkCall(args []commandArg) error {
+	names, scripts := commandCall(args)
+	for _, name := range names {
+		if err := p.checkCommand(name); err != nil {
+			return err
+		}
+	}
+	for _, script := range scripts {
+		if err := p.checkScript(script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandArg is a word of a call. The value of a word that is only known at runtime, such as
+// `$cmd`, is not static.
+type commandArg struct {
+	value  string
+	static bool
+}
+
+// commandCall returns the names of the commands a call runs, and the scripts it passes to a
+// shell. The names are those of the command itself and, for wrappers such as `env` or `sudo`, of
+// the command they run. The scripts are those of `bash -c`, `eval` or `env -S`. A name or script
+// only known at runtime is returned as an empty name.
+func commandCall(args []commandArg) (names, scripts []string) {
+	script := func(arg commandArg) {
+		if arg.static {
+			scripts = append(scripts, arg.value)
+		} else {
+			names = append(names, "")
+		}
+	}
+
+	for i := 0; i < len(args); {
+		if !args[i].static {
+			return append(names, ""), scripts
+		}
+		name := filepath.Base(args[i].value)
+		names = append(names, name)
+
+		switch options, wrapper := shellWrappers[name]; {
+		case wrapper:
+			i = wrappedCommand(name, options, args, i+1, script)
+		case shells[name]:
+			commandOption := false
+			for j := i + 1; j < len(args); j++ {
+				arg := args[j]
+				switch {
+				case !arg.static:
+					script(arg)
+				case arg.value == "-o" || arg.value == "-O" || arg.value == "+o" || arg.value == "+O":
+					j++
+					continue
+				case strings.HasPrefix(arg.value, "--"):
+					continue
+				case strings.HasPrefix(arg.value, "-") || strings.HasPrefix(arg.value, "+"):
+					commandOption = commandOption || strings.Contains(arg.value, "c")
+					continue
+				case commandOption:
+					script(arg)
+				}
+				// The first operand is the script of -c, or else the script file the shell runs.
+				break
+			}
+			return names, scripts
+		case name == "eval":
+			var payload []string
+			for _, arg := range args[i+1:] {
+				if !arg.static {
+					return append(names, ""), scripts
+				}
+				payload = append(payload, arg.value)
+			}
+			return names, append(scripts, strings.Join(payload, " "))
+		default:
+			return names, scripts
+		}
+	}
+	return names, scripts
+}
+
+// wrappedCommand returns the index of the command that the wrapper name runs, skipping its
+// options from args[i] on, the values of the options that take one, and variable assignments. The
+// command string of `env -S` is passed to script.
+func wrappedCommand(name string, options []string, args []commandArg, i int, script func(commandArg)) int {
+	for ; i < len(args); i++ {
+		arg := args[i].value
+		switch {
+		case !args[i].static:
+			return i
+		case arg == "--":
+			i++
+		case strings.HasPrefix(arg, "-"):
+			if contains(options, arg) && i+1 < len(args) {
+				i++
+				if name == "env" && (arg == "-S" || arg == "--split-string") {
+					script(args[i])
+				}
+			}
+			continue
+		case strings.Contains(arg, "="):
+			continue
+		}
+		break
+	}
+	// The first operand of timeout is the duration.
+	if name == "timeout" {
+		i++
+	}
+	return i
+}
+
+// wordValue returns the value of a word, with its quotes and escapes removed, and whether it is
+// static, i.e. holds no expansion such as `$cmd`.
+func wordValue(word *syntax.Word) (string, bool) {
+	var value strings.Builder
+	for _, part := range word.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			value.WriteString(unescape(part.Value, ""))
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				return "", false
+			}
+			value.WriteString(part.Value)
+		case *syntax.DblQuoted:
+			for _, quoted := range part.Parts {
+				lit, ok := quoted.(*syntax.Lit)
+				if !ok {
+					return "", false
+				}
+				value.WriteString(unescape(lit.Value, "$`\"\\\n"))
+			}
+		default:
+			return "", false
+		}
+	}
+	return value.String(), true
+}
+
+// unescape removes the backslashes of s that escape the next character: any character when
+// escaped is empty, as in an unquoted word, and otherwise only the characters of escaped.
+func unescape(s, escaped string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (escaped == "" || strings.IndexByte(escaped, s[i+1]) >= 0) {
+			i++
+			if s[i] == '\n' {
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// checkCommand rejects a command the policy denies, or any command outside the allowed commands.
+func (p *sandboxPolicy) checkCommand(name string) error {
+	if p == nil {
+		return nil
+	}
+	if name == "" || name == "." {
+		if p.AllowedCommands != nil {
+			return &sandboxError{limit: limitCommand, message: "command with a dynamic name is not allowed"}
+		}
+		return nil
+	}
+	if p.DeniedCommands != nil && contains(*p.DeniedCommands, name) {
+		return &sandboxError{limit: limitCommand, message: fmt.Sprintf("command %q is denied", name)}
+	}
+	if p.AllowedCommands != nil && !contains(*p.AllowedCommands, name) {
+		return &sandboxError{limit: limitCommand, message: fmt.Sprintf("command %q is not allowed", name)}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// workingDir returns the directory a process asking for dir runs in. When the policy has a working
+// directory, a process that asks for none runs there, and dir must be inside it.
+func (p *sandboxPolicy) workingDir(dir string) (string, error) {
+	if p == nil || p.WorkingDir == nil {
+		return dir, nil
+	}
+	if dir == "" {
+		return *p.WorkingDir, nil
+	}
+	rel, err := filepath.Rel(*p.WorkingDir, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &sandboxError{limit: limitWorkingDir, message: fmt.Sprintf("working directory %s is outside of %s", dir, *p.WorkingDir)}
+	}
+	return dir, nil
+}
+
+// denyNetwork reports whether the process runs without network access.
+func (p *sandboxPolicy) denyNetwork() bool {
+	return p != nil && p.DenyNetwork != nil && *p.DenyNetwork
+}
+
+// wrap returns the command that runs name with args under the CPU time and memory limits of the
+// policy, which the shell sets before replacing itself with the command.
+func (p *sandboxPolicy) wrap(name string, args []string) (string, []string) {
+	if p == nil || (p.CPUTime == nil && p.Memory == nil) {
+		return name, args
+	}
+
+	var limits []string
+	if p.CPUTime != nil {
+		// The process gets SIGXCPU at the soft limit, and SIGKILL a second later if it ignores it.
+		limits = append(limits, fmt.Sprintf("ulimit -S -t %d && ulimit -H -t %d", *p.CPUTime, *p.CPUTime+1))
+	}
+	if p.Memory != nil {
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", *p.Memory*1024))
+	}
+	script := strings.Join(limits, " && ") + ` && exec "$0" "$@"`
+	return shellPath(), append([]string{"-c", script, name}, args...)
+}
+
+// limits returns a context that is canceled with a sandboxError when the process outlives the
+// wall time of the policy or writes more than maxOutputBytes, as counted by the returned count
+// function. The returned stop function releases the context.
+func (p *sandboxPolicy) limits(ctx context.Context) (context.Context, func(n int), context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := func() { cancel(nil) }
+	if p == nil {
+		return ctx, func(int) {}, stop
+	}
+
+	if p.WallTime != nil {
+		limit := time.Duration(*p.WallTime) * time.Second
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, limit, &sandboxError{
+			limit:   limitWallTime,
+			message: fmt.Sprintf("process exceeded the wall time limit of %s", limit),
+		})
+		stop = func() {
+			cancelTimeout()
+			cancel(nil)
+		}
+	}
+
+	if p.MaxOutputBytes == nil {
+		return ctx, func(int) {}, stop
+	}
+
+	var (
+		mu      sync.Mutex
+		written int
+	)
+	count := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		written += n
+		if written > *p.MaxOutputBytes {
+			cancel(&sandboxError{
+				limit:   limitMaxOutputBytes,
+				message: fmt.Sprintf("process exceeded the output limit of %d bytes", *p.MaxOutputBytes),
+			})
+		}
+	}
+	return ctx, count, stop
+}
+
+// exceeded returns the sandboxError of a process that was killed for breaking a limit of the
+// policy, or nil. ctx is the context returned by limits. The memory limit is not reported: an
+// allocation over the limit fails inside the process, which handles the failure itself, so
+// neither its exit status nor a signal tells it apart from other failures.
+func (p *sandboxPolicy) exceeded(ctx context.Context, cpuExceeded bool) error {
+	if p == nil {
+		return nil
+	}
+
+	var limitErr *sandboxError
+	if errors.As(context.Cause(ctx), &limitErr) {
+		return limitErr
+	}
+	if p.CPUTime != nil && cpuExceeded {
+		return &sandboxError{limit: limitCPUTime, message: fmt.Sprintf("process exceeded the CPU time limit of %ds", *p.CPUTime)}
+	}
+	return nil
+}
+
+// countingWriter passes the number of bytes written to it to count.
+type countingWriter struct {
+	count func(n int)
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	w.count(len(p))
+	return len(p), nil
+}
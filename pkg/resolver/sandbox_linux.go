@@ -0,0 +1,64 @@
+//go:build linux
+
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// userNamespaceSettings are the kernel settings that disable the user namespaces of unprivileged
+// processes when set to 0.
+var userNamespaceSettings = []string{"/proc/sys/user/max_user_namespaces", "/proc/sys/kernel/unprivileged_userns_clone"}
+
+// isolateNetwork runs cmd in new user and network namespaces, which leave the process with an
+// unconfigured loopback interface only. The user namespace maps the current user to itself, so
+// the process keeps its file permissions. It fails when the kernel settings disable user
+// namespaces.
+func isolateNetwork(cmd *exec.Cmd) error {
+	if err := checkUserNamespaces(afero.NewOsFs()); err != nil {
+		return err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	return nil
+}
+
+// checkUserNamespaces returns a sandboxError when one of the userNamespaceSettings disables user
+// namespaces. Settings that do not exist are ignored.
+func checkUserNamespaces(fs afero.Fs) error {
+	for _, setting := range userNamespaceSettings {
+		value, err := afero.ReadFile(fs, setting)
+		if err != nil || strings.TrimSpace(string(value)) != "0" {
+			continue
+		}
+		return &sandboxError{
+			limit:   limitNetwork,
+			message: fmt.Sprintf("network isolation is unavailable: user namespaces are disabled by %s", setting),
+		}
+	}
+	return nil
+}
+
+// networkIsolationError returns the error of a process started in the namespaces of
+// isolateNetwork. The failures to create the namespaces, such as in a container whose seccomp
+// profile denies them, are returned as a sandboxError.
+func networkIsolationError(err error) error {
+	if !errors.Is(err, syscall.EPERM) && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOSPC) && !errors.Is(err, syscall.EUSERS) {
+		return err
+	}
+	return &sandboxError{
+		limit:   limitNetwork,
+		message: fmt.Sprintf("network isolation is unavailable: the process is not allowed to create user and network namespaces: %v", err),
+	}
+}
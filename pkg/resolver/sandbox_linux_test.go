@@ -0,0 +1,52 @@
+//go:build linux
+
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsolateNetwork(t *testing.T) {
+	t.Parallel()
+
+	t.Run("UserNamespacesDisabled", func(t *testing.T) {
+		t.Parallel()
+		for _, setting := range userNamespaceSettings {
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, setting, []byte("0\n"), 0o644))
+
+			err := checkUserNamespaces(fs)
+			var limitErr *sandboxError
+			require.ErrorAs(t, err, &limitErr, setting)
+			assert.Equal(t, limitNetwork, limitErr.limit)
+			assert.Equal(t, "network isolation is unavailable: user namespaces are disabled by "+setting, limitErr.Error())
+		}
+	})
+
+	t.Run("UserNamespacesEnabled", func(t *testing.T) {
+		t.Parallel()
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, userNamespaceSettings[0], []byte("15000\n"), 0o644))
+		assert.NoError(t, checkUserNamespaces(fs))
+	})
+
+	t.Run("NamespacesDenied", func(t *testing.T) {
+		t.Parallel()
+		err := networkIsolationError(&exec.Error{Name: "sh", Err: fmt.Errorf("fork/exec: %w", syscall.EPERM)})
+		var limitErr *sandboxError
+		require.ErrorAs(t, err, &limitErr)
+		assert.Equal(t, limitNetwork, limitErr.limit)
+		assert.Contains(t, limitErr.Error(), "not allowed to create user and network namespaces")
+
+		notFound := errors.New("executable file not found")
+		assert.Same(t, notFound, networkIsolationError(notFound))
+	})
+}
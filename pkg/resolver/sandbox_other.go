@@ -0,0 +1,15 @@
+//go:build !linux
+
+package resolver
+
+import "os/exec"
+
+// isolateNetwork fails on platforms without network namespaces.
+func isolateNetwork(_ *exec.Cmd) error {
+	return &sandboxError{limit: limitNetwork, message: "network isolation is not supported on this platform"}
+}
+
+// networkIsolationError returns err, since isolateNetwork never starts a process on this platform.
+func networkIsolationError(err error) error {
+	return err
+}
@@ -0,0 +1,156 @@
+package resolver
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandbox(t *testing.T) {
+	t.Parallel()
+
+	intPtr := func(v int) *int { return &v }
+	run := func(t *testing.T, script string, sandbox *sandboxPolicy) (commandResult, *sandboxError) {
+		t.Helper()
+		result, err := runCommand(context.Background(), commandTask{Command: script, Shell: true, Sandbox: sandbox})
+		if err == nil {
+			return result, nil
+		}
+		limitErr, ok := err.(*sandboxError)
+		require.True(t, ok, "unexpected error: %v", err)
+		return result, limitErr
+	}
+
+	t.Run("CheckScript", func(t *testing.T) {
+		t.Parallel()
+		allowed := []string{"echo", "grep", "env"}
+		denied := []string{"curl"}
+		policy := &sandboxPolicy{AllowedCommands: &allowed, DeniedCommands: &denied}
+
+		require.NoError(t, policy.checkScript("echo hi | grep h && env FOO=bar echo $FOO"))
+		for script, message := range map[string]string{
+			"echo hi; rm -rf /tmp/x":   `command "rm" is not allowed`,
+			"echo $(curl example.com)": `command "curl" is denied`,
+			"env -i /usr/bin/curl x":   `command "curl" is denied`,
+			"$CMD hi":                  "command with a dynamic name is not allowed",
+			"if true; then wget x; fi": `command "true" is not allowed`,
+			"echo 'unterminated":       "failed to parse command",
+		} {
+			err := policy.checkScript(script)
+			require.Error(t, err, script)
+			assert.Contains(t, err.Error(), message, script)
+		}
+
+		deniedOnly := &sandboxPolicy{DeniedCommands: &denied}
+		require.NoError(t, deniedOnly.checkScript("$CMD hi; ls"))
+	})
+
+	t.Run("CheckNestedCommands", func(t *testing.T) {
+		t.Parallel()
+		denied := []string{"rm"}
+		policy := &sandboxPolicy{DeniedCommands: &denied}
+
+		for _, script := range []string{
+			`bash -c "rm -rf /tmp/x"`,
+			`sh -ec 'echo hi; rm -rf /tmp/x'`,
+			`bash -o pipefail -c "rm x"`,
+			`eval "rm -rf /tmp/x"`,
+			`eval rm -rf /tmp/x`,
+			`env rm x`,
+			`env -u HOME FOO=bar rm x`,
+			`env -S "rm -rf /tmp/x"`,
+			`sudo -u root rm x`,
+			`sudo -- rm x`,
+			`nice -n 10 rm x`,
+			`timeout -s KILL 10 rm x`,
+			`xargs -n 1 rm < files`,
+			`"rm" x`,
+			`r\m x`,
+			`bash -c 'sudo -u root bash -c "eval rm x"'`,
+		} {
+			err := policy.checkScript(script)
+			require.Error(t, err, script)
+			assert.Contains(t, err.Error(), `command "rm" is denied`, script)
+		}
+
+		for _, script := range []string{`bash script.sh -c rm`, `echo rm`, `timeout 10 sleep 1`} {
+			require.NoError(t, policy.checkScript(script), script)
+		}
+
+		require.ErrorContains(t, policy.checkArgs("/bin/bash", []string{"-c", "rm -rf /tmp/x"}), `command "rm" is denied`)
+		require.ErrorContains(t, policy.checkArgs("sudo", []string{"-u", "root", "rm", "x"}), `command "rm" is denied`)
+		require.NoError(t, policy.checkArgs("echo", []string{"rm"}))
+
+		allowed := []string{"bash", "echo"}
+		allowlist := &sandboxPolicy{AllowedCommands: &allowed}
+		require.ErrorContains(t, allowlist.checkScript(`bash -c "$SCRIPT"`), "command with a dynamic name is not allowed")
+		require.ErrorContains(t, allowlist.checkScript(`eval "$CMD"`), `command "eval" is not allowed`)
+	})
+
+	t.Run("WorkingDir", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		result, limitErr := run(t, "pwd", &sandboxPolicy{WorkingDir: &dir})
+		require.Nil(t, limitErr)
+		assert.Equal(t, dir+"\n", result.Stdout)
+
+		policy := &sandboxPolicy{WorkingDir: &dir}
+		sub, err := policy.workingDir(filepath.Join(dir, "sub"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "sub"), sub)
+		_, err = policy.workingDir(filepath.Dir(dir))
+		require.ErrorContains(t, err, "is outside of")
+	})
+
+	t.Run("MaxOutputBytes", func(t *testing.T) {
+		t.Parallel()
+		_, limitErr := run(t, "yes", &sandboxPolicy{MaxOutputBytes: intPtr(1024)})
+		require.NotNil(t, limitErr)
+		assert.Equal(t, limitMaxOutputBytes, limitErr.limit)
+	})
+
+	t.Run("WallTime", func(t *testing.T) {
+		t.Parallel()
+		start := time.Now()
+		_, limitErr := run(t, "sleep 30", &sandboxPolicy{WallTime: intPtr(1)})
+		require.NotNil(t, limitErr)
+		assert.Equal(t, limitWallTime, limitErr.limit)
+		assert.Equal(t, http.StatusGatewayTimeout, limitErr.statusCode())
+		assert.Less(t, time.Since(start), 10*time.Second)
+	})
+
+	t.Run("CPUTime", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS != "linux" {
+			t.Skip("CPU time limits are only tested on Linux")
+		}
+		_, limitErr := run(t, "while :; do :; done", &sandboxPolicy{CPUTime: intPtr(1)})
+		require.NotNil(t, limitErr)
+		assert.Equal(t, limitCPUTime, limitErr.limit)
+	})
+
+	t.Run("DenyNetwork", func(t *testing.T) {
+		t.Parallel()
+		deny := true
+		result, limitErr := run(t, "tail -n +3 /proc/net/dev | cut -d: -f1", &sandboxPolicy{DenyNetwork: &deny})
+		if limitErr != nil {
+			require.Equal(t, limitNetwork, limitErr.limit)
+			t.Skipf("network isolation unavailable: %v", limitErr)
+		}
+		assert.Equal(t, "lo", strings.TrimSpace(result.Stdout))
+	})
+
+	t.Run("WithinLimits", func(t *testing.T) {
+		t.Parallel()
+		result, limitErr := run(t, "echo ok", &sandboxPolicy{CPUTime: intPtr(5), Memory: intPtr(512), MaxOutputBytes: intPtr(1024), WallTime: intPtr(5)})
+		require.Nil(t, limitErr)
+		assert.Equal(t, "ok\n", result.Stdout)
+	})
+}
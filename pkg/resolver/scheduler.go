@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// buildExecutionLevels groups the dependency stack into levels. Every resource in a level only
+// requires resources from earlier levels, so the resources of a level can run concurrently.
+// The order of the stack is preserved within each level.
+func buildExecutionLevels(stack []string, dependencies map[string][]string) [][]string {
+	inStack := make(map[string]bool, len(stack))
+	for _, actionID := range stack {
+		inStack[actionID] = true
+	}
+
+	depth := make(map[string]int, len(stack))
+	var levels [][]string
+
+	// The stack is ordered so that dependencies always come before their dependents.
+	for _, actionID := range stack {
+		level := 0
+		for _, dep := range dependencies[actionID] {
+			if !inStack[dep] {
+				continue
+			}
+			if d, ok := depth[dep]; ok && d+1 > level {
+				level = d + 1
+			}
+		}
+		depth[actionID] = level
+
+		for len(levels) <= level {
+			levels = append(levels, nil)
+		}
+		levels[level] = append(levels[level], actionID)
+	}
+
+	return levels
+}
+
+// maxParallelism returns how many resources of a level of the given size may run at once.
+func (dr *DependencyResolver) maxParallelism(levelSize int) int {
+	limit := levelSize
+	if maxParallelism := dr.settings().MaxParallelism; maxParallelism > 0 && maxParallelism < limit {
+		limit = maxParallelism
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// runLevel processes the resources of a level concurrently, bounded by the configured max
+// parallelism. The first error cancels the resources that have not started yet and is returned
+// once the running resources have finished.
+func (dr *DependencyResolver) runLevel(ctx context.Context, level []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, dr.maxParallelism(len(level)))
+	)
+
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for _, nodeActionID := range level {
+		wg.Add(1)
+		go func(actionID string) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			// Recover from panics in this resource so that the other resources can finish.
+			defer func() {
+				if r := recover(); r != nil {
+					buf := make([]byte, 1<<16)
+					stackSize := runtime.Stack(buf, false)
+					dr.Logger.Error("panic recovered while processing resource", "actionID", actionID, "panic", r)
+					dr.Logger.Error("stack trace", "stack", string(buf[:stackSize]))
+					fail(&resourceError{code: 500, message: fmt.Sprintf("panic in resource %s: %v", actionID, r), fatal: true})
+				}
+			}()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := dr.processResource(ctx, actionID); err != nil {
+				fail(err)
+			}
+		}(nodeActionID)
+	}
+
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		// The parent context was canceled before the level could finish.
+		return ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,79 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildExecutionLevels(t *testing.T) {
+	t.Parallel()
+
+	t.Run("IndependentBranches", func(t *testing.T) {
+		t.Parallel()
+		dependencies := map[string][]string{
+			"response": {"llm", "http"},
+			"llm":      {"request"},
+			"http":     {"request"},
+			"request":  nil,
+		}
+		stack := []string{"request", "llm", "http", "response"}
+
+		levels := buildExecutionLevels(stack, dependencies)
+		assert.Equal(t, [][]string{{"request"}, {"llm", "http"}, {"response"}}, levels)
+	})
+
+	t.Run("LinearChain", func(t *testing.T) {
+		t.Parallel()
+		dependencies := map[string][]string{
+			"c": {"b"},
+			"b": {"a"},
+			"a": nil,
+		}
+		stack := []string{"a", "b", "c"}
+
+		levels := buildExecutionLevels(stack, dependencies)
+		assert.Equal(t, [][]string{{"a"}, {"b"}, {"c"}}, levels)
+	})
+
+	t.Run("DependencyOutsideStack", func(t *testing.T) {
+		t.Parallel()
+		dependencies := map[string][]string{
+			"b": {"a", "missing"},
+			"a": nil,
+		}
+		stack := []string{"a", "b"}
+
+		levels := buildExecutionLevels(stack, dependencies)
+		assert.Equal(t, [][]string{{"a"}, {"b"}}, levels)
+	})
+
+	t.Run("EmptyStack", func(t *testing.T) {
+		t.Parallel()
+		assert.Empty(t, buildExecutionLevels(nil, nil))
+	})
+}
+
+func TestMaxParallelism(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Unbounded", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		assert.Equal(t, 3, dr.maxParallelism(3))
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.Settings.MaxParallelism = 2
+		assert.Equal(t, 2, dr.maxParallelism(5))
+		assert.Equal(t, 1, dr.maxParallelism(1))
+	})
+
+	t.Run("NoSettings", func(t *testing.T) {
+		t.Parallel()
+		dr := &DependencyResolver{}
+		assert.Equal(t, 1, dr.maxParallelism(0))
+	})
+}
@@ -0,0 +1,34 @@
+package resolver
+
+import (
+	"fmt"
+	"sort"
+)
+
+// stepEnv returns the KEY=VALUE pairs of the env of an exec or python step, with the secret
+// references of the values, such as ${secret:API_KEY}, replaced by the secrets, together with the
+// sorted keys, which are logged instead of the values.
+func (dr *DependencyResolver) stepEnv(env *map[string]string) ([]string, []string, error) {
+	if env == nil {
+		return nil, nil, nil
+	}
+
+	pairs := make([]string, 0, len(*env))
+	keys := make([]string, 0, len(*env))
+	for key, value := range *env {
+		expanded, err := dr.Secrets.Expand(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("env %s: %w", key, err)
+		}
+		pairs = append(pairs, key+"="+expanded)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return pairs, keys, nil
+}
+
+// redactResult hides the secret values the process of a step wrote to its output.
+func (dr *DependencyResolver) redactResult(result *commandResult) {
+	result.Stdout = dr.Secrets.Redact(result.Stdout)
+	result.Stderr = dr.Secrets.Redact(result.Stderr)
+}
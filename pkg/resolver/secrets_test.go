@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/secrets"
+	pklHTTP "github.com/kdeps/schema/gen/http"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecrets(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	dr.Secrets = secrets.New(map[string]string{"API_KEY": "sk-12345"})
+
+	env, keys, err := dr.stepEnv(&map[string]string{"AUTH": "Bearer ${secret:API_KEY}", "MODE": "test"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"AUTH=Bearer sk-12345", "MODE=test"}, env)
+	assert.Equal(t, []string{"AUTH", "MODE"}, keys)
+
+	_, _, err = dr.stepEnv(&map[string]string{"AUTH": "${secret:OTHER}"})
+	assert.ErrorContains(t, err, "env AUTH: secret OTHER is not available")
+
+	result := commandResult{Stdout: "key is sk-12345", Stderr: "sk-12345"}
+	dr.redactResult(&result)
+	assert.Equal(t, commandResult{Stdout: "key is [REDACTED]", Stderr: "[REDACTED]"}, result)
+
+	dr.streamOutput("@agent/run:1.0.0")("stdout", "using sk-12345")
+	logFile, err := afero.ReadFile(dr.Fs, dr.outputLogFile("@agent/run:1.0.0", "stdout"))
+	require.NoError(t, err)
+	assert.Equal(t, "using [REDACTED]\n", string(logFile))
+	assert.NotContains(t, dr.Logger.GetOutput(), "sk-12345")
+}
+
+func TestDoRequestSecrets(t *testing.T) {
+	t.Parallel()
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = []string{r.URL.Path, r.URL.RawQuery, string(body), r.Header.Get("Authorization")}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	dr := newTestResolver(t)
+	dr.Secrets = secrets.New(map[string]string{"API_KEY": "sk-12345"})
+	client := &pklHTTP.ResourceHTTPClient{
+		Method:  "POST",
+		Url:     server.URL + "/keys/${secret:API_KEY}",
+		Params:  &map[string]string{"key": "${secret:API_KEY}"},
+		Data:    &[]string{`{"key": "${secret:API_KEY}"}`},
+		Headers: &map[string]string{"Authorization": "Bearer ${secret:API_KEY}"},
+	}
+	statusCode, err := dr.DoRequest(context.Background(), client)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, []string{"/keys/sk-12345", "key=sk-12345", `{"key": "sk-12345"}`, "Bearer sk-12345"}, received)
+	assert.Equal(t, server.URL+"/keys/${secret:API_KEY}", client.Url)
+	assert.Equal(t, `{"key": "[REDACTED]"}`, *client.Response.Body)
+
+	for _, client := range []*pklHTTP.ResourceHTTPClient{
+		{Method: "GET", Url: server.URL + "/${secret:OTHER}"},
+		{Method: "GET", Url: server.URL, Params: &map[string]string{"key": "${secret:OTHER}"}},
+		{Method: "POST", Url: server.URL, Data: &[]string{"${secret:OTHER}"}},
+	} {
+		_, err := dr.DoRequest(context.Background(), client)
+		assert.ErrorContains(t, err, "secret OTHER is not available")
+	}
+}
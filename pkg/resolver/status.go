@@ -0,0 +1,67 @@
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resourceStatus is the outcome of a processed resource, as exposed by the status output.
+type resourceStatus struct {
+	attempts int
+	cached   bool
+	// statusCode is the HTTP status code of the client step, recorded for the trace.
+	statusCode int
+}
+
+// updateStatus applies update to the status of the resource and rewrites the status output.
+func (dr *DependencyResolver) updateStatus(resourceID string, update func(status *resourceStatus)) error {
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
+
+	if dr.statuses == nil {
+		dr.statuses = make(map[string]*resourceStatus)
+	}
+	status, ok := dr.statuses[resourceID]
+	if !ok {
+		status = &resourceStatus{attempts: 1}
+		dr.statuses[resourceID] = status
+	}
+	update(status)
+
+	return dr.writeStatusOutput()
+}
+
+// writeStatusOutput writes the status output module, which resources import as `status`.
+// The caller must hold outputMu.
+func (dr *DependencyResolver) writeStatusOutput() error {
+	ids := make([]string, 0, len(dr.statuses))
+	for id := range dr.statuses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var pklContent strings.Builder
+	pklContent.WriteString("/// The number of attempts each resource needed, keyed by actionID.\n")
+	pklContent.WriteString("attemptCounts: Mapping<String, Int> = new {\n")
+	for _, id := range ids {
+		pklContent.WriteString(fmt.Sprintf("  [\"%s\"] = %d\n", id, dr.statuses[id].attempts))
+	}
+	pklContent.WriteString("}\n\n")
+
+	pklContent.WriteString("/// The actionIDs of the resources whose output was reused from the cache.\n")
+	pklContent.WriteString("cachedResources: Listing<String> = new {\n")
+	for _, id := range ids {
+		if dr.statuses[id].cached {
+			pklContent.WriteString(fmt.Sprintf("  \"%s\"\n", id))
+		}
+	}
+	pklContent.WriteString("}\n\n")
+
+	pklContent.WriteString("/// Retrieves the number of attempts the resource [actionID] needed. Defaults to 1.\n")
+	pklContent.WriteString("function attempts(actionID: String): Int = attemptCounts.getOrNull(actionID) ?? 1\n\n")
+	pklContent.WriteString("/// Returns true when the output of the resource [actionID] was reused from the cache.\n")
+	pklContent.WriteString("function cached(actionID: String): Boolean = cachedResources.toList().contains(actionID)\n")
+
+	return dr.writeOutput("status", pklContent.String())
+}
@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// errStepTimeout is returned when a resource step does not complete within its timeout.
+var errStepTimeout = errors.New("timeout exceeded")
+
+// stepFuture signals the completion of a resource step that runs in the background.
+type stepFuture struct {
+	done chan struct{}
+	err  error
+}
+
+func newStepFuture() *stepFuture {
+	return &stepFuture{done: make(chan struct{})}
+}
+
+// complete records the result of the step and releases everyone waiting on it.
+func (f *stepFuture) complete(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// wait blocks until the step completes or ctx is done.
+func (f *stepFuture) wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func stepKey(actionID, step string) string {
+	return step + ":" + actionID
+}
+
+// runStep runs fn with ctx in the background and registers a future for the given actionID and
+// step that completes with the error returned by fn.
+func (dr *DependencyResolver) runStep(ctx context.Context, actionID, step string, fn func(ctx context.Context) error) {
+	future := newStepFuture()
+
+	dr.stepsMu.Lock()
+	if dr.steps == nil {
+		dr.steps = make(map[string]*stepFuture)
+	}
+	dr.steps[stepKey(actionID, step)] = future
+	dr.stepsMu.Unlock()
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				buf := make([]byte, 1<<16)
+				stackSize := runtime.Stack(buf, false)
+				dr.Logger.Error("panic recovered in resource step", "actionID", actionID, "step", step, "panic", r)
+				dr.Logger.Error("stack trace", "stack", string(buf[:stackSize]))
+				future.complete(fmt.Errorf("panic in %s step: %v", step, r))
+			}
+		}()
+
+		future.complete(fn(ctx))
+	}()
+}
+
+// stepFuture returns the future of the latest step started for the given actionID and step.
+func (dr *DependencyResolver) stepFuture(actionID, step string) *stepFuture {
+	dr.stepsMu.Lock()
+	defer dr.stepsMu.Unlock()
+
+	return dr.steps[stepKey(actionID, step)]
+}
+
+// formatDuration converts a time.Duration into a human-friendly string.
+// It prints hours, minutes, and seconds when appropriate.
+func formatDuration(d time.Duration) string {
+	secondsTotal := int(d.Seconds())
+	hours := secondsTotal / 3600
+	minutes := (secondsTotal % 3600) / 60
+	seconds := secondsTotal % 60
+
+	switch {
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm %ds", hours, minutes, seconds)
+	case minutes > 0:
+		return fmt.Sprintf("%dm %ds", minutes, seconds)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
@@ -0,0 +1,116 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunStep(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Completes", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.runStep(context.Background(), "action", "exec", func(context.Context) error { return nil })
+
+		future := dr.stepFuture("action", "exec")
+		require.NotNil(t, future)
+		assert.NoError(t, future.wait(context.Background()))
+	})
+
+	t.Run("PropagatesError", func(t *testing.T) {
+		t.Parallel()
+		stepErr := errors.New("command failed")
+		dr := newTestResolver(t)
+		dr.runStep(context.Background(), "action", "exec", func(context.Context) error { return stepErr })
+
+		err := dr.stepFuture("action", "exec").wait(context.Background())
+		assert.ErrorIs(t, err, stepErr)
+	})
+
+	t.Run("RecoversPanic", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		dr.runStep(context.Background(), "action", "python", func(context.Context) error { panic("boom") })
+
+		err := dr.stepFuture("action", "python").wait(context.Background())
+		assert.ErrorContains(t, err, "boom")
+	})
+
+	t.Run("UnknownStep", func(t *testing.T) {
+		t.Parallel()
+		dr := &DependencyResolver{}
+		assert.Nil(t, dr.stepFuture("action", "exec"))
+	})
+}
+
+func TestProcessResourceStep(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Completes", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		err := dr.processResourceStep(context.Background(), "action", "exec", nil, func(ctx context.Context) error {
+			dr.runStep(ctx, "action", "exec", func(context.Context) error { return nil })
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("TimeoutCancelsStep", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		timeout := 1
+		canceled := make(chan struct{})
+		err := dr.processResourceStep(context.Background(), "action", "llm", &timeout, func(ctx context.Context) error {
+			dr.runStep(ctx, "action", "llm", func(ctx context.Context) error {
+				<-ctx.Done()
+				close(canceled)
+				return ctx.Err()
+			})
+			return nil
+		})
+		require.ErrorIs(t, err, errStepTimeout)
+
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Fatal("step context was not canceled after the timeout")
+		}
+	})
+
+	t.Run("ParentCanceled", func(t *testing.T) {
+		t.Parallel()
+		dr := newTestResolver(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := dr.processResourceStep(ctx, "action", "client", nil, func(ctx context.Context) error {
+			dr.runStep(ctx, "action", "client", func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, errStepTimeout)
+	})
+}
+
+func TestNewStepError(t *testing.T) {
+	t.Parallel()
+
+	timeoutErr := newStepError("exec", "Exec", "action", fmt.Errorf("exec %w after 1s", errStepTimeout), false)
+	assert.Equal(t, 504, timeoutErr.code)
+	assert.Contains(t, timeoutErr.message, "Exec timed out for resource: action")
+
+	failedErr := newStepError("llm", "LLM chat", "action", errors.New("boom"), true)
+	assert.Equal(t, 500, failedErr.code)
+	assert.True(t, failedErr.fatal)
+	assert.Equal(t, "LLM chat failed for resource: action - boom", failedErr.message)
+}
@@ -0,0 +1,142 @@
+package resolver
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// templateRequestID is the request ID under which the workflow is compiled.
+const templateRequestID = "template"
+
+// requestTemplate is the compiled workflow that API requests are created from. It holds the
+// parts of the workflow that are the same for every request: the resource files, the dependency
+// graph and the placeholder outputs.
+type requestTemplate struct {
+	// resources holds the resource entries, with files relative to the workflow directory.
+	resources    []ResourceNodeEntry
+	dependencies map[string][]string
+	// files holds the content of the resource files, keyed by the same relative paths.
+	files map[string]string
+	// outputs holds the placeholder output modules, keyed by import alias.
+	outputs map[string]string
+	// results holds the placeholder results the output modules are rendered from.
+	results *resultStore
+}
+
+// CompileRequestTemplate prepares the workflow once so that API requests can reuse it. The
+// resources are discovered and evaluated, the placeholder outputs are generated
+// and the dependency graph is built in a template directory, and every request created with
+// NewRequestResolver afterwards only writes its own copies of the resulting files.
+func (dr *DependencyResolver) CompileRequestTemplate() error {
+	actionDir := filepath.Join(dr.ActionDir, templateRequestID)
+	if err := dr.Fs.RemoveAll(actionDir); err != nil {
+		return fmt.Errorf("failed to remove old template directory: %w", err)
+	}
+
+	tmpl, err := dr.newChildResolver(dr.Context, actionDir, templateRequestID, dr.Logger)
+	if err != nil {
+		return err
+	}
+
+	if err := tmpl.PrepareWorkflowDir(); err != nil {
+		return fmt.Errorf("failed to prepare template workflow directory: %w", err)
+	}
+
+	if err := tmpl.PrepareImportFiles(); err != nil {
+		return fmt.Errorf("failed to prepare template import files: %w", err)
+	}
+
+	// Resources are evaluated while they are compiled, so they need a request to import.
+	sections := []string{
+		`path = "/"`,
+		`IP = ""`,
+		fmt.Sprintf(`ID = "%s"`, templateRequestID),
+		`method = "GET"`,
+		"headers {\n}",
+		`data = ""`,
+		"params {\n}",
+		"files {\n}",
+	}
+	if err := tmpl.CreateRequestModule(sections); err != nil {
+		return fmt.Errorf("failed to create template request: %w", err)
+	}
+
+	if err := tmpl.LoadResourceEntries(); err != nil {
+		return fmt.Errorf("failed to compile workflow resources: %w", err)
+	}
+
+	compiled := &requestTemplate{
+		dependencies: tmpl.ResourceDependencies,
+		files:        make(map[string]string),
+		outputs:      make(map[string]string),
+	}
+
+	for _, res := range tmpl.Resources {
+		relPath, err := filepath.Rel(tmpl.WorkflowDir, res.File)
+		if err != nil {
+			return fmt.Errorf("failed to resolve resource file %s: %w", res.File, err)
+		}
+		compiled.resources = append(compiled.resources, ResourceNodeEntry{ActionID: res.ActionID, File: relPath})
+
+		if _, ok := compiled.files[relPath]; ok {
+			continue
+		}
+		content, err := afero.ReadFile(tmpl.Fs, res.File)
+		if err != nil {
+			return fmt.Errorf("failed to read compiled resource %s: %w", res.File, err)
+		}
+		compiled.files[relPath] = string(content)
+	}
+
+	for _, alias := range outputAliases {
+		if alias == "request" || !tmpl.hasOutput(alias) {
+			continue
+		}
+		content, err := tmpl.readOutput(alias)
+		if err != nil {
+			return fmt.Errorf("failed to read placeholder output: %w", err)
+		}
+		compiled.outputs[alias] = content
+	}
+	compiled.results = tmpl.results
+
+	dr.template = compiled
+	dr.Logger.Debug("workflow compiled", "resources", len(compiled.resources))
+
+	return nil
+}
+
+// apply creates the resources and placeholder outputs of the request from the template.
+func (t *requestTemplate) apply(dr *DependencyResolver) error {
+	for alias, content := range t.outputs {
+		if err := dr.writeOutput(alias, content); err != nil {
+			return err
+		}
+	}
+	dr.results = t.results.clone()
+
+	for relPath, content := range t.files {
+		file := filepath.Join(dr.WorkflowDir, relPath)
+		if err := dr.Fs.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for resource %s: %w", relPath, err)
+		}
+		if err := afero.WriteFile(dr.Fs, file, []byte(content), 0o644); err != nil {
+			return fmt.Errorf("failed to write resource %s: %w", relPath, err)
+		}
+	}
+
+	for _, res := range t.resources {
+		dr.Resources = append(dr.Resources, ResourceNodeEntry{
+			ActionID: res.ActionID,
+			File:     filepath.Join(dr.WorkflowDir, res.File),
+		})
+	}
+	for actionID, requires := range t.dependencies {
+		dr.ResourceDependencies[actionID] = requires
+	}
+	dr.compiled = true
+
+	return nil
+}
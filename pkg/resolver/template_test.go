@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/logging"
+	pklExec "github.com/kdeps/schema/gen/exec"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRequestResolverFromTemplate(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	logger := logging.NewTestLogger()
+	base := &DependencyResolver{
+		Fs:        fs,
+		Logger:    logger,
+		AgentDir:  "/agent",
+		ActionDir: "/action",
+		FilesDir:  "/action/files",
+	}
+
+	resource := "amends \"Resource.pkl\"\n" +
+		"import \"kdeps:exec\" as exec\n" +
+		"import \"kdeps:request\" as request\n"
+	base.template = &requestTemplate{
+		resources:    []ResourceNodeEntry{{ActionID: "fetch", File: "resources/fetch.pkl"}},
+		dependencies: map[string][]string{"fetch": {"input"}},
+		files:        map[string]string{"resources/fetch.pkl": resource},
+		outputs:      map[string]string{"exec": "resources {\n}\n"},
+	}
+
+	dr, err := NewRequestResolver(base, context.Background(), "req1", logger)
+	require.NoError(t, err)
+	assert.True(t, dr.Compiled())
+	assert.Equal(t, base.FilesDir, dr.FilesDir)
+
+	resourceFile := filepath.Join("/action/requests/req1/workflow", "resources/fetch.pkl")
+	assert.Equal(t, []ResourceNodeEntry{{ActionID: "fetch", File: resourceFile}}, dr.Resources)
+	assert.Equal(t, map[string][]string{"fetch": {"input"}}, dr.ResourceDependencies)
+
+	content, err := afero.ReadFile(fs, resourceFile)
+	require.NoError(t, err)
+	assert.Equal(t, resource, string(content))
+
+	output, err := dr.readOutput("exec")
+	require.NoError(t, err)
+	assert.Equal(t, "resources {\n}\n", output)
+}
+
+func TestRequestResolverProjectPaths(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	fs := afero.NewOsFs()
+	logger := logging.NewTestLogger()
+	base := &DependencyResolver{
+		Fs:            fs,
+		Logger:        logger,
+		AgentDir:      filepath.Join(root, "agent"),
+		ActionDir:     filepath.Join(root, "action"),
+		WorkflowDir:   filepath.Join(root, "agent", "workflow"),
+		FilesDir:      filepath.Join(root, "action", "files"),
+		APIServerMode: true,
+	}
+	require.NoError(t, fs.MkdirAll(filepath.Join(base.WorkflowDir, "scripts"), 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(base.WorkflowDir, "scripts", "greet.sh"), []byte(`echo "hello $1"`), 0o755))
+	base.template = &requestTemplate{
+		resources:    []ResourceNodeEntry{{ActionID: "greet", File: "resources/greet.pkl"}},
+		dependencies: map[string][]string{},
+		files:        map[string]string{"resources/greet.pkl": "amends \"Resource.pkl\"\n"},
+		outputs:      map[string]string{},
+	}
+
+	dr, err := NewRequestResolver(base, context.Background(), "req1", logger)
+	require.NoError(t, err)
+	require.True(t, dr.Compiled())
+	assert.Equal(t, base.WorkflowDir, dr.projectDir())
+
+	script := "scripts/greet.sh"
+	args := []string{"world"}
+	execBlock := &pklExec.ResourceExec{}
+	require.NoError(t, dr.processExecBlock(context.Background(), "greet", execBlock, &runOptions{
+		Exec: &execOptions{ScriptFile: &script, Args: &args},
+	}))
+	assert.Equal(t, "hello world\n", *execBlock.Stdout)
+
+	outside := "../requests/req1/workflow/resources/greet.pkl"
+	err = dr.processExecBlock(context.Background(), "greet", &pklExec.ResourceExec{}, &runOptions{
+		Exec: &execOptions{ScriptFile: &outside},
+	})
+	require.ErrorContains(t, err, "is outside of the project directory")
+}
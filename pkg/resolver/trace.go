@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kdeps/kdeps/pkg/telemetry"
+	pklRes "github.com/kdeps/schema/gen/resource"
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Outcomes of a trace span.
+const (
+	traceSucceeded       = "succeeded"
+	traceFailed          = "failed"
+	traceSkipped         = "skipped"
+	tracePreflightFailed = "preflightFailed"
+	traceCached          = "cached"
+)
+
+// TraceSpan is the execution of a resource, or of one of the steps of its run block, during a
+// request. The span of a resource has the "resource" step.
+type TraceSpan struct {
+	ActionID   string    `json:"actionID"`
+	Step       string    `json:"step"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs int64     `json:"durationMs"`
+	Outcome    string    `json:"outcome"`
+	Attempts   int       `json:"attempts,omitempty"`
+	ExitCode   *int      `json:"exitCode,omitempty"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// recordSpan ends the span, adds it to the trace of the request and returns it.
+func (dr *DependencyResolver) recordSpan(span TraceSpan, err error) TraceSpan {
+	span.End = time.Now()
+	span.DurationMs = span.End.Sub(span.Start).Milliseconds()
+	if err != nil {
+		if span.Outcome == traceSucceeded {
+			span.Outcome = traceFailed
+		}
+		span.Error = dr.Secrets.Redact(err.Error())
+	}
+
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
+	dr.trace = append(dr.trace, span)
+	return span
+}
+
+// recordStepSpan adds the span of a step to the trace, with the attempts it needed and the exit
+// code or HTTP status code it ended with, and returns the recorded span.
+func (dr *DependencyResolver) recordStepSpan(span TraceSpan, runBlock *pklRes.ResourceAction, err error) TraceSpan {
+	dr.outputMu.Lock()
+	span.Attempts = 1
+	if status, ok := dr.statuses[span.ActionID]; ok {
+		span.Attempts, span.StatusCode = status.attempts, status.statusCode
+	}
+	dr.outputMu.Unlock()
+
+	switch span.Step {
+	case "exec":
+		span.ExitCode = runBlock.Exec.ExitCode
+	case "python":
+		span.ExitCode = runBlock.Python.ExitCode
+	}
+
+	return dr.recordSpan(span, err)
+}
+
+// endOtelSpan ends the OpenTelemetry span of a step with the outcome of its trace span.
+func endOtelSpan(otelSpan trace.Span, span TraceSpan, err error) {
+	otelSpan.SetAttributes(attribute.String("kdeps.outcome", span.Outcome), attribute.Int("kdeps.attempts", span.Attempts))
+	if span.ExitCode != nil {
+		otelSpan.SetAttributes(attribute.Int("process.exit.code", *span.ExitCode))
+	}
+	if span.StatusCode != 0 {
+		otelSpan.SetAttributes(attribute.Int("http.response.status_code", span.StatusCode))
+	}
+	telemetry.End(otelSpan, err)
+}
+
+// Trace returns the spans recorded for the request, ordered by start time.
+func (dr *DependencyResolver) Trace() []TraceSpan {
+	dr.outputMu.Lock()
+	defer dr.outputMu.Unlock()
+
+	trace := append([]TraceSpan{}, dr.trace...)
+	sort.SliceStable(trace, func(i, j int) bool {
+		return trace[i].Start.Before(trace[j].Start)
+	})
+	return trace
+}
+
+// TraceFile returns the file the trace of the request is written to: in the traceDir of the
+// workflow, or in the traces directory of the agent, so that it is kept after the directory of an
+// API request is removed.
+func (dr *DependencyResolver) TraceFile() string {
+	dir := filepath.Join(dr.baseActionDir(), "traces")
+	if traceDir := dr.settings().TraceDir; traceDir != "" {
+		dir = traceDir
+	}
+	return filepath.Join(dir, dr.RequestID+"__trace.json")
+}
+
+// writeTrace writes the trace of the request to TraceFile.
+func (dr *DependencyResolver) writeTrace() error {
+	content, err := json.MarshalIndent(dr.Trace(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trace: %w", err)
+	}
+	if err := dr.Fs.MkdirAll(filepath.Dir(dr.TraceFile()), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for trace: %w", err)
+	}
+	if err := afero.WriteFile(dr.Fs, dr.TraceFile(), content, 0o644); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
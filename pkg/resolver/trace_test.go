@@ -0,0 +1,79 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kdeps/kdeps/pkg/environment"
+	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/workflow"
+	pklExec "github.com/kdeps/schema/gen/exec"
+	pklRes "github.com/kdeps/schema/gen/resource"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	dr := newTestResolver(t)
+	dr.ResponseTargetFile = "/action/api/req__response.json"
+	dr.statuses = map[string]*resourceStatus{"@agent/run:1.0.0": {attempts: 3}}
+	start := time.Now()
+	exitCode := 2
+	runBlock := &pklRes.ResourceAction{Exec: &pklExec.ResourceExec{ExitCode: &exitCode}}
+
+	dr.recordStepSpan(TraceSpan{ActionID: "@agent/run:1.0.0", Step: "exec", Start: start.Add(time.Second), Outcome: traceSucceeded}, runBlock, errors.New("process exited with code 2"))
+	dr.recordSpan(TraceSpan{ActionID: "@agent/check:1.0.0", Step: "resource", Start: start, Outcome: traceSkipped}, nil)
+
+	trace := dr.Trace()
+	require.Len(t, trace, 2)
+	assert.Equal(t, "@agent/check:1.0.0", trace[0].ActionID)
+	assert.Equal(t, traceSkipped, trace[0].Outcome)
+	assert.Zero(t, trace[0].Attempts)
+
+	assert.Equal(t, traceFailed, trace[1].Outcome)
+	assert.Equal(t, 3, trace[1].Attempts)
+	assert.Equal(t, &exitCode, trace[1].ExitCode)
+	assert.Equal(t, "process exited with code 2", trace[1].Error)
+
+	require.NoError(t, dr.writeTrace())
+	content, err := afero.ReadFile(dr.Fs, "/action/traces/req__trace.json")
+	require.NoError(t, err)
+	var persisted []TraceSpan
+	require.NoError(t, json.Unmarshal(content, &persisted))
+	assert.Len(t, persisted, 2)
+}
+
+func TestTraceFileOutlivesRequest(t *testing.T) {
+	t.Parallel()
+
+	logger := logging.NewTestLogger()
+	base := &DependencyResolver{
+		Fs:          afero.NewMemMapFs(),
+		Logger:      logger,
+		AgentDir:    "/agent",
+		ActionDir:   "/agent/action",
+		Environment: &environment.Environment{},
+		template:    &requestTemplate{dependencies: map[string][]string{}},
+	}
+
+	dr, err := NewRequestResolver(base, context.Background(), "req1", logger)
+	require.NoError(t, err)
+	dr.recordSpan(TraceSpan{ActionID: "@agent/run:1.0.0", Step: "resource", Start: time.Now(), Outcome: traceSucceeded}, nil)
+	require.NoError(t, dr.writeTrace())
+	assert.Equal(t, "/agent/action/traces/req1__trace.json", dr.TraceFile())
+
+	// The API server removes the directory of the request once it completes.
+	require.NoError(t, dr.Fs.RemoveAll(dr.ActionDir))
+	exists, err := afero.Exists(dr.Fs, dr.TraceFile())
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	dr.Settings = &workflow.Settings{TraceDir: "/var/log/kdeps"}
+	assert.Equal(t, "/var/log/kdeps/req1__trace.json", dr.TraceFile())
+}
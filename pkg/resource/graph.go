@@ -0,0 +1,164 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GraphNode is a resource of the dependency graph: the actionID a resource file declares, the
+// actionIDs it requires and the handler of its onError block, if any.
+type GraphNode struct {
+	ActionID string
+	File     string
+	Requires []string
+	Handler  string
+}
+
+// ValidateGraph checks the dependency graph of a workflow. It reports duplicate actionIDs, requires
+// entries, error handlers and target actions that do not match any resource, and dependency cycles
+// together with their path. The target actions are the targetActionID of the workflow and those of
+// its API routes, and errorHandler is the errorHandler setting of the workflow, which handles the
+// failures of the resources without an onError handler. Since the outputs of an error handler
+// replace those of the failed resource, a resource depends on its error handler, and a handler that
+// requires a resource whose failures it handles is a cycle. The resources that no target action
+// depends on are returned as warnings, since they are never run.
+func ValidateGraph(nodes []GraphNode, errorHandler string, targetActionIDs ...string) ([]string, error) {
+	var errs []error
+
+	files := make(map[string]string, len(nodes))
+	requires := make(map[string][]string, len(nodes))
+	handlers := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		if file, ok := files[node.ActionID]; ok {
+			errs = append(errs, fmt.Errorf("actionID %s is declared in both %s and %s", node.ActionID, file, node.File))
+			continue
+		}
+		files[node.ActionID] = node.File
+		requires[node.ActionID] = node.Requires
+		handlers[node.ActionID] = node.Handler
+	}
+
+	actionIDs := make([]string, 0, len(requires))
+	for actionID := range requires {
+		actionIDs = append(actionIDs, actionID)
+	}
+	sort.Strings(actionIDs)
+
+	for _, actionID := range actionIDs {
+		for _, dep := range requires[actionID] {
+			if _, ok := requires[dep]; !ok {
+				errs = append(errs, fmt.Errorf("resource %s (%s) requires unknown actionID %s", actionID, files[actionID], dep))
+			}
+		}
+		if handler := handlers[actionID]; handler != "" {
+			if _, ok := requires[handler]; !ok {
+				errs = append(errs, fmt.Errorf("resource %s (%s) has unknown error handler %s", actionID, files[actionID], handler))
+			}
+		}
+	}
+	if _, ok := requires[errorHandler]; errorHandler != "" && !ok {
+		errs = append(errs, fmt.Errorf("errorHandler %s does not match any resource", errorHandler))
+	}
+
+	// The dependencies of a resource are the actions it requires and its error handler.
+	deps := make(map[string][]string, len(requires))
+	for _, actionID := range actionIDs {
+		deps[actionID] = requires[actionID]
+		handler := handlers[actionID]
+		if handler == "" {
+			handler = errorHandler
+		}
+		if handler != "" && handler != actionID {
+			deps[actionID] = append(append([]string{}, requires[actionID]...), handler)
+		}
+	}
+
+	for _, cycle := range findCycles(actionIDs, deps) {
+		errs = append(errs, fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> ")))
+	}
+
+	reachable := make(map[string]bool, len(requires))
+	var visit func(actionID string)
+	visit = func(actionID string) {
+		if reachable[actionID] {
+			return
+		}
+		reachable[actionID] = true
+		for _, dep := range deps[actionID] {
+			visit(dep)
+		}
+	}
+
+	for _, targetActionID := range targetActionIDs {
+		if _, ok := requires[targetActionID]; !ok {
+			errs = append(errs, fmt.Errorf("targetActionID %s does not match any resource", targetActionID))
+			continue
+		}
+		visit(targetActionID)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	var warnings []string
+	for _, actionID := range actionIDs {
+		if !reachable[actionID] {
+			warnings = append(warnings, fmt.Sprintf("resource %s (%s) is not required by any target action and is never run", actionID, files[actionID]))
+		}
+	}
+
+	return warnings, nil
+}
+
+// findCycles returns one path for every dependency cycle, starting and ending with the same
+// actionID.
+func findCycles(actionIDs []string, deps map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := make(map[string]int, len(actionIDs))
+	var (
+		path   []string
+		cycles [][]string
+		visit  func(actionID string)
+	)
+	visit = func(actionID string) {
+		state[actionID] = visiting
+		path = append(path, actionID)
+
+		for _, dep := range deps[actionID] {
+			if _, ok := deps[dep]; !ok {
+				continue
+			}
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == dep {
+						cycle := append(append([]string{}, path[i:]...), dep)
+						cycles = append(cycles, cycle)
+						break
+					}
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[actionID] = done
+	}
+
+	for _, actionID := range actionIDs {
+		if state[actionID] == unvisited {
+			visit(actionID)
+		}
+	}
+
+	return cycles
+}
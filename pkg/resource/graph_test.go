@@ -0,0 +1,144 @@
+package resource_test
+
+import (
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateGraph(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Valid", func(t *testing.T) {
+		t.Parallel()
+		nodes := []resource.GraphNode{
+			{ActionID: "response", File: "response.pkl", Requires: []string{"llm", "fetch"}},
+			{ActionID: "llm", File: "llm.pkl", Requires: []string{"fetch"}},
+			{ActionID: "fetch", File: "fetch.pkl"},
+			{ActionID: "unused", File: "unused.pkl", Requires: []string{"fetch"}},
+		}
+
+		warnings, err := resource.ValidateGraph(nodes, "", "response")
+		require.NoError(t, err)
+		require.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], "unused")
+	})
+
+	t.Run("UnknownTarget", func(t *testing.T) {
+		t.Parallel()
+		_, err := resource.ValidateGraph([]resource.GraphNode{{ActionID: "fetch", File: "fetch.pkl"}}, "", "fecth")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "targetActionID fecth does not match any resource")
+	})
+
+	t.Run("DanglingRequires", func(t *testing.T) {
+		t.Parallel()
+		nodes := []resource.GraphNode{
+			{ActionID: "response", File: "response.pkl", Requires: []string{"@other/fetch:1.0.0"}},
+		}
+
+		_, err := resource.ValidateGraph(nodes, "", "response")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "resource response (response.pkl) requires unknown actionID @other/fetch:1.0.0")
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		t.Parallel()
+		nodes := []resource.GraphNode{
+			{ActionID: "fetch", File: "a.pkl"},
+			{ActionID: "fetch", File: "b.pkl"},
+		}
+
+		_, err := resource.ValidateGraph(nodes, "", "fetch")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "actionID fetch is declared in both a.pkl and b.pkl")
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		t.Parallel()
+		nodes := []resource.GraphNode{
+			{ActionID: "a", File: "a.pkl", Requires: []string{"b"}},
+			{ActionID: "b", File: "b.pkl", Requires: []string{"c"}},
+			{ActionID: "c", File: "c.pkl", Requires: []string{"a"}},
+		}
+
+		_, err := resource.ValidateGraph(nodes, "", "a")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "dependency cycle: a -> b -> c -> a")
+	})
+}
+
+func TestValidateGraphRouteTargets(t *testing.T) {
+	t.Parallel()
+
+	nodes := []resource.GraphNode{
+		{ActionID: "chat", File: "chat.pkl", Requires: []string{"fetch"}},
+		{ActionID: "summarize", File: "summarize.pkl", Requires: []string{"fetch"}},
+		{ActionID: "fetch", File: "fetch.pkl"},
+	}
+
+	warnings, err := resource.ValidateGraph(nodes, "", "chat", "summarize")
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, err = resource.ValidateGraph(nodes, "", "chat", "sumarize")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "targetActionID sumarize does not match any resource")
+}
+
+func TestValidateGraphErrorHandlers(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Valid", func(t *testing.T) {
+		t.Parallel()
+		nodes := []resource.GraphNode{
+			{ActionID: "response", File: "response.pkl", Requires: []string{"fetch"}},
+			{ActionID: "fetch", File: "fetch.pkl", Handler: "fetchFallback"},
+			{ActionID: "fetchFallback", File: "fetchFallback.pkl"},
+			{ActionID: "errorResponse", File: "errorResponse.pkl"},
+		}
+
+		warnings, err := resource.ValidateGraph(nodes, "errorResponse", "response")
+		require.NoError(t, err)
+		assert.Empty(t, warnings)
+	})
+
+	t.Run("UnknownHandler", func(t *testing.T) {
+		t.Parallel()
+		nodes := []resource.GraphNode{
+			{ActionID: "fetch", File: "fetch.pkl", Handler: "fallback"},
+		}
+
+		_, err := resource.ValidateGraph(nodes, "errorRespnse", "fetch")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "resource fetch (fetch.pkl) has unknown error handler fallback")
+		assert.Contains(t, err.Error(), "errorHandler errorRespnse does not match any resource")
+	})
+
+	t.Run("HandlerCycle", func(t *testing.T) {
+		t.Parallel()
+		nodes := []resource.GraphNode{
+			{ActionID: "fetch", File: "fetch.pkl", Handler: "fallback"},
+			{ActionID: "fallback", File: "fallback.pkl", Requires: []string{"fetch"}},
+		}
+
+		_, err := resource.ValidateGraph(nodes, "", "fetch")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "dependency cycle: fallback -> fetch -> fallback")
+	})
+
+	t.Run("ErrorHandlerCycle", func(t *testing.T) {
+		t.Parallel()
+		nodes := []resource.GraphNode{
+			{ActionID: "response", File: "response.pkl", Requires: []string{"fetch"}},
+			{ActionID: "fetch", File: "fetch.pkl"},
+			{ActionID: "errorResponse", File: "errorResponse.pkl", Requires: []string{"fetch"}},
+		}
+
+		_, err := resource.ValidateGraph(nodes, "errorResponse", "response")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "dependency cycle: errorResponse -> fetch -> errorResponse")
+	})
+}
@@ -3,8 +3,11 @@ package resource
 import (
 	"context"
 	"fmt"
+	"os"
 
+	"github.com/apple/pkl-go/pkl"
 	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/schema"
 	pklRes "github.com/kdeps/schema/gen/resource"
 )
 
@@ -13,8 +16,8 @@ func LoadResource(ctx context.Context, resourceFile string, logger *logging.Logg
 	// Log additional info before reading the resource
 	logger.Debug("reading resource file", "resource-file", resourceFile)
 
-	// Attempt to load the resource from the file path
-	res, err := pklRes.LoadFromPath(ctx, resourceFile)
+	// Attempt to load the resource from the file path, against the vendored schema
+	res, err := loadFromPath(ctx, resourceFile)
 	if err != nil {
 		// Log the error with debug info if something goes wrong
 		logger.Error("error reading resource file", "resource-file", resourceFile, "error", err)
@@ -26,3 +29,20 @@ func LoadResource(ctx context.Context, resourceFile string, logger *logging.Logg
 
 	return res, nil
 }
+
+// loadFromPath loads the resource file like pklRes.LoadFromPath does, except that it amends the
+// vendored schema, which declares the run options of kdeps.
+func loadFromPath(ctx context.Context, resourceFile string) (*pklRes.Resource, error) {
+	content, err := os.ReadFile(resourceFile)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluator, err := pkl.NewEvaluator(ctx, pkl.PreconfiguredOptions, schema.WithVendoredSchema)
+	if err != nil {
+		return nil, err
+	}
+	defer evaluator.Close()
+
+	return pklRes.Load(ctx, evaluator, schema.Source(resourceFile, content))
+}
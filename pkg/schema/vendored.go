@@ -0,0 +1,50 @@
+package schema
+
+import (
+	"embed"
+	"io/fs"
+	"regexp"
+
+	"github.com/apple/pkl-go/pkl"
+)
+
+// vendoredVersion is the version of the schema the modules in the pkl directory are copied from.
+// On top of the published modules, they declare the options kdeps reads from resources, such as
+// run.retry, so that resources can set them, and their accessors, such as exec.stdout, return the
+// stored values as they are instead of decoding the ones that look base64 encoded.
+const vendoredVersion = "0.2.7"
+
+// vendoredScheme is the URI scheme under which the vendored modules are read.
+const vendoredScheme = "kdeps-schema"
+
+//go:embed pkl/*.pkl
+var vendoredFiles embed.FS
+
+// vendoredHeaderPattern matches the amends or extends clause of a module of the vendored schema
+// version, up to the name of the module.
+var vendoredHeaderPattern = regexp.MustCompile(`(?m)^(\s*(?:amends|extends)\s+")package://schema\.kdeps\.com/core@` + regexp.QuoteMeta(vendoredVersion) + `#/`)
+
+// WithVendoredSchema lets the evaluated modules amend the vendored schema modules.
+func WithVendoredSchema(opts *pkl.EvaluatorOptions) {
+	files, err := fs.Sub(vendoredFiles, "pkl")
+	if err != nil {
+		panic(err)
+	}
+	pkl.WithFs(files, vendoredScheme)(opts)
+}
+
+// VendoredModule returns the URI of the vendored schema module with the given name, such as
+// Exec.pkl, which modules evaluated with WithVendoredSchema can extend.
+func VendoredModule(name string) string {
+	return vendoredScheme + ":/" + name
+}
+
+// Source returns the source of the Pkl file at path with the given content. A file that amends
+// or extends a module of the vendored schema version is evaluated against the vendored module,
+// so that it can set the options kdeps declares on top of the published schema. The file keeps
+// its own URI, so relative imports and error messages still refer to it.
+func Source(path string, content []byte) *pkl.ModuleSource {
+	source := pkl.FileSource(path)
+	source.Contents = vendoredHeaderPattern.ReplaceAllString(string(content), "${1}"+vendoredScheme+":/")
+	return source
+}
@@ -0,0 +1,60 @@
+package schema
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVendoredVersion(t *testing.T) {
+	t.Parallel()
+
+	// The vendored modules must be copied again whenever the pinned schema version changes.
+	assert.Equal(t, specifiedVersion, vendoredVersion)
+}
+
+func TestWithVendoredSchema(t *testing.T) {
+	t.Parallel()
+
+	var opts pkl.EvaluatorOptions
+	WithVendoredSchema(&opts)
+	require.Len(t, opts.ModuleReaders, 1)
+	assert.Equal(t, vendoredScheme, opts.ModuleReaders[0].Scheme())
+	assert.Contains(t, opts.AllowedModules, vendoredScheme+":")
+
+	resource, err := fs.ReadFile(vendoredFiles, "pkl/Resource.pkl")
+	require.NoError(t, err)
+	assert.Contains(t, string(resource), "hidden retry: RetryPolicy?")
+
+	apiServer, err := fs.ReadFile(vendoredFiles, "pkl/APIServer.pkl")
+	require.NoError(t, err)
+	assert.Contains(t, string(apiServer), "hidden targetActionID: String?")
+
+	workflow, err := fs.ReadFile(vendoredFiles, "pkl/Workflow.pkl")
+	require.NoError(t, err)
+	assert.Contains(t, string(workflow), "hidden maxParallelism: Int?")
+	assert.Contains(t, string(workflow), "hidden secrets: Listing<String>?")
+
+	exec, err := fs.ReadFile(vendoredFiles, "pkl/Exec.pkl")
+	require.NoError(t, err)
+	assert.NotContains(t, string(exec), "base64Decoded")
+	assert.Equal(t, "kdeps-schema:/Exec.pkl", VendoredModule("Exec.pkl"))
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+
+	content := "amends \"package://schema.kdeps.com/core@" + vendoredVersion + "#/Resource.pkl\"\n" +
+		"import \"package://schema.kdeps.com/core@" + vendoredVersion + "#/Document.pkl\" as document\n"
+	source := Source("/agent/workflow/resources/fetch.pkl", []byte(content))
+
+	assert.Equal(t, "file:///agent/workflow/resources/fetch.pkl", source.Uri.String())
+	assert.Equal(t, "amends \"kdeps-schema:/Resource.pkl\"\n"+
+		"import \"package://schema.kdeps.com/core@"+vendoredVersion+"#/Document.pkl\" as document\n", source.Contents)
+
+	other := "amends \"package://schema.kdeps.com/core@0.1.0#/Resource.pkl\"\n"
+	assert.Equal(t, other, Source("/agent/workflow/resources/fetch.pkl", []byte(other)).Contents)
+}
@@ -0,0 +1,237 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kdeps/kdeps/pkg/environment"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Redacted replaces the values of the secrets in logs, outputs and error messages.
+const Redacted = "[REDACTED]"
+
+// minRedactedLength is the length under which a secret value is not redacted, since replacing
+// every occurrence of a one or two character value would garble the text without hiding anything.
+const minRedactedLength = 4
+
+// referencePattern matches a secret reference, such as ${secret:API_KEY}.
+var referencePattern = regexp.MustCompile(`\$\{secret:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Store holds the values of the secrets declared by the workflow. A nil Store holds no secrets.
+type Store struct {
+	values   map[string]string
+	replacer *strings.Replacer
+}
+
+// Load reads the secrets with the given names, which the workflow declares in its secrets
+// setting. The value of a secret is read from the file with its name in KDEPS_SECRETS_DIR, the
+// environment variable with its name, or the encrypted store at KDEPS_SECRETS_STORE, in that
+// order. The secrets read from the environment, and the key of the store, are removed from the
+// environment, so that only the steps that reference a secret get its value. Declared secrets
+// without a value are reported in missing.
+func Load(fs afero.Fs, env *environment.Environment, names []string) (store *Store, missing []string, err error) {
+	// The key is removed even when the workflow declares no secrets, so that no step inherits it.
+	key := env.SecretsKey
+	os.Unsetenv("KDEPS_SECRETS_KEY")
+	if len(names) == 0 {
+		return nil, nil, nil
+	}
+
+	var stored map[string]string
+	if exists, _ := afero.Exists(fs, env.SecretsStore); exists {
+		if key == "" {
+			return nil, nil, fmt.Errorf("secret store %s exists but KDEPS_SECRETS_KEY is not set", env.SecretsStore)
+		}
+		if stored, err = ReadStore(fs, env.SecretsStore, key); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		if content, err := afero.ReadFile(fs, filepath.Join(env.SecretsDir, name)); err == nil {
+			values[name] = strings.TrimRight(string(content), "\r\n")
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			values[name] = value
+			os.Unsetenv(name)
+			continue
+		}
+		if value, ok := stored[name]; ok {
+			values[name] = value
+			continue
+		}
+		missing = append(missing, name)
+	}
+
+	return New(values), missing, nil
+}
+
+// New returns a store holding the given secret values.
+func New(values map[string]string) *Store {
+	store := &Store{values: values}
+
+	// Longer values are replaced first, so that a secret containing another one is fully hidden.
+	secretValues := make([]string, 0, len(values))
+	for _, value := range values {
+		if len(value) >= minRedactedLength {
+			secretValues = append(secretValues, value)
+		}
+	}
+	sort.Slice(secretValues, func(i, j int) bool { return len(secretValues[i]) > len(secretValues[j]) })
+
+	oldnew := make([]string, 0, 2*len(secretValues))
+	for _, value := range secretValues {
+		oldnew = append(oldnew, value, Redacted)
+	}
+	store.replacer = strings.NewReplacer(oldnew...)
+	return store
+}
+
+// Expand replaces the secret references of value, such as ${secret:API_KEY}, with the values of
+// the secrets. It fails when a referenced secret is not available.
+func (s *Store) Expand(value string) (string, error) {
+	var missing []string
+	expanded := referencePattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := referencePattern.FindStringSubmatch(ref)[1]
+		if s != nil {
+			if secret, ok := s.values[name]; ok {
+				return secret
+			}
+		}
+		missing = append(missing, name)
+		return ref
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("secret %s is not available", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// Redact replaces the values of the secrets in text with Redacted.
+func (s *Store) Redact(text string) string {
+	if s == nil || len(s.values) == 0 {
+		return text
+	}
+	return s.replacer.Replace(text)
+}
+
+// Names returns the sorted names of the available secrets.
+func (s *Store) Names() []string {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, 0, len(s.values))
+	for name := range s.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sealedStore is the content of an encrypted secret store file.
+type sealedStore struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// storeKey derives the AES-256 key of a store from its passphrase.
+func storeKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// ReadStore decrypts the secret store at path with passphrase.
+func ReadStore(fs afero.Fs, path, passphrase string) (map[string]string, error) {
+	content, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret store: %w", err)
+	}
+
+	var sealed sealedStore
+	if err := json.Unmarshal(content, &sealed); err != nil {
+		return nil, fmt.Errorf("failed to parse secret store: %w", err)
+	}
+
+	gcm, err := newGCM(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("failed to decrypt secret store: invalid nonce")
+	}
+	plaintext, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt secret store: wrong key or corrupted store")
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse secret store: %w", err)
+	}
+	return values, nil
+}
+
+// WriteStore encrypts values with passphrase into the secret store at path.
+func WriteStore(fs afero.Fs, path, passphrase string, values map[string]string) error {
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets: %w", err)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	content, err := json.Marshal(sealedStore{Salt: salt, Nonce: nonce, Ciphertext: gcm.Seal(nil, nonce, plaintext, nil)})
+	if err != nil {
+		return fmt.Errorf("failed to encode secret store: %w", err)
+	}
+	if err := fs.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create secret store directory: %w", err)
+	}
+	if err := afero.WriteFile(fs, path, content, 0o600); err != nil {
+		return fmt.Errorf("failed to write secret store: %w", err)
+	}
+	return nil
+}
+
+// newGCM returns the AES-GCM cipher of a store with the given passphrase and salt.
+//
+//nolint:ireturn
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := storeKey(passphrase, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive secret store key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return gcm, nil
+}
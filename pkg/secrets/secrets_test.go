@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"os"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/environment"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	// Load reads and unsets environment variables, so it does not run in parallel.
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/run/secrets/FROM_FILE", []byte("file-secret\n"), 0o600))
+	require.NoError(t, WriteStore(fs, "/agent/secrets.enc", "passphrase", map[string]string{
+		"FROM_STORE": "store-secret",
+		"FROM_FILE":  "shadowed",
+	}))
+	t.Setenv("FROM_ENV", "env-secret")
+
+	names := []string{"FROM_FILE", "FROM_ENV", "FROM_STORE", "MISSING"}
+	env := &environment.Environment{
+		SecretsDir:   "/run/secrets",
+		SecretsStore: "/agent/secrets.enc",
+		SecretsKey:   "passphrase",
+	}
+	store, missing, err := Load(fs, env, names)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"MISSING"}, missing)
+	assert.Equal(t, []string{"FROM_ENV", "FROM_FILE", "FROM_STORE"}, store.Names())
+
+	expanded, err := store.Expand("${secret:FROM_FILE}/${secret:FROM_ENV}/${secret:FROM_STORE}")
+	require.NoError(t, err)
+	assert.Equal(t, "file-secret/env-secret/store-secret", expanded)
+
+	_, found := os.LookupEnv("FROM_ENV")
+	assert.False(t, found, "secrets read from the environment are removed from it")
+
+	t.Run("WrongKey", func(t *testing.T) {
+		env := *env
+		env.SecretsKey = "wrong"
+		_, _, err := Load(fs, &env, names)
+		assert.ErrorContains(t, err, "wrong key")
+	})
+
+	t.Run("NoSecrets", func(t *testing.T) {
+		t.Setenv("KDEPS_SECRETS_KEY", "passphrase")
+		store, missing, err := Load(fs, &environment.Environment{SecretsKey: "passphrase"}, nil)
+		require.NoError(t, err)
+		assert.Nil(t, store)
+		assert.Empty(t, missing)
+
+		_, found := os.LookupEnv("KDEPS_SECRETS_KEY")
+		assert.False(t, found, "the key of the store is removed from the environment")
+	})
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	store := New(map[string]string{"TOKEN": "s3cr3t-token", "PREFIX": "s3cr3t", "PIN": "42"})
+
+	t.Run("Expand", func(t *testing.T) {
+		t.Parallel()
+		expanded, err := store.Expand("Bearer ${secret:TOKEN}, $HOME, ${secret}")
+		require.NoError(t, err)
+		assert.Equal(t, "Bearer s3cr3t-token, $HOME, ${secret}", expanded)
+
+		_, err = store.Expand("${secret:UNKNOWN}")
+		assert.ErrorContains(t, err, "secret UNKNOWN is not available")
+
+		var nilStore *Store
+		_, err = nilStore.Expand("${secret:TOKEN}")
+		assert.Error(t, err)
+	})
+
+	t.Run("Redact", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, "token=[REDACTED] prefix=[REDACTED] pin=42", store.Redact("token=s3cr3t-token prefix=s3cr3t pin=42"))
+
+		var nilStore *Store
+		assert.Equal(t, "s3cr3t", nilStore.Redact("s3cr3t"))
+	})
+}
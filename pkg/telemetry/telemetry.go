@@ -0,0 +1,112 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/kdeps/kdeps/pkg/workflow"
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters selected by the telemetry settings of the workflow.
+const (
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+)
+
+const instrumentationName = "github.com/kdeps/kdeps"
+
+// Setup installs the tracer provider of the exporter selected by the workflow settings and the W3C
+// trace context propagator. Tracing stays disabled when no exporter is selected. The returned
+// function flushes the pending spans and shuts the tracer provider down.
+func Setup(ctx context.Context, fs afero.Fs, settings workflow.TelemetrySettings, serviceName, serviceVersion string) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	var processor sdktrace.SpanProcessor
+	closeExporter := noop
+	switch strings.ToLower(strings.TrimSpace(settings.Exporter)) {
+	case "":
+		return noop, nil
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if settings.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(settings.Endpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return noop, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	case ExporterFile:
+		file, err := fs.OpenFile(settings.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return noop, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return noop, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		// Spans are written as they end, so that the file is complete even if the agent is killed.
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+		closeExporter = func(context.Context) error { return file.Close() }
+	default:
+		return noop, fmt.Errorf("unsupported telemetry exporter %q: use %s or %s", settings.Exporter, ExporterOTLP, ExporterFile)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", serviceVersion),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		if err := provider.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shut down tracer provider: %w", err)
+		}
+		return closeExporter(ctx)
+	}, nil
+}
+
+// Tracer returns the tracer of kdeps. It does not record anything until Setup installs a tracer
+// provider.
+//
+//nolint:ireturn
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// End ends span, marking it as failed when err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Extract returns ctx with the trace context of the headers of an incoming request.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject adds the trace context of ctx to the headers of an outgoing request.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
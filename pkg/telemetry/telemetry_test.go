@@ -0,0 +1,62 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/kdeps/kdeps/pkg/workflow"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	// Setup installs the global tracer provider, so the subtests run one at a time.
+	ctx := context.Background()
+
+	t.Run("Disabled", func(t *testing.T) {
+		shutdown, err := Setup(ctx, afero.NewMemMapFs(), workflow.TelemetrySettings{}, "agent", "1.0.0")
+		require.NoError(t, err)
+		require.NoError(t, shutdown(ctx))
+	})
+
+	t.Run("UnsupportedExporter", func(t *testing.T) {
+		_, err := Setup(ctx, afero.NewMemMapFs(), workflow.TelemetrySettings{Exporter: "zipkin"}, "agent", "1.0.0")
+		assert.ErrorContains(t, err, "unsupported telemetry exporter")
+	})
+
+	t.Run("FileExporter", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		settings := workflow.TelemetrySettings{Exporter: ExporterFile, File: "/agent/traces.jsonl"}
+		shutdown, err := Setup(ctx, fs, settings, "agent", "1.0.0")
+		require.NoError(t, err)
+
+		parent := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{1},
+			TraceFlags: trace.FlagsSampled,
+			Remote:     true,
+		})
+		incoming := http.Header{}
+		Inject(trace.ContextWithRemoteSpanContext(ctx, parent), incoming)
+		assert.Equal(t, "00-01000000000000000000000000000000-0100000000000000-01", incoming.Get("traceparent"))
+
+		stepCtx, span := Tracer().Start(Extract(ctx, incoming), "kdeps.exec")
+		assert.Equal(t, parent.TraceID(), span.SpanContext().TraceID())
+
+		outgoing := http.Header{}
+		Inject(stepCtx, outgoing)
+		assert.Contains(t, outgoing.Get("traceparent"), parent.TraceID().String())
+
+		End(span, errors.New("exit status 1"))
+		require.NoError(t, shutdown(ctx))
+
+		content, err := afero.ReadFile(fs, settings.File)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `"Name":"kdeps.exec"`)
+		assert.Contains(t, string(content), "exit status 1")
+	})
+}
@@ -30,3 +30,9 @@ func EncodePklSlice(s *[]string) string {
 	builder.WriteString("    }\n")
 	return builder.String()
 }
+
+// PklString returns s as a Pkl string literal.
+func PklString(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + replacer.Replace(s) + `"`
+}
@@ -10,8 +10,7 @@ func FormatRequestHeaders(headers map[string][]string) string {
 	var headersLines []string
 	for name, values := range headers {
 		for _, value := range values {
-			encodedValue := EncodeBase64String(strings.TrimSpace(value))
-			headersLines = append(headersLines, fmt.Sprintf(`["%s"] = "%s"`, name, encodedValue))
+			headersLines = append(headersLines, fmt.Sprintf(`[%s] = %s`, PklString(name), PklString(strings.TrimSpace(value))))
 		}
 	}
 
@@ -23,8 +22,7 @@ func FormatRequestParams(params map[string][]string) string {
 	var paramsLines []string
 	for param, values := range params {
 		for _, value := range values {
-			encodedValue := EncodeBase64String(strings.TrimSpace(value))
-			paramsLines = append(paramsLines, fmt.Sprintf(`["%s"] = "%s"`, param, encodedValue))
+			paramsLines = append(paramsLines, fmt.Sprintf(`[%s] = %s`, PklString(param), PklString(strings.TrimSpace(value))))
 		}
 	}
 	return "params {\n" + strings.Join(paramsLines, "\n") + "\n}"
@@ -0,0 +1,12 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPklString(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, `"a\"b\\c\n\\(d)"`, PklString("a\"b\\c\n\\(d)"))
+}
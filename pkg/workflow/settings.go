@@ -0,0 +1,243 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/schema"
+)
+
+// Settings holds the settings kdeps declares on the workflow on top of the published schema, see
+// the vendored Workflow.pkl. A setting the workflow does not set keeps its default, also when the
+// workflow is written against another schema version.
+type Settings struct {
+	// MaxParallelism is the maximum number of independent resources run at the same time. 0 means
+	// no limit.
+	MaxParallelism int
+	// FailOnNonZeroExit fails the exec and python steps that exit with a non-zero code.
+	FailOnNonZeroExit bool
+	// Retry is the retry policy of the resources that do not set their own.
+	Retry RetrySettings
+	// Cache selects the resources whose outputs are cached across requests.
+	Cache CacheSettings
+	// Requests controls how the API server admits requests.
+	Requests RequestSettings
+	// ErrorHandler is the actionID of the resource that handles the failures of the resources
+	// without an onError handler.
+	ErrorHandler string
+	// MaxOutputSize is the maximum size (in bytes) of a stored resource output. 0 means no limit.
+	MaxOutputSize int
+	// TraceDir is the directory the execution traces of the requests are written to. Empty means
+	// the traces directory of the action directory of the agent.
+	TraceDir string
+	// Telemetry selects the OpenTelemetry exporter of the traces.
+	Telemetry TelemetrySettings
+	// Metrics controls the Prometheus metrics of the API server.
+	Metrics MetricsSettings
+	// Secrets lists the names of the secrets the resources reference.
+	Secrets []string
+}
+
+// RetrySettings is the default retry policy of the resources.
+type RetrySettings struct {
+	MaxAttempts int
+	Backoff     string
+	// Delay and MaxDelay are in seconds.
+	Delay    int
+	MaxDelay int
+	// RetryOn lists the failures that are retried. Empty retries every failure.
+	RetryOn []string
+}
+
+// CacheSettings selects the resources whose outputs are cached.
+type CacheSettings struct {
+	Resources []string
+	// TTL is how long (in seconds) a cached output is reused.
+	TTL int
+}
+
+// RequestSettings controls how the API server admits requests.
+type RequestSettings struct {
+	// MaxConcurrent is the maximum number of requests processed at the same time. 0 means no limit.
+	MaxConcurrent int
+	// QueueTimeout is how long (in seconds) a request waits for a free slot. 0 waits indefinitely.
+	QueueTimeout  int
+	AllowPlan     bool
+	AllowProgress bool
+	AllowTrace    bool
+}
+
+// TelemetrySettings selects the OpenTelemetry exporter of the traces.
+type TelemetrySettings struct {
+	// Exporter is "otlp", "file" or empty, which disables OpenTelemetry.
+	Exporter string
+	Endpoint string
+	File     string
+}
+
+// MetricsSettings controls the Prometheus metrics of the API server.
+type MetricsSettings struct {
+	Enabled bool
+	Path    string
+	// Port is the port of a separate metrics server. 0 serves the metrics on the API server port.
+	Port int
+}
+
+// DefaultSettings returns the settings of a workflow that sets none of them.
+func DefaultSettings() *Settings {
+	return &Settings{
+		Retry:     RetrySettings{MaxAttempts: 1, Backoff: "exponential", Delay: 1, MaxDelay: 30},
+		Cache:     CacheSettings{TTL: 3600},
+		Requests:  RequestSettings{QueueTimeout: 60},
+		Telemetry: TelemetrySettings{File: "/agent/traces.jsonl"},
+		Metrics:   MetricsSettings{Path: "/metrics"},
+	}
+}
+
+// The blocks below mirror the classes of the vendored Workflow.pkl, whose properties are all
+// optional.
+
+type retryBlock struct {
+	MaxAttempts *int      `pkl:"maxAttempts"`
+	Backoff     *string   `pkl:"backoff"`
+	Delay       *int      `pkl:"delay"`
+	MaxDelay    *int      `pkl:"maxDelay"`
+	RetryOn     *[]string `pkl:"retryOn"`
+}
+
+type cacheBlock struct {
+	Resources *[]string `pkl:"resources"`
+	TTL       *int      `pkl:"ttl"`
+}
+
+type requestsBlock struct {
+	MaxConcurrent *int  `pkl:"maxConcurrent"`
+	QueueTimeout  *int  `pkl:"queueTimeout"`
+	AllowPlan     *bool `pkl:"allowPlan"`
+	AllowProgress *bool `pkl:"allowProgress"`
+	AllowTrace    *bool `pkl:"allowTrace"`
+}
+
+type telemetryBlock struct {
+	Exporter *string `pkl:"exporter"`
+	Endpoint *string `pkl:"endpoint"`
+	File     *string `pkl:"file"`
+}
+
+type metricsBlock struct {
+	Path *string `pkl:"path"`
+	Port *int    `pkl:"port"`
+}
+
+// LoadSettings reads the settings of a workflow file.
+func LoadSettings(ctx context.Context, workflowFile string, logger *logging.Logger) (*Settings, error) {
+	logger.Debug("reading workflow settings", "workflow-file", workflowFile)
+
+	content, err := os.ReadFile(workflowFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading workflow settings '%s': %w", workflowFile, err)
+	}
+
+	evaluator, err := pkl.NewEvaluator(ctx, pkl.PreconfiguredOptions, schema.WithVendoredSchema)
+	if err != nil {
+		return nil, err
+	}
+	defer evaluator.Close()
+
+	settings, err := ReadSettings(ctx, evaluator, schema.Source(workflowFile, content))
+	if err != nil {
+		return nil, fmt.Errorf("error reading workflow settings '%s': %w", workflowFile, err)
+	}
+	return settings, nil
+}
+
+// ReadSettings reads the settings of the workflow module with the given evaluator. A setting that
+// is not set keeps its default, and a setting that fails to evaluate is an error.
+func ReadSettings(ctx context.Context, evaluator pkl.Evaluator, source *pkl.ModuleSource) (*Settings, error) {
+	settings := DefaultSettings()
+	read := func(name string, out any) error {
+		expr := fmt.Sprintf("module.getPropertyOrNull(%q)", name)
+		if err := evaluator.EvaluateExpression(ctx, source, expr, out); err != nil {
+			return fmt.Errorf("invalid workflow setting %s: %w", name, err)
+		}
+		return nil
+	}
+
+	var (
+		maxParallelism, maxOutputSize *int
+		failOnNonZeroExit             *bool
+		errorHandler, traceDir        *string
+		retry                         *retryBlock
+		cache                         *cacheBlock
+		requests                      *requestsBlock
+		telemetry                     *telemetryBlock
+		metrics                       *metricsBlock
+		secrets                       *[]string
+	)
+	for _, setting := range []struct {
+		name string
+		out  any
+	}{
+		{"maxParallelism", &maxParallelism},
+		{"failOnNonZeroExit", &failOnNonZeroExit},
+		{"retry", &retry},
+		{"cache", &cache},
+		{"requests", &requests},
+		{"errorHandler", &errorHandler},
+		{"maxOutputSize", &maxOutputSize},
+		{"traceDir", &traceDir},
+		{"telemetry", &telemetry},
+		{"metrics", &metrics},
+		{"secrets", &secrets},
+	} {
+		if err := read(setting.name, setting.out); err != nil {
+			return nil, err
+		}
+	}
+
+	set(&settings.MaxParallelism, maxParallelism)
+	set(&settings.FailOnNonZeroExit, failOnNonZeroExit)
+	set(&settings.ErrorHandler, errorHandler)
+	set(&settings.MaxOutputSize, maxOutputSize)
+	set(&settings.TraceDir, traceDir)
+	set(&settings.Secrets, secrets)
+	if retry != nil {
+		set(&settings.Retry.MaxAttempts, retry.MaxAttempts)
+		set(&settings.Retry.Backoff, retry.Backoff)
+		set(&settings.Retry.Delay, retry.Delay)
+		set(&settings.Retry.MaxDelay, retry.MaxDelay)
+		set(&settings.Retry.RetryOn, retry.RetryOn)
+	}
+	if cache != nil {
+		set(&settings.Cache.Resources, cache.Resources)
+		set(&settings.Cache.TTL, cache.TTL)
+	}
+	if requests != nil {
+		set(&settings.Requests.MaxConcurrent, requests.MaxConcurrent)
+		set(&settings.Requests.QueueTimeout, requests.QueueTimeout)
+		set(&settings.Requests.AllowPlan, requests.AllowPlan)
+		set(&settings.Requests.AllowProgress, requests.AllowProgress)
+		set(&settings.Requests.AllowTrace, requests.AllowTrace)
+	}
+	if telemetry != nil {
+		set(&settings.Telemetry.Exporter, telemetry.Exporter)
+		set(&settings.Telemetry.Endpoint, telemetry.Endpoint)
+		set(&settings.Telemetry.File, telemetry.File)
+	}
+	if metrics != nil {
+		settings.Metrics.Enabled = true
+		set(&settings.Metrics.Path, metrics.Path)
+		set(&settings.Metrics.Port, metrics.Port)
+	}
+	return settings, nil
+}
+
+// set replaces the default value of a setting with the value the workflow sets, if any.
+func set[T any](setting *T, value *T) {
+	if value != nil {
+		*setting = *value
+	}
+}
@@ -3,8 +3,11 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"os"
 
+	"github.com/apple/pkl-go/pkl"
 	"github.com/kdeps/kdeps/pkg/logging"
+	"github.com/kdeps/kdeps/pkg/schema"
 	pklWf "github.com/kdeps/schema/gen/workflow"
 )
 
@@ -14,7 +17,7 @@ import (
 func LoadWorkflow(ctx context.Context, workflowFile string, logger *logging.Logger) (pklWf.Workflow, error) {
 	logger.Debug("reading workflow file", "workflow-file", workflowFile)
 
-	wf, err := pklWf.LoadFromPath(ctx, workflowFile)
+	wf, err := loadFromPath(ctx, workflowFile)
 	if err != nil {
 		logger.Error("error reading workflow file", "workflow-file", workflowFile, "error", err)
 		return nil, fmt.Errorf("error reading workflow file '%s': %w", workflowFile, err)
@@ -23,3 +26,22 @@ func LoadWorkflow(ctx context.Context, workflowFile string, logger *logging.Logg
 	logger.Debug("successfully read and parsed workflow file", "workflow-file", workflowFile)
 	return wf, nil
 }
+
+// loadFromPath loads the workflow file like pklWf.LoadFromPath does, except that it amends the
+// vendored schema, which declares the targetActionID of the API server routes.
+//
+//nolint:ireturn
+func loadFromPath(ctx context.Context, workflowFile string) (pklWf.Workflow, error) {
+	content, err := os.ReadFile(workflowFile)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluator, err := pkl.NewEvaluator(ctx, pkl.PreconfiguredOptions, schema.WithVendoredSchema)
+	if err != nil {
+		return nil, err
+	}
+	defer evaluator.Close()
+
+	return pklWf.Load(ctx, evaluator, schema.Source(workflowFile, content))
+}